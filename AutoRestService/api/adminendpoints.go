@@ -0,0 +1,201 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/config"
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+/*
+BuildInfo version information of this build, set by the main package
+*/
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+}
+
+// Build the build info of this service
+var Build BuildInfo
+
+var started = time.Now()
+
+/*
+Info information about the running service
+*/
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Started   string `json:"started"`
+	Uptime    string `json:"uptime"`
+	SystemID  string `json:"systemID"`
+}
+
+/*
+LogLevelRequest changing the log level, globally or for a single tenant, device or package
+*/
+type LogLevelRequest struct {
+	// Level the new log level: debug, info, alert or fatal
+	Level string `json:"level"`
+	// Tenant, Device and Package are restricting the change to messages of this tenant, device or package
+	Tenant  string `json:"tenant,omitempty"`
+	Device  string `json:"device,omitempty"`
+	Package string `json:"package,omitempty"`
+	// Timeout after this duration the change will be reverted, e.g. "10m"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+/*
+AdminRoutes getting all routes for the admin endpoint
+*/
+func AdminRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/logging", Documented(RouteDoc{
+		Summary:  "getting the actual log levels",
+		Tag:      "admin",
+		Response: logging.LevelState{},
+	}, GetLoggingEndpoint))
+	router.Method(http.MethodPut, "/logging", Documented(RouteDoc{
+		Summary:  "changing the log level globally or for a tenant, device or package",
+		Tag:      "admin",
+		Request:  LogLevelRequest{},
+		Response: logging.LevelState{},
+		Errors:   []ErrorCode{CodeValidationFailed},
+	}, PutLoggingEndpoint))
+	router.Method(http.MethodDelete, "/logging", Documented(RouteDoc{
+		Summary:  "reverting all log level changes to the configuration",
+		Tag:      "admin",
+		Response: logging.LevelState{},
+	}, DeleteLoggingEndpoint))
+	router.Method(http.MethodGet, "/info", Documented(RouteDoc{
+		Summary:  "version, build and runtime information",
+		Tag:      "admin",
+		Response: Info{},
+	}, GetInfoEndpoint))
+	router.Method(http.MethodGet, "/config", Documented(RouteDoc{
+		Summary:  "the effective config, all secret values are redacted",
+		Tag:      "admin",
+		Response: map[string]interface{}{},
+	}, GetEffectiveConfigEndpoint))
+	router.Method(http.MethodGet, "/versions", Documented(RouteDoc{
+		Summary:  "all api versions with their deprecation and usage",
+		Tag:      "admin",
+		Response: []VersionInfo{},
+	}, GetVersionsEndpoint))
+	router.Mount("/backups", BackupRoutes())
+	router.Mount("/retention", RetentionRoutes())
+	return router
+}
+
+/*
+GetLoggingEndpoint getting the actual log levels
+*/
+func GetLoggingEndpoint(response http.ResponseWriter, req *http.Request) {
+	Render(response, req, logging.Levels())
+}
+
+/*
+PutLoggingEndpoint changing the log level globally or for a tenant, device or package, with an optional timeout
+*/
+func PutLoggingEndpoint(response http.ResponseWriter, req *http.Request) {
+	var levelReq LogLevelRequest
+	if problem := Decode(req, &levelReq); problem != nil {
+		WriteProblem(response, req, problem)
+		return
+	}
+	errs := make([]FieldError, 0)
+	level, err := logging.ParseLevel(levelReq.Level)
+	if err != nil || levelReq.Level == "" {
+		errs = append(errs, FieldError{Field: "level", Message: fmt.Sprintf("unknown log level: %s", levelReq.Level)})
+	}
+	var timeout time.Duration
+	if levelReq.Timeout != "" {
+		timeout, err = time.ParseDuration(levelReq.Timeout)
+		if err != nil || timeout < 0 {
+			errs = append(errs, FieldError{Field: "timeout", Message: fmt.Sprintf("wrong timeout: %s", levelReq.Timeout)})
+		}
+	}
+	if len(errs) > 0 {
+		ValidationError(response, req, errs)
+		return
+	}
+
+	scopes := map[string]string{
+		logging.ScopeTenant:  levelReq.Tenant,
+		logging.ScopeDevice:  levelReq.Device,
+		logging.ScopePackage: levelReq.Package,
+	}
+	scoped := false
+	for scope, value := range scopes {
+		if value == "" {
+			continue
+		}
+		scoped = true
+		if err := logging.SetScopeLevel(scope, value, level, timeout); err != nil {
+			Error(response, req, CodeBadRequest, err.Error())
+			return
+		}
+		log.Alertf("log level for %s %s changed to %s, timeout: %s", scope, value, level, levelReq.Timeout)
+	}
+	if !scoped {
+		logging.SetLevel(level, timeout)
+		log.Alertf("global log level changed to %s, timeout: %s", level, levelReq.Timeout)
+	}
+	Render(response, req, logging.Levels())
+}
+
+/*
+DeleteLoggingEndpoint reverting all log level changes to the configured level
+*/
+func DeleteLoggingEndpoint(response http.ResponseWriter, req *http.Request) {
+	logging.ResetLevels()
+	log.Alert("log levels reset to configuration")
+	Render(response, req, logging.Levels())
+}
+
+/*
+GetInfoEndpoint getting version, build and runtime information of this service
+*/
+func GetInfoEndpoint(response http.ResponseWriter, req *http.Request) {
+	info := Info{
+		Version:   Build.Version,
+		Commit:    Build.Commit,
+		BuildTime: Build.BuildTime,
+		GoVersion: runtime.Version(),
+		Started:   started.Format(time.RFC3339),
+		Uptime:    time.Since(started).Round(time.Second).String(),
+		SystemID:  SystemID,
+	}
+	// without ldflags the vcs information of the go build is used
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = setting.Value
+			}
+		}
+	}
+	Render(response, req, info)
+}
+
+/*
+GetEffectiveConfigEndpoint getting the effective config of this service, all secret values are redacted
+*/
+func GetEffectiveConfigEndpoint(response http.ResponseWriter, req *http.Request) {
+	c, err := config.Map(config.Redact(config.Get()))
+	if err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't convert config: %s", err.Error()))
+		return
+	}
+	Render(response, req, c)
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/backup"
+	"github.com/willie68/AutoRestIoT/config"
+	"github.com/willie68/AutoRestIoT/jobs"
+)
+
+// job types of the backups
+const (
+	JobBackup  = "backup"
+	JobRestore = "restore"
+)
+
+/*
+BackupRequest creating a backup of a single tenant, or of all tenants without tenant
+*/
+type BackupRequest struct {
+	Tenant string `json:"tenant,omitempty"`
+}
+
+/*
+RestoreRequest restoring a backup, only the tenant if set, into the target tenant if set
+*/
+type RestoreRequest struct {
+	Name   string `json:"name,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+func init() {
+	jobs.Register(JobBackup, runBackup)
+	jobs.Register(JobRestore, runRestore)
+}
+
+/*
+BackupRoutes getting all routes for the backup endpoint
+*/
+func BackupRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/", Documented(RouteDoc{
+		Summary:  "all backups, newest first",
+		Tag:      "admin",
+		Response: []backup.Info{},
+	}, GetBackupsEndpoint))
+	router.Method(http.MethodPost, "/", Documented(RouteDoc{
+		Summary:  "creating a backup of a tenant or of all tenants as job",
+		Tag:      "admin",
+		Request:  BackupRequest{},
+		Response: jobs.Job{},
+		Status:   http.StatusAccepted,
+	}, PostBackupEndpoint))
+	router.Method(http.MethodDelete, "/{name}", Documented(RouteDoc{
+		Summary:  "deleting a backup",
+		Tag:      "admin",
+		Response: "",
+		Errors:   []ErrorCode{CodeNotFound},
+	}, DeleteBackupEndpoint))
+	router.Method(http.MethodPost, "/{name}/restore", Documented(RouteDoc{
+		Summary:  "restoring a backup as job, optional into another tenant",
+		Tag:      "admin",
+		Request:  RestoreRequest{},
+		Response: jobs.Job{},
+		Status:   http.StatusAccepted,
+		Errors:   []ErrorCode{CodeNotFound, CodeValidationFailed},
+	}, PostRestoreEndpoint))
+	return router
+}
+
+/*
+GetBackupsEndpoint getting all backups, newest first
+*/
+func GetBackupsEndpoint(response http.ResponseWriter, req *http.Request) {
+	list, err := backup.List(config.Get().Backup.Dir)
+	if err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't list backups: %s", err.Error()))
+		return
+	}
+	Render(response, req, list)
+}
+
+/*
+PostBackupEndpoint creating a backup of a tenant or of all tenants as job
+*/
+func PostBackupEndpoint(response http.ResponseWriter, req *http.Request) {
+	var backupReq BackupRequest
+	if req.ContentLength != 0 {
+		if problem := Decode(req, &backupReq); problem != nil {
+			WriteProblem(response, req, problem)
+			return
+		}
+	}
+	Accepted(response, req, JobBackup, backupReq)
+}
+
+/*
+PostRestoreEndpoint restoring a backup as job, optional only a single tenant and into another tenant
+*/
+func PostRestoreEndpoint(response http.ResponseWriter, req *http.Request) {
+	var restoreReq RestoreRequest
+	if req.ContentLength != 0 {
+		if problem := Decode(req, &restoreReq); problem != nil {
+			WriteProblem(response, req, problem)
+			return
+		}
+	}
+	restoreReq.Name = chi.URLParam(req, "name")
+	if !backupExists(restoreReq.Name) {
+		Error(response, req, CodeNotFound, fmt.Sprintf("backup %s not found", restoreReq.Name))
+		return
+	}
+	if restoreReq.Target != "" && restoreReq.Tenant == "" {
+		ValidationError(response, req, []FieldError{{Field: "tenant", Message: "a target needs the tenant to restore"}})
+		return
+	}
+	Accepted(response, req, JobRestore, restoreReq)
+}
+
+/*
+DeleteBackupEndpoint deleting a backup
+*/
+func DeleteBackupEndpoint(response http.ResponseWriter, req *http.Request) {
+	name := chi.URLParam(req, "name")
+	if !backupExists(name) {
+		Error(response, req, CodeNotFound, fmt.Sprintf("backup %s not found", name))
+		return
+	}
+	if err := backup.Delete(config.Get().Backup.Dir, name); err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't delete backup: %s", err.Error()))
+		return
+	}
+	log.WithContext(req.Context()).Alertf("backup %s deleted", name)
+	Render(response, req, name)
+}
+
+func backupExists(name string) bool {
+	if !backup.ValidName(name) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(config.Get().Backup.Dir, name))
+	return err == nil
+}
+
+func runBackup(task *jobs.Task, params json.RawMessage) (interface{}, error) {
+	var backupReq BackupRequest
+	if err := json.Unmarshal(params, &backupReq); err != nil {
+		return nil, err
+	}
+	task.SetProgress(0, "creating backup")
+	info, err := backup.Create(config.Get().Backup.Dir, SystemID, backupReq.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func runRestore(task *jobs.Task, params json.RawMessage) (interface{}, error) {
+	var restoreReq RestoreRequest
+	if err := json.Unmarshal(params, &restoreReq); err != nil {
+		return nil, err
+	}
+	if !backup.ValidName(restoreReq.Name) {
+		return nil, fmt.Errorf("wrong backup name: %s", restoreReq.Name)
+	}
+	task.SetProgress(0, "restoring backup")
+	manifest, err := backup.Restore(filepath.Join(config.Get().Backup.Dir, restoreReq.Name), restoreReq.Tenant, restoreReq.Target)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/willie68/AutoRestIoT/config"
+	"github.com/willie68/AutoRestIoT/storage"
+)
+
+/*
+BatchRequest a list of operations executed in one request
+*/
+type BatchRequest struct {
+	// Atomic all or none of the operations, only for the documents of the models. At the first failed operation
+	// the changes are rolled back, all other operations are answered with 424.
+	Atomic bool `json:"atomic,omitempty"`
+	// StopOnError stops at the first failed operation, the following operations are skipped with 424
+	StopOnError bool             `json:"stopOnError,omitempty"`
+	Operations  []BatchOperation `json:"operations"`
+}
+
+/*
+BatchOperation a single operation of a batch, the path is relative to the api version
+*/
+type BatchOperation struct {
+	ID      string            `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+/*
+BatchResult the result of a single operation of a batch
+*/
+type BatchResult struct {
+	ID     string          `json:"id,omitempty"`
+	Status int             `json:"status"`
+	ETag   string          `json:"etag,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+/*
+BatchResponse the results of all operations in the order of the request
+*/
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// headers of the batch request, which can't be changed by an operation
+var batchProtectedHeaders = []string{SystemHeader, APIKeyHeader, "Authorization", TenantHeader}
+
+var rootMutex sync.RWMutex
+var rootHandler http.Handler
+
+/*
+SetRootHandler setting the handler of the service with the complete middleware chain. The operations of a batch are
+dispatched to it, so authentication, logging and tracing are applied to every operation like to a single request.
+*/
+func SetRootHandler(handler http.Handler) {
+	rootMutex.Lock()
+	defer rootMutex.Unlock()
+	rootHandler = handler
+}
+
+/*
+PostBatchEndpoint executing the operations of a batch one after the other on the routes of this version.
+The operations are using the credentials and the tenant of the batch request, an operation can't change them.
+An atomic batch is executed in a transaction of the storage, no other write to the tenant is done in between.
+*/
+func (v *Version) PostBatchEndpoint(response http.ResponseWriter, req *http.Request) {
+	var batch BatchRequest
+	if problem := Decode(req, &batch); problem != nil {
+		WriteProblem(response, req, problem)
+		return
+	}
+	errs := make([]FieldError, 0)
+	max := config.Get().Batch.MaxOperations
+	if len(batch.Operations) == 0 {
+		errs = append(errs, FieldError{Field: "operations", Message: "no operations"})
+	}
+	if max > 0 && len(batch.Operations) > max {
+		errs = append(errs, FieldError{Field: "operations", Message: fmt.Sprintf("max %d operations allowed", max)})
+	}
+	for i, op := range batch.Operations {
+		field := fmt.Sprintf("operations[%d]", i)
+		switch strings.ToUpper(op.Method) {
+		case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			errs = append(errs, FieldError{Field: field + ".method", Message: fmt.Sprintf("method not supported: %s", op.Method)})
+		}
+		path := v.relativePath(op.Path)
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, FieldError{Field: field + ".path", Message: "path must start with /"})
+		} else if strings.HasPrefix(path, "/_batch") {
+			errs = append(errs, FieldError{Field: field + ".path", Message: "nested batches are not allowed"})
+		} else if batch.Atomic && (!strings.HasPrefix(path, "/models/") || streaming(path)) {
+			errs = append(errs, FieldError{Field: field + ".path", Message: "atomic batches only support the documents of the models"})
+		}
+	}
+	if len(errs) > 0 {
+		ValidationError(response, req, errs)
+		return
+	}
+
+	var tx *storage.Transaction
+	if batch.Atomic {
+		tx = storage.Begin(getTenant(req))
+		// only done, if the batch itself fails
+		defer tx.Rollback()
+		req = req.WithContext(storage.WithTransaction(req.Context(), tx))
+	}
+	results := make([]BatchResult, len(batch.Operations))
+	failed := false
+	for i, op := range batch.Operations {
+		if failed {
+			results[i] = BatchResult{ID: op.ID, Status: http.StatusFailedDependency}
+			continue
+		}
+		results[i] = v.execute(req, i, op)
+		failed = (batch.StopOnError || batch.Atomic) && results[i].Status >= http.StatusBadRequest
+	}
+	if tx != nil {
+		if failed {
+			tx.Rollback()
+			for i := range results {
+				if results[i].Status < http.StatusBadRequest {
+					results[i] = BatchResult{ID: results[i].ID, Status: http.StatusFailedDependency}
+				}
+			}
+		} else if err := tx.Commit(); err != nil {
+			Error(response, req, CodeInternal, fmt.Sprintf("can't commit batch: %s", err.Error()))
+			return
+		}
+	}
+	Render(response, req, BatchResponse{Results: results})
+}
+
+/*
+execute dispatching a single operation through the root handler of the service,
+without root handler directly to the router of this version
+*/
+func (v *Version) execute(parent *http.Request, index int, op BatchOperation) BatchResult {
+	ctx := parent.Context()
+	// every operation gets its own request id, derived from the batch request
+	requestID := fmt.Sprintf("%s-%d", middleware.GetReqID(ctx), index)
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, requestID)
+	sub, err := http.NewRequestWithContext(ctx, strings.ToUpper(op.Method), v.Path()+v.relativePath(op.Path), bytes.NewReader(op.Body))
+	if err != nil {
+		return BatchResult{ID: op.ID, Status: http.StatusBadRequest, Body: problemBody(NewProblem(CodeBadRequest, err.Error()))}
+	}
+	rootMutex.RLock()
+	handler := rootHandler
+	rootMutex.RUnlock()
+	// a new routing context, without root handler the path is routed relative to this version
+	rctx := chi.NewRouteContext()
+	if handler == nil {
+		handler = Recoverer(v.handler())
+		rctx.RoutePath = v.relativePath(sub.URL.Path)
+	}
+	sub = sub.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	sub.Header.Set(RequestIDHeader, requestID)
+	for name, value := range op.Headers {
+		sub.Header.Set(name, value)
+	}
+	for _, name := range batchProtectedHeaders {
+		sub.Header.Del(name)
+		if value := parent.Header.Get(name); value != "" {
+			sub.Header.Set(name, value)
+		}
+	}
+	sub.Header.Set("Accept", ContentTypeJSON)
+	if len(op.Body) > 0 {
+		sub.Header.Set("Content-Type", ContentTypeJSON)
+	}
+	sub.RemoteAddr = parent.RemoteAddr
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, sub)
+	result := BatchResult{
+		ID:     op.ID,
+		Status: recorder.Code,
+		ETag:   recorder.Header().Get("ETag"),
+	}
+	if body := recorder.Body.Bytes(); len(body) > 0 {
+		if json.Valid(body) {
+			result.Body = body
+		} else {
+			result.Body, _ = json.Marshal(string(body))
+		}
+	}
+	return result
+}
+
+/*
+relativePath the path of an operation relative to this version, the version path is optional
+*/
+func (v *Version) relativePath(path string) string {
+	return strings.TrimPrefix(path, v.Path())
+}
+
+/*
+handler the router of this version for the operations of a batch, created on first use
+*/
+func (v *Version) handler() http.Handler {
+	v.routerOnce.Do(func() {
+		v.router = v.Router()
+	})
+	return v.router
+}
+
+func problemBody(p *Problem) json.RawMessage {
+	data, _ := json.Marshal(p)
+	return data
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+func batchVersion() *Version {
+	return &Version{
+		Name: "8",
+		Routes: func(r chi.Router) {
+			r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"1"`)
+				Render(w, r, map[string]string{"id": chi.URLParam(r, "id"), "tenant": getTenant(r), "requestId": r.Header.Get(RequestIDHeader)})
+			})
+			r.Post("/fail", func(w http.ResponseWriter, r *http.Request) {
+				Error(w, r, CodeBadRequest, "failed")
+			})
+		},
+	}
+}
+
+func postBatch(t *testing.T, handler http.Handler, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set(TenantHeader, "tenant")
+	req.Header.Set(SystemHeader, "system")
+	req.Header.Set(APIKeyHeader, "key")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func batchResults(t *testing.T, rec *httptest.ResponseRecorder) []BatchResult {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var response BatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Results
+}
+
+func TestBatch(t *testing.T) {
+	v := batchVersion()
+	results := batchResults(t, postBatch(t, v.Router(), "/_batch", `{"operations":[
+		{"id":"a","method":"get","path":"/items/1"},
+		{"id":"b","method":"GET","path":"/api/v8/items/2","headers":{"X-mcs-tenant":"other"}},
+		{"id":"c","method":"POST","path":"/fail"},
+		{"id":"d","method":"GET","path":"/unknown"}]}`))
+	if len(results) != 4 {
+		t.Fatalf("wrong results: %+v", results)
+	}
+	var item map[string]string
+	json.Unmarshal(results[1].Body, &item)
+	if results[1].ID != "b" || results[1].Status != http.StatusOK || results[1].ETag != `"1"` || item["id"] != "2" {
+		t.Errorf("wrong result: %+v", results[1])
+	}
+	// the tenant of the batch request can't be changed by an operation
+	if item["tenant"] != "tenant" {
+		t.Errorf("tenant changed to %s", item["tenant"])
+	}
+	if results[2].Status != http.StatusBadRequest || results[3].Status != http.StatusNotFound {
+		t.Errorf("wrong errors: %+v", results)
+	}
+}
+
+func TestBatchStopOnError(t *testing.T) {
+	v := batchVersion()
+	results := batchResults(t, postBatch(t, v.Router(), "/_batch", `{"stopOnError":true,"operations":[
+		{"method":"GET","path":"/items/1"},
+		{"method":"POST","path":"/fail"},
+		{"method":"GET","path":"/items/2"}]}`))
+	if results[0].Status != http.StatusOK || results[1].Status != http.StatusBadRequest || results[2].Status != http.StatusFailedDependency {
+		t.Errorf("wrong results: %+v", results)
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	v := batchVersion()
+	tests := []struct{ body, field string }{
+		{`{"atomic":true,"operations":[{"method":"GET","path":"/items/1"}]}`, "operations[0].path"},
+		{`{"atomic":true,"operations":[{"method":"POST","path":"/models/devices/_import"}]}`, "operations[0].path"},
+		{`{"operations":[]}`, "operations"},
+		{`{"operations":[{"method":"HEAD","path":"/items/1"}]}`, "operations[0].method"},
+		{`{"operations":[{"method":"GET","path":"items/1"}]}`, "operations[0].path"},
+		{`{"operations":[{"method":"POST","path":"/_batch"}]}`, "operations[0].path"},
+	}
+	for _, test := range tests {
+		p := readProblem(t, postBatch(t, v.Router(), "/_batch", test.body), http.StatusUnprocessableEntity)
+		if len(p.Errors) != 1 || p.Errors[0].Field != test.field {
+			t.Errorf("%s: wrong errors %+v", test.body, p.Errors)
+		}
+	}
+}
+
+func TestBatchMiddlewares(t *testing.T) {
+	v := batchVersion()
+	authenticated := 0
+	root := chi.NewRouter()
+	root.Use(RequestID, NewSysAPIHandler("system", "key").Handler, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated++
+			next.ServeHTTP(w, r)
+		})
+	})
+	root.Mount(v.Path(), v.Router())
+	SetRootHandler(root)
+	defer SetRootHandler(nil)
+
+	results := batchResults(t, postBatch(t, root, v.Path()+"/_batch", `{"operations":[
+		{"method":"GET","path":"/items/1"},
+		{"method":"GET","path":"/items/2","headers":{"X-mcs-apikey":"wrong"}}]}`))
+	// the batch request and every operation are passing the middlewares
+	if authenticated != 3 {
+		t.Errorf("middlewares passed %d times", authenticated)
+	}
+	for i, result := range results {
+		var item map[string]string
+		json.Unmarshal(result.Body, &item)
+		// the request id of an operation is derived from the batch request
+		if result.Status != http.StatusOK || !strings.HasSuffix(item["requestId"], fmt.Sprintf("-%d", i)) {
+			t.Errorf("wrong result: %+v", result)
+		}
+	}
+}
+
+func TestAtomicBatch(t *testing.T) {
+	path, _ := createDocument(t, `{"name":"sensor"}`)
+	v, _ := GetVersion("2")
+	router := chi.NewRouter()
+	router.Mount(v.Path(), v.Router())
+	batch := func(last string) []BatchResult {
+		return batchResults(t, postBatch(t, router, v.Path()+"/_batch", `{"atomic":true,"operations":[
+			{"method":"POST","path":"/models/devices/","body":{"name":"created"}},
+			{"method":"PUT","path":"/models`+path+`","body":{"name":"renamed"}},
+			{"method":"DELETE","path":"/models`+path+`"},
+			`+last+`]}`))
+	}
+
+	// a failed operation rolls back the others
+	results := batch(`{"method":"GET","path":"/models/devices/unknown"},{"method":"GET","path":"/models/devices/"}`)
+	for i, status := range []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusFailedDependency, http.StatusNotFound, http.StatusFailedDependency} {
+		if results[i].Status != status {
+			t.Errorf("operation %d: status %d", i, results[i].Status)
+		}
+	}
+	list := storage.List("tenant", "devices")
+	if len(list) != 1 || list[0].Revision != 1 || list[0].Data["name"] != "sensor" {
+		t.Errorf("not rolled back: %+v", list)
+	}
+	if items := trash.List("tenant"); len(items) != 0 {
+		t.Errorf("rolled back delete in the trash: %+v", items)
+	}
+
+	results = batch(`{"method":"GET","path":"/models/devices/"}`)
+	for i, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			t.Errorf("operation %d: status %d", i, result.Status)
+		}
+	}
+	list = storage.List("tenant", "devices")
+	if len(list) != 1 || list[0].Data["name"] != "created" || len(trash.List("tenant")) != 1 {
+		t.Errorf("not committed: %+v", list)
+	}
+}
@@ -0,0 +1,434 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/bulk"
+	"github.com/willie68/AutoRestIoT/jobs"
+	"github.com/willie68/AutoRestIoT/storage"
+)
+
+// job types of the bulk import and export
+const (
+	JobImport = "import"
+	JobExport = "export"
+)
+
+// names of the job files
+const (
+	importFile = "import"
+	exportFile = "export"
+)
+
+// progressSize number of documents after which the progress of an import or export is reported
+const progressSize = 100
+
+var bulkFormats = map[string]string{
+	bulk.ContentTypeNDJSON:  bulk.FormatNDJSON,
+	"application/jsonlines": bulk.FormatNDJSON,
+	bulk.ContentTypeCSV:     bulk.FormatCSV,
+}
+
+/*
+BulkParams the parameters of an import or export job
+*/
+type BulkParams struct {
+	Model   string       `json:"model"`
+	Format  string       `json:"format"`
+	Mapping bulk.Mapping `json:"mapping,omitempty"`
+	// Jobs base path of the job resources in the api version of the request, for the download link
+	Jobs string `json:"jobs,omitempty"`
+}
+
+/*
+ExportResult the result of an export job, the file is downloaded with the download link of the job
+*/
+type ExportResult struct {
+	Documents   int    `json:"documents"`
+	Format      string `json:"format"`
+	ContentType string `json:"contentType"`
+}
+
+func init() {
+	jobs.Register(JobImport, runImport)
+	jobs.Register(JobExport, runExport)
+}
+
+/*
+PostImportEndpoint uploading ndjson or csv, by its Content-Type, as import job. The csv columns can be mapped
+to fields with the query parameter mapping=column=field,... The report with the errors per line is the result of the job.
+*/
+func PostImportEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	format, ok := bulkFormats[mediaType]
+	if err != nil || !ok {
+		Error(response, req, CodeUnsupportedMediaType, fmt.Sprintf("supported media types: %s, %s", bulk.ContentTypeNDJSON, bulk.ContentTypeCSV))
+		return
+	}
+	mapping, err := bulk.ParseMapping(req.URL.Query().Get("mapping"))
+	if err != nil {
+		ValidationError(response, req, []FieldError{{Field: "mapping", Message: err.Error()}})
+		return
+	}
+	params := BulkParams{Model: model, Format: format, Mapping: mapping}
+	job, err := jobs.SubmitWithFile(JobImport, tenant, params, importFile, req.Body)
+	acceptedJob(response, req, job, err)
+}
+
+/*
+GetExportEndpoint streaming all documents of a model as ndjson or csv, like the export job. The documents are read
+and written page by page, a slow client slows down the reading. For exports taking longer than a client can wait
+the export job is the better choice.
+*/
+func GetExportEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	params, ok := exportParams(response, req, model)
+	if !ok {
+		return
+	}
+	writer, err := bulk.NewWriter(params.Format, response, exportFields(params), params.Mapping)
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	response.Header().Set("Content-Type", exportContentType(params.Format))
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", model, params.Format))
+	flusher, _ := response.(http.Flusher)
+	n, err := exportDocuments(req.Context(), storage.NewCursor(tenant, model), writer, func(int) error {
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		// the response is already started, the client sees the incomplete export
+		log.WithContext(req.Context()).Alertf("export of model %s stopped after %d documents: %s", model, n, err.Error())
+	}
+}
+
+/*
+PostExportEndpoint exporting all documents of a model as job, as ndjson or csv by the query parameter format
+or the Accept header. The csv columns are the fields of the model definition, or the fields of the first document,
+mapped with the query parameter mapping. The job has a download link for the file.
+*/
+func PostExportEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	params, ok := exportParams(response, req, model)
+	if !ok {
+		return
+	}
+	params.Jobs = strings.TrimSuffix(jobPath(req, ""), "/")
+	job, err := jobs.Submit(JobExport, tenant, params)
+	acceptedJob(response, req, job, err)
+}
+
+/*
+exportParams the format and the mapping of an export request, on an error the response is already written
+*/
+func exportParams(response http.ResponseWriter, req *http.Request, model string) (BulkParams, bool) {
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = bulk.FormatNDJSON
+		for _, part := range strings.Split(req.Header.Get("Accept"), ",") {
+			if mediaType, _, err := mime.ParseMediaType(part); err == nil {
+				if f, ok := bulkFormats[mediaType]; ok {
+					format = f
+					break
+				}
+			}
+		}
+	}
+	if format != bulk.FormatNDJSON && format != bulk.FormatCSV {
+		ValidationError(response, req, []FieldError{{Field: "format", Message: fmt.Sprintf("unknown format: %s", format)}})
+		return BulkParams{}, false
+	}
+	mapping, err := bulk.ParseMapping(req.URL.Query().Get("mapping"))
+	if err != nil {
+		ValidationError(response, req, []FieldError{{Field: "mapping", Message: err.Error()}})
+		return BulkParams{}, false
+	}
+	return BulkParams{Model: model, Format: format, Mapping: mapping}, true
+}
+
+/*
+GetJobDownloadEndpoint downloading the file of a finished export job
+*/
+func GetJobDownloadEndpoint(response http.ResponseWriter, req *http.Request) {
+	job, ok := tenantJob(req)
+	if !ok || job.Type != JobExport || job.Status != jobs.StatusSucceeded {
+		Error(response, req, CodeNotFound, fmt.Sprintf("no download for job %s", chi.URLParam(req, "id")))
+		return
+	}
+	var result ExportResult
+	if err := json.Unmarshal(job.Result, &result); err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("wrong result of job %s: %s", job.ID, err.Error()))
+		return
+	}
+	file, err := os.Open(jobs.FilePath(job.ID, exportFile))
+	if os.IsNotExist(err) {
+		Error(response, req, CodeNotFound, fmt.Sprintf("the file of job %s is removed", job.ID))
+		return
+	}
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	defer file.Close()
+	response.Header().Set("Content-Type", result.ContentType)
+	response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", job.ID, result.Format))
+	http.ServeContent(response, req, "", *job.Finished, file)
+}
+
+/*
+RunImport running an import job with the content and waiting for its report, like the import endpoint.
+The jobs must be started.
+*/
+func RunImport(ctx context.Context, tenant string, params BulkParams, content io.Reader) (bulk.Report, error) {
+	var report bulk.Report
+	job, err := jobs.SubmitWithFile(JobImport, tenant, params, importFile, content)
+	if err != nil {
+		return report, err
+	}
+	if job, err = finished(ctx, job.ID); err != nil {
+		return report, err
+	}
+	err = json.Unmarshal(job.Result, &report)
+	return report, err
+}
+
+/*
+RunExport running an export job and copying the export file to out, like the export endpoint.
+The jobs must be started.
+*/
+func RunExport(ctx context.Context, tenant string, params BulkParams, out io.Writer) (ExportResult, error) {
+	var result ExportResult
+	job, err := jobs.Submit(JobExport, tenant, params)
+	if err != nil {
+		return result, err
+	}
+	if job, err = finished(ctx, job.ID); err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(job.Result, &result); err != nil {
+		return result, err
+	}
+	file, err := os.Open(jobs.FilePath(job.ID, exportFile))
+	if err != nil {
+		return result, fmt.Errorf("can't open export: %s", err.Error())
+	}
+	defer file.Close()
+	_, err = io.Copy(out, file)
+	return result, err
+}
+
+/*
+finished waiting for the job, a failed or cancelled job is returned as error
+*/
+func finished(ctx context.Context, id string) (jobs.Job, error) {
+	job, err := jobs.Wait(ctx, id)
+	if err != nil {
+		return job, err
+	}
+	if job.Status != jobs.StatusSucceeded {
+		return job, fmt.Errorf("job %s %s: %s", job.ID, job.Status, job.Error)
+	}
+	return job, nil
+}
+
+/*
+runImport importing the uploaded file document by document, documents of defined models are validated.
+The progress is the part of the file already read. The id of a document is derived from the job and the line,
+so an import started again after a restart doesn't create the documents of the first run a second time.
+*/
+func runImport(task *jobs.Task, data json.RawMessage) (interface{}, error) {
+	var params BulkParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(task.File(importFile))
+	if err != nil {
+		return nil, fmt.Errorf("can't open upload: %s", err.Error())
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	counter := &taskReader{task: task, reader: file}
+	bulkReader, err := bulk.NewReader(params.Format, counter, params.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	reader := &lineReader{Reader: bulkReader}
+	count := 0
+	report, err := bulk.Import(reader, func(doc bulk.Document) error {
+		raw, err := json.Marshal(map[string]interface{}(doc))
+		if err != nil {
+			return err
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return err
+		}
+		if errs := storage.Validate(params.Model, values); len(errs) > 0 {
+			return fmt.Errorf("%s: %s", errs[0].Field, errs[0].Message)
+		}
+		if _, _, err := storage.CreateWithID(task.Tenant(), params.Model, importID(task.ID(), reader.line), values); err != nil {
+			return err
+		}
+		count++
+		if count%progressSize == 0 && info.Size() > 0 {
+			task.SetProgress(int(counter.read*100/info.Size()), fmt.Sprintf("%d documents imported", count))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("import stopped after %d lines: %s", report.Lines, err.Error())
+	}
+	return report, nil
+}
+
+/*
+runExport writing all documents of the model into the export file and adding the download link
+*/
+func runExport(task *jobs.Task, data json.RawMessage) (interface{}, error) {
+	var params BulkParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	file, err := task.CreateFile(exportFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	writer, err := bulk.NewWriter(params.Format, file, exportFields(params), params.Mapping)
+	if err != nil {
+		return nil, err
+	}
+	cursor := storage.NewCursor(task.Tenant(), params.Model)
+	total := cursor.Len()
+	n, err := exportDocuments(task, cursor, writer, func(written int) error {
+		task.SetProgress(written*100/total, fmt.Sprintf("%d documents exported", written))
+		return nil
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		if task.Err() != nil {
+			return nil, task.Err()
+		}
+		return nil, fmt.Errorf("can't write export: %s", err.Error())
+	}
+	task.AddLink("download", params.Jobs+"/"+task.ID()+"/download")
+	return ExportResult{Documents: n, Format: params.Format, ContentType: exportContentType(params.Format)}, nil
+}
+
+/*
+exportDocuments writing the documents of the cursor page by page, page is called after every page with the number
+of written documents. It stops, when the context is done.
+*/
+func exportDocuments(ctx context.Context, cursor *storage.Cursor, writer bulk.Writer, page func(written int) error) (int, error) {
+	written := 0
+	for {
+		docs := cursor.Next(progressSize)
+		if len(docs) == 0 {
+			return written, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return written, err
+		}
+		for _, doc := range docs {
+			if err := writer.Write(bulk.Document(doc.Data)); err != nil {
+				return written, err
+			}
+			written++
+		}
+		if err := page(written); err != nil {
+			return written, err
+		}
+	}
+}
+
+/*
+exportFields the csv columns of a defined model, without definition the fields of the first document
+*/
+func exportFields(params BulkParams) []string {
+	var fields []string
+	if m, ok := storage.GetModel(params.Model); ok && params.Format == bulk.FormatCSV {
+		for _, f := range m.Fields {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
+
+func exportContentType(format string) string {
+	if format == bulk.FormatCSV {
+		return bulk.ContentTypeCSV + "; charset=utf-8"
+	}
+	return bulk.ContentTypeNDJSON
+}
+
+/*
+importID the document id of a line of an import job
+*/
+func importID(job string, line int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", job, line)))
+	return hex.EncodeToString(sum[:12])
+}
+
+/*
+lineReader keeping the line of the last read document
+*/
+type lineReader struct {
+	bulk.Reader
+	line int
+}
+
+func (r *lineReader) Read() (bulk.Document, int, error) {
+	doc, line, err := r.Reader.Read()
+	r.line = line
+	return doc, line, err
+}
+
+/*
+taskReader counting the bytes read, stops reading when the task is cancelled
+*/
+type taskReader struct {
+	task   *jobs.Task
+	reader io.Reader
+	read   int64
+}
+
+func (r *taskReader) Read(p []byte) (int, error) {
+	if err := r.task.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/bulk"
+	"github.com/willie68/AutoRestIoT/jobs"
+	"github.com/willie68/AutoRestIoT/storage"
+)
+
+/*
+startJobs starting the storage in memory and the jobs in a temporary directory
+*/
+func startJobs(t *testing.T) {
+	t.Helper()
+	if err := storage.Start(storage.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.Start(jobs.Config{Workers: 1, Storage: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		jobs.Stop(context.Background())
+	})
+}
+
+/*
+bulkRouter the actual version like mounted in the service, the paths of the requests are relative to the version
+*/
+func bulkRouter() http.Handler {
+	router := chi.NewRouter()
+	router.Mount(ActualVersion().Path(), ActualVersion().Router())
+	return router
+}
+
+func serveBulk(t *testing.T, method, path, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	if !strings.HasPrefix(path, "/api/") {
+		path = ActualVersion().Path() + path
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(TenantHeader, "tenant")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	bulkRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+/*
+waitForJob reading the job of an accepted request until it is finished
+*/
+func waitForJob(t *testing.T, rec *httptest.ResponseRecorder) jobs.Job {
+	t.Helper()
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	for i := 0; i < 500; i++ {
+		var job jobs.Job
+		rec := serveBulk(t, http.MethodGet, location, "", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status.Finished() {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s not finished", location)
+	return jobs.Job{}
+}
+
+func TestImportJob(t *testing.T) {
+	startJobs(t)
+	storage.SetModels([]storage.Model{{Name: "sensors", Fields: []storage.Field{{Name: "name", Type: storage.TypeString, Required: true}}}})
+	defer storage.SetModels(nil)
+
+	body := "{\"name\":\"a\",\"value\":1}\n{\"value\":2}\nnot json\n{\"name\":\"b\"}\n"
+	job := waitForJob(t, serveBulk(t, http.MethodPost, "/models/sensors/_import", bulk.ContentTypeNDJSON, body))
+	if job.Status != jobs.StatusSucceeded {
+		t.Fatalf("job failed: %+v", job)
+	}
+	var report bulk.Report
+	if err := json.Unmarshal(job.Result, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Lines != 4 || report.Imported != 2 || report.Failed != 2 || report.Errors[0].Line != 2 || report.Errors[1].Line != 3 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if docs := storage.List("tenant", "sensors"); len(docs) != 2 || docs[0].Data["value"] != 1.0 {
+		t.Errorf("wrong documents: %+v", docs)
+	}
+
+	csv := "Name,Value\nc,3\n"
+	job = waitForJob(t, serveBulk(t, http.MethodPost, "/models/sensors/_import?mapping=Name=name,Value=value", "text/csv; charset=utf-8", csv))
+	if job.Status != jobs.StatusSucceeded || len(storage.List("tenant", "sensors")) != 3 {
+		t.Errorf("csv not imported: %+v", job)
+	}
+
+	readProblem(t, serveBulk(t, http.MethodPost, "/models/sensors/_import", ContentTypeJSON, "{}"), http.StatusUnsupportedMediaType)
+}
+
+func TestExportJob(t *testing.T) {
+	startJobs(t)
+	for _, value := range []float64{1, 2} {
+		if _, err := storage.Create("tenant", "sensors", map[string]interface{}{"name": "s", "value": value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	job := waitForJob(t, serveBulk(t, http.MethodPost, "/models/sensors/_export?format=csv&mapping=Value=value", "", ""))
+	if job.Status != jobs.StatusSucceeded || job.Links["download"] != ActualVersion().Path()+"/jobs/"+job.ID+"/download" {
+		t.Fatalf("wrong job: %+v", job)
+	}
+	rec := serveBulk(t, http.MethodGet, job.Links["download"], "", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), bulk.ContentTypeCSV) {
+		t.Fatalf("status %d, content type %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != "name,Value\ns,1\ns,2\n" {
+		t.Errorf("wrong export: %q", rec.Body.String())
+	}
+
+	// the download of another tenant is not found
+	req := httptest.NewRequest(http.MethodGet, job.Links["download"], nil)
+	req.Header.Set(TenantHeader, "other")
+	rec = httptest.NewRecorder()
+	bulkRouter().ServeHTTP(rec, req)
+	readProblem(t, rec, http.StatusNotFound)
+
+	readProblem(t, serveBulk(t, http.MethodPost, "/models/sensors/_export?format=xml", "", ""), http.StatusUnprocessableEntity)
+}
+
+func TestImportRestart(t *testing.T) {
+	if err := storage.Start(storage.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := jobs.Start(jobs.Config{Workers: 1, Storage: dir}); err != nil {
+		t.Fatal(err)
+	}
+	var body strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&body, "{\"index\":%d}\n", i)
+	}
+	job := waitForJob(t, serveBulk(t, http.MethodPost, "/models/sensors/_import", bulk.ContentTypeNDJSON, body.String()))
+	jobs.Stop(context.Background())
+
+	// a crash after the first half of the lines: the job is stored as running, the other documents are missing
+	for _, doc := range storage.List("tenant", "sensors")[5:] {
+		storage.Delete("tenant", "sensors", doc.ID, nil)
+	}
+	job.Status = jobs.StatusRunning
+	job.Result = nil
+	job.Finished = nil
+	data, _ := json.Marshal(job)
+	if err := ioutil.WriteFile(filepath.Join(dir, job.ID+".json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jobs.Start(jobs.Config{Workers: 1, Storage: dir}); err != nil {
+		t.Fatal(err)
+	}
+	defer jobs.Stop(context.Background())
+	for i := 0; i < 500; i++ {
+		if job, _ = jobs.Get(job.ID); job.Status.Finished() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var report bulk.Report
+	json.Unmarshal(job.Result, &report)
+	if job.Status != jobs.StatusSucceeded || report.Imported != 10 {
+		t.Fatalf("wrong job after restart: %+v", job)
+	}
+	docs := storage.List("tenant", "sensors")
+	if len(docs) != 10 {
+		t.Errorf("%d documents after the restart", len(docs))
+	}
+	for i, doc := range docs {
+		if doc.Data["index"] != float64(i) {
+			t.Errorf("wrong document %d: %+v", i, doc)
+		}
+	}
+}
+
+func TestExportStream(t *testing.T) {
+	startJobs(t)
+	for i := 0; i < 2*progressSize+50; i++ {
+		if _, err := storage.Create("tenant", "sensors", map[string]interface{}{"index": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the documented route, not a document with the id _export
+	rec := serveBulk(t, http.MethodGet, "/models/sensors/_export", "", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != bulk.ContentTypeNDJSON {
+		t.Fatalf("status %d, content type %s: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 2*progressSize+50 || lines[0] != `{"index":0}` || lines[len(lines)-1] != `{"index":249}` {
+		t.Errorf("wrong export: %d lines, %s ... %s", len(lines), lines[0], lines[len(lines)-1])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, ActualVersion().Path()+"/models/sensors/_export", nil)
+	req.Header.Set(TenantHeader, "tenant")
+	req.Header.Set("Accept", bulk.ContentTypeCSV)
+	rec = httptest.NewRecorder()
+	bulkRouter().ServeHTTP(rec, req)
+	if !strings.HasPrefix(rec.Body.String(), "index\n0\n1\n") || !strings.HasPrefix(rec.Header().Get("Content-Type"), bulk.ContentTypeCSV) {
+		t.Errorf("wrong csv export: %.20q", rec.Body.String())
+	}
+	readProblem(t, serveBulk(t, http.MethodGet, "/models/sensors/_export?format=xml", "", ""), http.StatusUnprocessableEntity)
+}
+
+func TestBulkWithoutIdempotency(t *testing.T) {
+	calls := 0
+	handler := countingHandler(&calls)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/models/sensors/_import", strings.NewReader("{}"))
+		req.Header.Set(TenantHeader, "idempotency-tenant")
+		req.Header.Set(IdempotencyKeyHeader, "import-key")
+		rec := httptest.NewRecorder()
+		Idempotency(handler).ServeHTTP(rec, req)
+		if rec.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Error("import replayed")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
+
+func TestRunImportExport(t *testing.T) {
+	startJobs(t)
+	ctx := context.Background()
+	params := BulkParams{Model: "sensors", Format: bulk.FormatNDJSON}
+	report, err := RunImport(ctx, "tenant", params, strings.NewReader("{\"value\":1}\nnot json\n{\"value\":2}\n"))
+	if err != nil || report.Imported != 2 || report.Failed != 1 {
+		t.Fatalf("wrong import: %+v, %v", report, err)
+	}
+	var out strings.Builder
+	params.Format = bulk.FormatCSV
+	result, err := RunExport(ctx, "tenant", params, &out)
+	if err != nil || result.Documents != 2 || out.String() != "value\n1\n2\n" {
+		t.Errorf("wrong export: %+v, %v: %q", result, err, out.String())
+	}
+	if _, err := RunExport(ctx, "tenant", BulkParams{Model: "sensors", Format: "xml"}, &out); err == nil {
+		t.Error("export with a wrong format succeeded")
+	}
+}
+
+func TestStreamingDeadlines(t *testing.T) {
+	server := httptest.NewUnstartedServer(StreamingDeadlines(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusRequestTimeout)
+			return
+		}
+		fmt.Fprint(w, len(data))
+	})))
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	// an upload taking longer than the read timeout of the server
+	upload := func(path string) (string, error) {
+		body, writer := io.Pipe()
+		go func() {
+			for i := 0; i < 5; i++ {
+				writer.Write([]byte("line\n"))
+				time.Sleep(50 * time.Millisecond)
+			}
+			writer.Close()
+		}()
+		resp, err := http.Post(server.URL+path, bulk.ContentTypeNDJSON, body)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		return string(data), err
+	}
+	if result, err := upload("/models/sensors/_import"); err != nil || result != "25" {
+		t.Errorf("upload cut off: %q, %v", result, err)
+	}
+	if result, err := upload("/models/sensors"); err == nil && result == "25" {
+		t.Error("read timeout removed for a normal request")
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ETag the strong entity tag of a document revision, like "42"
+*/
+func ETag(revision uint64) string {
+	return fmt.Sprintf("\"%d\"", revision)
+}
+
+/*
+contentETag a weak entity tag of a response body, for responses without a revision
+*/
+func contentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "W/\"" + base64.RawURLEncoding.EncodeToString(sum[:12]) + "\""
+}
+
+/*
+SetRevision setting the ETag and Last-Modified headers of a document, a zero modified time is omitted
+*/
+func SetRevision(w http.ResponseWriter, etag string, modified time.Time) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+/*
+CheckPreconditions evaluating the conditional headers of RFC 7232 against the actual etag and modification time
+of a document. For an unknown document the etag is empty. If the result is false, a 304 or 412 response is
+already written and the handler must stop.
+*/
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if p := Precondition(r, etag, modified); p != nil {
+		WriteProblem(w, r, p)
+		return false
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, true) {
+			notModified(w, etag, modified)
+			return false
+		}
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Modified-Since")); ok && !modified.IsZero() {
+		if !modified.Truncate(time.Second).After(since) {
+			notModified(w, etag, modified)
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Precondition evaluating the conditional headers of a changing request, the result is a 412 problem or nil.
+The write handlers are calling it inside the atomic update of the storage with the stored revision,
+so a concurrent change between the check and the write is impossible.
+*/
+func Precondition(r *http.Request, etag string, modified time.Time) *Problem {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, false) {
+			return NewProblem(CodePreconditionFailed, fmt.Sprintf("the document has changed, actual etag: %s", etag))
+		}
+	} else if since, ok := parseHTTPDate(r.Header.Get("If-Unmodified-Since")); ok && !modified.IsZero() {
+		if modified.Truncate(time.Second).After(since) {
+			return NewProblem(CodePreconditionFailed, fmt.Sprintf("the document has been modified at %s", modified.UTC().Format(http.TimeFormat)))
+		}
+	}
+	safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && !safe && matchETag(ifNoneMatch, etag, true) {
+		return NewProblem(CodePreconditionFailed, "the document exists")
+	}
+	return nil
+}
+
+func notModified(w http.ResponseWriter, etag string, modified time.Time) {
+	SetRevision(w, etag, modified)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+/*
+matchETag checks if the etag is in the list of a If-Match or If-None-Match header.
+If-Match uses the strong comparison, If-None-Match the weak one.
+*/
+func matchETag(header string, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+)
+
+/*
+marshalCSV writing a list as csv, one row per entry with the json field names as header.
+A single object is written as one row, nested values are written as json.
+*/
+func marshalCSV(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	raws := make([]json.RawMessage, 0)
+	if err := json.Unmarshal(data, &raws); err != nil {
+		raws = []json.RawMessage{data}
+	}
+
+	header := make([]string, 0)
+	seen := make(map[string]bool)
+	rows := make([]map[string]interface{}, 0, len(raws))
+	for _, raw := range raws {
+		keys, err := objectKeys(raw)
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{})
+		if keys == nil {
+			// not an object
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, err
+			}
+			keys = []string{"value"}
+			row["value"] = value
+		} else if err := json.Unmarshal(raw, &row); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				header = append(header, key)
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for i, key := range header {
+			record[i] = csvCell(row[key])
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+/*
+objectKeys the keys of a json object in the original order, nil if it's no object
+*/
+func objectKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '{' {
+		return nil, nil
+	}
+	keys := make([]string, 0)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, t.(string))
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func csvCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-chi/render"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// supported media types of request and response bodies
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeCBOR    = "application/cbor"
+	ContentTypeMsgPack = "application/msgpack"
+	ContentTypeCSV     = "text/csv"
+)
+
+/*
+Codec marshalling and unmarshalling of a media type, without Unmarshal the media type is only supported for responses
+*/
+type Codec struct {
+	ContentType string
+	Marshal     func(v interface{}) ([]byte, error)
+	Unmarshal   func(data []byte, v interface{}) error
+}
+
+var cborEncMode, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+var codecs = map[string]Codec{
+	ContentTypeJSON: {
+		ContentType: ContentTypeJSON,
+		Marshal:     json.Marshal,
+		Unmarshal:   json.Unmarshal,
+	},
+	ContentTypeCBOR: {
+		ContentType: ContentTypeCBOR,
+		Marshal:     cborEncMode.Marshal,
+		Unmarshal:   cbor.Unmarshal,
+	},
+	ContentTypeMsgPack: {
+		ContentType: ContentTypeMsgPack,
+		Marshal:     marshalMsgPack,
+		Unmarshal:   unmarshalMsgPack,
+	},
+	ContentTypeCSV: {
+		ContentType: ContentTypeCSV + "; charset=utf-8",
+		Marshal:     marshalCSV,
+	},
+}
+
+// other names of the supported media types
+var aliases = map[string]string{
+	"application/x-msgpack":   ContentTypeMsgPack,
+	"application/vnd.msgpack": ContentTypeMsgPack,
+	"application/*":           ContentTypeJSON,
+	"*/*":                     ContentTypeJSON,
+	"text/*":                  ContentTypeCSV,
+}
+
+func codec(mediaType string) (Codec, bool) {
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if alias, ok := aliases[mediaType]; ok {
+		mediaType = alias
+	}
+	c, ok := codecs[mediaType]
+	return c, ok
+}
+
+/*
+Decode decoding the request body by its Content-Type, default is json.
+The result is the same for all encodings, so the validation of the handlers is independent of the encoding.
+*/
+func Decode(r *http.Request, v interface{}) *Problem {
+	mediaType := ContentTypeJSON
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		parsed, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return NewProblem(CodeUnsupportedMediaType, err.Error())
+		}
+		mediaType = parsed
+	}
+	c, ok := codec(mediaType)
+	if !ok || c.Unmarshal == nil {
+		return NewProblem(CodeUnsupportedMediaType, fmt.Sprintf("media type %s not supported", mediaType))
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return NewProblem(CodeInvalidBody, err.Error())
+	}
+	if err := c.Unmarshal(data, v); err != nil {
+		return NewProblem(CodeInvalidBody, err.Error())
+	}
+	return nil
+}
+
+/*
+Negotiate choosing the response codec by the Accept header, ok is false if no accepted media type is supported
+*/
+func Negotiate(r *http.Request) (Codec, bool) {
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return codecs[ContentTypeJSON], true
+	}
+	type candidate struct {
+		mediaType string
+		q         float64
+	}
+	candidates := make([]candidate, 0)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{mediaType, q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, candidate := range candidates {
+		if c, ok := codec(candidate.mediaType); ok {
+			return c, true
+		}
+	}
+	return Codec{}, false
+}
+
+/*
+Render writing the value in the media type requested by the Accept header, the status can be set with render.Status
+*/
+func Render(w http.ResponseWriter, r *http.Request, v interface{}) {
+	c, ok := Negotiate(r)
+	if !ok {
+		Error(w, r, CodeNotAcceptable, fmt.Sprintf("supported media types: %s", strings.Join(MediaTypes(), ", ")))
+		return
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		Error(w, r, CodeInternal, fmt.Sprintf("can't encode response: %s", err.Error()))
+		return
+	}
+	status := http.StatusOK
+	if s, ok := r.Context().Value(render.StatusCtxKey).(int); ok {
+		status = s
+	}
+	w.Header().Set("Content-Type", c.ContentType)
+	w.Header().Add("Vary", "Accept")
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		// without a revision of the handler the etag of the content, polling clients are getting a 304
+		if w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", contentETag(data))
+		}
+		if !CheckPreconditions(w, r, w.Header().Get("ETag"), time.Time{}) {
+			return
+		}
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+/*
+MediaTypes all supported media types of the responses
+*/
+func MediaTypes() []string {
+	list := make([]string, 0, len(codecs))
+	for mediaType := range codecs {
+		list = append(list, mediaType)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func marshalMsgPack(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := msgpack.NewEncoder(&b)
+	// the same field names as json
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func unmarshalMsgPack(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testDocument struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count"`
+	Tags    []string          `json:"tags,omitempty"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]string{
+		"":                                     ContentTypeJSON,
+		"*/*":                                  ContentTypeJSON,
+		"application/cbor":                     ContentTypeCBOR,
+		"application/x-msgpack":                ContentTypeMsgPack,
+		"text/csv;q=0.5, application/cbor":     ContentTypeCBOR,
+		"application/json;q=0.2, text/csv":     ContentTypeCSV + "; charset=utf-8",
+		"image/png, application/msgpack;q=0.1": ContentTypeMsgPack,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		c, ok := Negotiate(r)
+		if !ok || c.ContentType != want {
+			t.Errorf("%q: got %s, want %s", accept, c.ContentType, want)
+		}
+	}
+	for _, accept := range []string{"image/png", "application/cbor;q=0"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		if _, ok := Negotiate(r); ok {
+			t.Errorf("%q accepted", accept)
+		}
+	}
+}
+
+func TestDecodeAllEncodings(t *testing.T) {
+	doc := testDocument{
+		Name:    "sensor",
+		Count:   42,
+		Tags:    []string{"a", "b"},
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Labels:  map[string]string{"room": "kitchen"},
+	}
+	for _, mediaType := range []string{ContentTypeJSON, ContentTypeCBOR, ContentTypeMsgPack, "application/vnd.msgpack"} {
+		c, _ := codec(mediaType)
+		data, err := c.Marshal(doc)
+		if err != nil {
+			t.Fatalf("%s: %v", mediaType, err)
+		}
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		r.Header.Set("Content-Type", mediaType+"; charset=utf-8")
+		var decoded testDocument
+		if p := Decode(r, &decoded); p != nil {
+			t.Fatalf("%s: %v", mediaType, p)
+		}
+		if decoded.Name != doc.Name || decoded.Count != doc.Count || len(decoded.Tags) != 2 ||
+			!decoded.Created.Equal(doc.Created) || decoded.Labels["room"] != "kitchen" {
+			t.Errorf("%s: decoded %+v", mediaType, decoded)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for ct, want := range map[string]ErrorCode{
+		ContentTypeCSV:       CodeUnsupportedMediaType,
+		"application/xml":    CodeUnsupportedMediaType,
+		"application/json;;": CodeUnsupportedMediaType,
+		ContentTypeJSON:      CodeInvalidBody,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("{broken")))
+		r.Header.Set("Content-Type", ct)
+		var v map[string]interface{}
+		if p := Decode(r, &v); p == nil || p.Code != want {
+			t.Errorf("%s: got %v, want %s", ct, p, want)
+		}
+	}
+}
+
+func TestMarshalCSV(t *testing.T) {
+	list := []map[string]interface{}{
+		{"name": "a", "count": 1},
+		{"name": "b", "nested": map[string]interface{}{"x": 1}, "ok": true},
+	}
+	data, err := marshalCSV(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "count,name,nested,ok\n1,a,,\n,b,\"{\"\"x\"\":1}\",true\n"
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+	data, _ = marshalCSV(testDocument{Name: "single"})
+	if !bytes.HasPrefix(data, []byte("name,count,created\nsingle,0,")) {
+		t.Errorf("single object: %s", data)
+	}
+	data, _ = marshalCSV([]string{"x", "y"})
+	if string(data) != "value\nx\ny\n" {
+		t.Errorf("list of values: %s", data)
+	}
+}
+
+func TestRender(t *testing.T) {
+	doc := testDocument{Name: "sensor"}
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", ContentTypeCBOR)
+	Render(rec, r, doc)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentTypeCBOR || rec.Header().Get("Vary") != "Accept" {
+		t.Fatalf("wrong response: %d %v", rec.Code, rec.Header())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no etag")
+	}
+
+	// polling with the etag
+	rec = httptest.NewRecorder()
+	r.Header.Set("If-None-Match", etag)
+	Render(rec, r, doc)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("status %d, want 304", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "image/png")
+	Render(rec, r, doc)
+	if p := readProblem(t, rec, http.StatusNotAcceptable); p.Code != CodeNotAcceptable {
+		t.Errorf("wrong problem: %+v", p)
+	}
+}
@@ -1,12 +1,15 @@
 package api
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/willie68/AutoRestIoT/logging"
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/trash"
 )
 
 // TenantHeader in this header thr right tenant should be inserted
@@ -19,6 +22,8 @@ var APIKey string
 //SystemID the systemid of this service
 var SystemID string
 
+var log = logging.ServiceLogger{Package: "api"}
+
 /*
 ConfigDescription describres all metadata of a config
 */
@@ -33,10 +38,45 @@ ConfigRoutes getting all routes for the config endpoint
 */
 func ConfigRoutes() *chi.Mux {
 	router := chi.NewRouter()
-	router.Post("/", PostConfigEndpoint)
-	router.Get("/", GetConfigEndpoint)
-	router.Delete("/", DeleteConfigEndpoint)
-	router.Get("/size", GetConfigSizeEndpoint)
+	router.Method(http.MethodPost, "/", Documented(RouteDoc{
+		Summary:  "create a new store for a tenant",
+		Tag:      "config",
+		Tenant:   true,
+		Response: "",
+		Status:   http.StatusCreated,
+	}, PostConfigEndpoint))
+	router.Method(http.MethodGet, "/", Documented(RouteDoc{
+		Summary:  "getting if a store for a tenant is initialised",
+		Tag:      "config",
+		Tenant:   true,
+		Response: ConfigDescription{},
+	}, GetConfigEndpoint))
+	router.Method(http.MethodDelete, "/", Documented(RouteDoc{
+		Summary:  "moving the store of a tenant with all data into the trash",
+		Tag:      "config",
+		Tenant:   true,
+		Response: trash.Item{},
+	}, DeleteConfigEndpoint))
+	router.Method(http.MethodGet, "/size", Documented(RouteDoc{
+		Summary:  "size of the store of a tenant",
+		Tag:      "config",
+		Tenant:   true,
+		Response: "",
+	}, GetConfigSizeEndpoint))
+	return router
+}
+
+/*
+ConfigRoutesV1 the config routes of version 1, deleting a store answers with the tenant like before the trash
+*/
+func ConfigRoutesV1() *chi.Mux {
+	router := ConfigRoutes()
+	router.Method(http.MethodDelete, "/", Documented(RouteDoc{
+		Summary:  "moving the store of a tenant with all data into the trash, answers with the tenant",
+		Tag:      "config",
+		Tenant:   true,
+		Response: "",
+	}, DeleteConfigEndpointV1))
 	return router
 }
 
@@ -47,7 +87,7 @@ because of the automatic store creation, the value is more likely that data is s
 func GetConfigEndpoint(response http.ResponseWriter, req *http.Request) {
 	tenant := getTenant(req)
 	if tenant == "" {
-		Msg(response, http.StatusBadRequest, "tenant not set")
+		Error(response, req, CodeTenantMissing, "")
 		return
 	}
 	c := ConfigDescription{
@@ -55,7 +95,7 @@ func GetConfigEndpoint(response http.ResponseWriter, req *http.Request) {
 		TenantID: tenant,
 		Size:     1234567,
 	}
-	render.JSON(response, req, c)
+	Render(response, req, c)
 }
 
 /*
@@ -65,24 +105,56 @@ because of the automatic store creation, this method will always return 201
 func PostConfigEndpoint(response http.ResponseWriter, req *http.Request) {
 	tenant := getTenant(req)
 	if tenant == "" {
-		Msg(response, http.StatusBadRequest, "tenant not set")
+		Error(response, req, CodeTenantMissing, "")
 		return
 	}
-	log.Printf("create store for tenant %s", tenant)
+	log.WithContext(req.Context()).With(logging.ScopeTenant, tenant).Infof("create store for tenant %s", tenant)
 	render.Status(req, http.StatusCreated)
-	render.JSON(response, req, tenant)
+	Render(response, req, tenant)
 }
 
 /*
-DeleteConfigEndpoint deleting store for a tenant, this will automatically delete all data in the store
+DeleteConfigEndpoint deleting store for a tenant, the store with all data is moved into the trash.
+It can be restored until the retention of the trash is expired.
 */
 func DeleteConfigEndpoint(response http.ResponseWriter, req *http.Request) {
+	item, ok := trashStore(response, req)
+	if !ok {
+		return
+	}
+	Render(response, req, item)
+}
+
+/*
+DeleteConfigEndpointV1 deleting store for a tenant like DeleteConfigEndpoint, answers with the tenant
+*/
+func DeleteConfigEndpointV1(response http.ResponseWriter, req *http.Request) {
+	item, ok := trashStore(response, req)
+	if !ok {
+		return
+	}
+	Render(response, req, item.Tenant)
+}
+
+/*
+trashStore moving the store of the tenant into the trash, on an error the response is already written
+*/
+func trashStore(response http.ResponseWriter, req *http.Request) (trash.Item, bool) {
 	tenant := getTenant(req)
 	if tenant == "" {
-		Msg(response, http.StatusBadRequest, "tenant not set")
-		return
+		Error(response, req, CodeTenantMissing, "")
+		return trash.Item{}, false
+	}
+	item, err := trash.Move(trash.KindStore, tenant, tenant, nil, func(item trash.Item) error {
+		storage.TrashTenant(tenant, item.ID)
+		return nil
+	})
+	if err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't delete store: %s", err.Error()))
+		return trash.Item{}, false
 	}
-	render.JSON(response, req, tenant)
+	log.WithContext(req.Context()).With(logging.ScopeTenant, tenant).Alertf("store of tenant %s moved to trash", tenant)
+	return item, true
 }
 
 /*
@@ -91,11 +163,11 @@ GetConfigSizeEndpoint size of the store for a tenant
 func GetConfigSizeEndpoint(response http.ResponseWriter, req *http.Request) {
 	tenant := getTenant(req)
 	if tenant == "" {
-		Msg(response, http.StatusBadRequest, "tenant not set")
+		Error(response, req, CodeTenantMissing, "")
 		return
 	}
 
-	render.JSON(response, req, tenant)
+	Render(response, req, tenant)
 }
 
 /*
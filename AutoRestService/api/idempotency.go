@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/config"
+)
+
+// IdempotencyKeyHeader header with the key of a request, which can be retried safely
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader marks a response as replayed for a retry
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const maxIdempotencyKeyLength = 255
+
+// idempotencySweepInterval interval of removing the expired responses
+const idempotencySweepInterval = time.Minute
+
+/*
+storedResponse the first response of a request with an idempotency key
+*/
+type storedResponse struct {
+	fingerprint [sha256.Size]byte
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	created     time.Time
+	expires     time.Time
+}
+
+type idempotencyStore struct {
+	mu        sync.Mutex
+	responses map[string]*storedResponse
+	// size of all stored bodies
+	size    int64
+	sweeper sync.Once
+}
+
+var idempotency = idempotencyStore{responses: make(map[string]*storedResponse)}
+
+/*
+Idempotency storing the response of a POST or PATCH request with an Idempotency-Key header per tenant and key.
+Requests without the header are passed through untouched. A retry with the same key and body gets the stored response,
+a different body with the same key is rejected. Server errors are not stored, so the request can be retried.
+The body of a request with a key is limited by idempotency.maxbody, all stored responses by idempotency.maxsize.
+*/
+func Idempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) || streaming(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg := config.Get().Idempotency
+		window := time.Duration(cfg.Window) * time.Second
+		if window <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			Error(w, r, CodeBadRequest, "idempotency key too long")
+			return
+		}
+		if cfg.MaxBody > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBody)
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if _, ok := err.(*http.MaxBytesError); ok {
+			Error(w, r, CodeTooLarge, fmt.Sprintf("max size of a request with an idempotency key: %d bytes", cfg.MaxBody))
+			return
+		}
+		if err != nil {
+			Error(w, r, CodeInvalidBody, err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		id := getTenant(r) + "\n" + key
+
+		stored, ok := idempotency.reserve(id, fingerprint, window)
+		if ok {
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			completed := false
+			defer func() {
+				// on a panic the key is released for a retry
+				if !completed {
+					idempotency.release(id)
+				}
+			}()
+			next.ServeHTTP(recorder, r)
+			completed = true
+			idempotency.finish(id, recorder, cfg.MaxSize)
+			return
+		}
+		switch {
+		case stored.fingerprint != fingerprint:
+			Error(w, r, CodeIdempotencyKeyReused, "the idempotency key was used for a different request")
+		case !stored.done:
+			Error(w, r, CodeConflict, "a request with this idempotency key is in progress")
+		default:
+			for name, values := range stored.header {
+				if name != RequestIDHeader {
+					w.Header()[name] = values
+				}
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body)
+		}
+	})
+}
+
+/*
+streaming checks the path for the streaming requests, like an import, the bodies of them are too large for storing them
+*/
+func streaming(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	return strings.HasSuffix(path, "/_import") || strings.HasSuffix(path, "/_export")
+}
+
+/*
+reserve reserving the key for a new request, if ok is false the stored response is returned
+*/
+func (s *idempotencyStore) reserve(id string, fingerprint [sha256.Size]byte, window time.Duration) (storedResponse, bool) {
+	s.sweeper.Do(func() {
+		go func() {
+			for range time.Tick(idempotencySweepInterval) {
+				s.sweep(time.Now())
+			}
+		}()
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if stored, ok := s.responses[id]; ok && now.Before(stored.expires) {
+		return *stored, false
+	}
+	s.remove(id)
+	s.responses[id] = &storedResponse{
+		fingerprint: fingerprint,
+		created:     now,
+		expires:     now.Add(window),
+	}
+	return storedResponse{}, true
+}
+
+/*
+finish storing the response, the oldest responses are removed until the size of all is below maxSize.
+The body is recorded before the compression of the service, so the encoding headers of the compressor
+are not stored. A replay is compressed again, depending on the Accept-Encoding of the retry.
+*/
+func (s *idempotencyStore) finish(id string, recorder *responseRecorder, maxSize int64) {
+	if recorder.status >= http.StatusInternalServerError {
+		s.release(id)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.responses[id]
+	if !ok {
+		return
+	}
+	stored.done = true
+	stored.status = recorder.status
+	stored.header = recorder.Header().Clone()
+	for _, name := range []string{"Content-Encoding", "Content-Length", "Vary"} {
+		stored.header.Del(name)
+	}
+	stored.body = recorder.body.Bytes()
+	s.size += int64(len(stored.body))
+	for maxSize > 0 && s.size > maxSize {
+		oldest := ""
+		for key, r := range s.responses {
+			if r.done && (oldest == "" || r.created.Before(s.responses[oldest].created)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			break
+		}
+		s.remove(oldest)
+	}
+}
+
+func (s *idempotencyStore) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+/*
+remove removing a response, must be called with the lock held
+*/
+func (s *idempotencyStore) remove(id string) {
+	if stored, ok := s.responses[id]; ok {
+		s.size -= int64(len(stored.body))
+		delete(s.responses, id)
+	}
+}
+
+/*
+sweep removing the expired responses, called periodically
+*/
+func (s *idempotencyStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, stored := range s.responses {
+		if now.After(stored.expires) {
+			s.remove(id)
+		}
+	}
+}
+
+/*
+responseRecorder writing the response and keeping a copy of status and body
+*/
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	rr.wroteHeader = true
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+/*
+countingHandler answering with 201 and the number of calls, with status 500 for a body "fail"
+*/
+func countingHandler(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		body := make([]byte, 4)
+		n, _ := r.Body.Read(body)
+		if string(body[:n]) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Calls", strings.Repeat("x", *calls))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+}
+
+func serveIdempotent(handler http.Handler, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/devices/", strings.NewReader(body))
+	req.Header.Set(TenantHeader, "idempotency-tenant")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	Idempotency(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotentReplay(t *testing.T) {
+	calls := 0
+	handler := countingHandler(&calls)
+	first := serveIdempotent(handler, http.MethodPost, "replay-key", `{"a":1}`)
+	second := serveIdempotent(handler, http.MethodPost, "replay-key", `{"a":1}`)
+	if calls != 1 {
+		t.Fatalf("handler called %d times", calls)
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != "created" || second.Header().Get("X-Calls") != "x" {
+		t.Errorf("wrong replay: %d %s", second.Code, second.Body.String())
+	}
+	if first.Header().Get(IdempotentReplayedHeader) != "" || second.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Error("replay not marked")
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	calls := 0
+	handler := countingHandler(&calls)
+	serveIdempotent(handler, http.MethodPost, "reused-key", `{"a":1}`)
+	p := readProblem(t, serveIdempotent(handler, http.MethodPost, "reused-key", `{"a":2}`), http.StatusUnprocessableEntity)
+	if p.Code != CodeIdempotencyKeyReused {
+		t.Errorf("wrong problem: %+v", p)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
+
+func TestWithoutIdempotencyKey(t *testing.T) {
+	calls := 0
+	handler := countingHandler(&calls)
+	for i := 0; i < 2; i++ {
+		rec := serveIdempotent(handler, http.MethodPost, "", `{"a":1}`)
+		if rec.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Error("request without key replayed")
+		}
+		// only POST and PATCH are handled
+		serveIdempotent(handler, http.MethodPut, "put-key", `{"a":1}`)
+	}
+	if calls != 4 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
+
+func TestIdempotencyErrors(t *testing.T) {
+	calls := 0
+	handler := countingHandler(&calls)
+	// server errors are not stored
+	serveIdempotent(handler, http.MethodPost, "error-key", "fail")
+	serveIdempotent(handler, http.MethodPost, "error-key", "fail")
+	if calls != 2 {
+		t.Errorf("handler called %d times", calls)
+	}
+	readProblem(t, serveIdempotent(handler, http.MethodPost, strings.Repeat("k", 256), "{}"), http.StatusBadRequest)
+	// the default limit is 1 MiB
+	readProblem(t, serveIdempotent(handler, http.MethodPost, "large-key", strings.Repeat(" ", 1048577)), http.StatusRequestEntityTooLarge)
+	if calls != 2 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
+
+func TestIdempotencyStoreSize(t *testing.T) {
+	s := idempotencyStore{responses: make(map[string]*storedResponse)}
+	s.sweeper.Do(func() {})
+	for _, id := range []string{"first", "second", "third"} {
+		s.reserve(id, sha256.Sum256([]byte(id)), time.Hour)
+		recorder := &responseRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+		recorder.Write([]byte("0123456789"))
+		s.finish(id, recorder, 25)
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := s.responses["first"]; ok || len(s.responses) != 2 || s.size != 20 {
+		t.Errorf("oldest response not removed: %d responses, size %d", len(s.responses), s.size)
+	}
+
+	s.sweep(time.Now().Add(2 * time.Hour))
+	if len(s.responses) != 0 || s.size != 0 {
+		t.Errorf("expired responses not removed: %d responses, size %d", len(s.responses), s.size)
+	}
+}
+
+func TestIdempotentReplayCompressed(t *testing.T) {
+	calls := 0
+	handler := middleware.DefaultCompress(Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"name":"sensor"}`))
+	})))
+	serve := func(encoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/devices/", strings.NewReader(`{"name":"sensor"}`))
+		req.Header.Set(TenantHeader, "idempotency-tenant")
+		req.Header.Set(IdempotencyKeyHeader, "compressed-key")
+		if encoding != "" {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, encoding := range []string{"gzip", "gzip", ""} {
+		rec := serve(encoding)
+		body := rec.Body.Bytes()
+		if rec.Header().Get("Content-Encoding") != encoding {
+			t.Fatalf("wrong encoding %q for %q", rec.Header().Get("Content-Encoding"), encoding)
+		}
+		if encoding == "gzip" {
+			r, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ = ioutil.ReadAll(r)
+		}
+		if string(body) != `{"name":"sensor"}` {
+			t.Errorf("wrong body %q for %q", body, encoding)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/willie68/AutoRestIoT/jobs"
+)
+
+/*
+JobRoutes getting all routes for the job endpoint
+*/
+func JobRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/", Documented(RouteDoc{
+		Summary:  "all jobs of the tenant",
+		Tag:      "jobs",
+		Response: []jobs.Job{},
+	}, GetJobsEndpoint))
+	router.Method(http.MethodGet, "/{id}", Documented(RouteDoc{
+		Summary:  "state, progress and result of a job",
+		Tag:      "jobs",
+		Response: jobs.Job{},
+		Errors:   []ErrorCode{CodeNotFound},
+	}, GetJobEndpoint))
+	router.Method(http.MethodGet, "/{id}/download", Documented(RouteDoc{
+		Summary: "downloading the file of a finished export job",
+		Tag:     "jobs",
+		Errors:  []ErrorCode{CodeNotFound},
+	}, GetJobDownloadEndpoint))
+	router.Method(http.MethodDelete, "/{id}", Documented(RouteDoc{
+		Summary:  "cancelling a queued or running job",
+		Tag:      "jobs",
+		Response: jobs.Job{},
+		Errors:   []ErrorCode{CodeNotFound},
+	}, DeleteJobEndpoint))
+	return router
+}
+
+/*
+Accepted submitting a job and answering with 202 and the job, the Location header is the job resource
+*/
+func Accepted(response http.ResponseWriter, req *http.Request, jobType string, params interface{}) {
+	job, err := jobs.Submit(jobType, getTenant(req), params)
+	acceptedJob(response, req, job, err)
+}
+
+/*
+acceptedJob answering a submitted job with 202
+*/
+func acceptedJob(response http.ResponseWriter, req *http.Request, job jobs.Job, err error) {
+	if err == jobs.ErrQueueFull {
+		Error(response, req, CodeUnavailable, err.Error())
+		return
+	}
+	if err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't submit job: %s", err.Error()))
+		return
+	}
+	log.WithContext(req.Context()).Infof("job %s (%s) submitted", job.ID, job.Type)
+	response.Header().Set("Location", jobPath(req, job.ID))
+	render.Status(req, http.StatusAccepted)
+	Render(response, req, withSelfLink(req, job))
+}
+
+/*
+GetJobsEndpoint getting all jobs of the tenant
+*/
+func GetJobsEndpoint(response http.ResponseWriter, req *http.Request) {
+	list := jobs.List(getTenant(req))
+	for i := range list {
+		list[i] = withSelfLink(req, list[i])
+	}
+	Render(response, req, list)
+}
+
+/*
+GetJobEndpoint getting the state, progress and result of a job
+*/
+func GetJobEndpoint(response http.ResponseWriter, req *http.Request) {
+	job, ok := tenantJob(req)
+	if !ok {
+		Error(response, req, CodeNotFound, fmt.Sprintf("job %s not found", chi.URLParam(req, "id")))
+		return
+	}
+	Render(response, req, withSelfLink(req, job))
+}
+
+/*
+DeleteJobEndpoint cancelling a queued or running job
+*/
+func DeleteJobEndpoint(response http.ResponseWriter, req *http.Request) {
+	job, ok := tenantJob(req)
+	if !ok {
+		Error(response, req, CodeNotFound, fmt.Sprintf("job %s not found", chi.URLParam(req, "id")))
+		return
+	}
+	job, err := jobs.Cancel(job.ID)
+	if err != nil {
+		Error(response, req, CodeNotFound, err.Error())
+		return
+	}
+	log.WithContext(req.Context()).Infof("job %s (%s) cancelled", job.ID, job.Type)
+	Render(response, req, withSelfLink(req, job))
+}
+
+/*
+tenantJob the job of the request, jobs of other tenants are not found
+*/
+func tenantJob(req *http.Request) (jobs.Job, bool) {
+	job, ok := jobs.Get(chi.URLParam(req, "id"))
+	if !ok || job.Tenant != getTenant(req) {
+		return jobs.Job{}, false
+	}
+	return job, true
+}
+
+func withSelfLink(req *http.Request, job jobs.Job) jobs.Job {
+	links := map[string]string{"self": jobPath(req, job.ID)}
+	for rel, href := range job.Links {
+		links[rel] = href
+	}
+	job.Links = links
+	return job
+}
+
+/*
+jobPath the path of the job resource in the api version of the request
+*/
+func jobPath(req *http.Request, id string) string {
+	for _, v := range versions {
+		if strings.HasPrefix(req.URL.Path, v.Path()+"/") {
+			return v.Path() + "/jobs/" + id
+		}
+	}
+	return ActualVersion().Path() + "/jobs/" + id
+}
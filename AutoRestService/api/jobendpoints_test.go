@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/willie68/AutoRestIoT/jobs"
+)
+
+func TestCancelJob(t *testing.T) {
+	jobs.Register("waiting", func(task *jobs.Task, params json.RawMessage) (interface{}, error) {
+		<-task.Done()
+		return nil, task.Err()
+	})
+	startJobs(t)
+	req := httptest.NewRequest(http.MethodPost, ActualVersion().Path()+"/jobs/", nil)
+	req.Header.Set(TenantHeader, "tenant")
+	rec := httptest.NewRecorder()
+	Accepted(rec, req, "waiting", nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+
+	// jobs of other tenants are not found
+	req = httptest.NewRequest(http.MethodDelete, location, nil)
+	req.Header.Set(TenantHeader, "other")
+	other := httptest.NewRecorder()
+	bulkRouter().ServeHTTP(other, req)
+	readProblem(t, other, http.StatusNotFound)
+
+	if rec := serveBulk(t, http.MethodDelete, location, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	job := waitForJob(t, rec)
+	if job.Status != jobs.StatusCancelled || job.Links["self"] != location {
+		t.Errorf("job not cancelled: %+v", job)
+	}
+
+	var list []jobs.Job
+	json.Unmarshal(serveBulk(t, http.MethodGet, "/jobs/", "", "").Body.Bytes(), &list)
+	if len(list) != 1 || list[0].ID != job.ID {
+		t.Errorf("wrong jobs: %+v", list)
+	}
+}
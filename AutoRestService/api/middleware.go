@@ -0,0 +1,72 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// RequestIDHeader header with the id of the request, taken from the caller or generated
+const RequestIDHeader = "X-Request-Id"
+
+/*
+RequestID taking the request id from the caller or generating a new one, the id is returned in the response header too
+*/
+func RequestID(next http.Handler) http.Handler {
+	return middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+}
+
+/*
+StreamingDeadlines removing the read and write deadlines of the server for the streaming requests, like the upload
+of an import or the streamed export, which are taking longer than the timeouts of the server. It must be the first
+middleware, the response controller needs the writer of the server.
+*/
+func StreamingDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streaming(r.URL.Path) {
+			rc := http.NewResponseController(w)
+			if err := rc.SetReadDeadline(time.Time{}); err != nil {
+				log.WithContext(r.Context()).Alertf("can't remove read deadline: %s", err.Error())
+			}
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+				log.WithContext(r.Context()).Alertf("can't remove write deadline: %s", err.Error())
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+Recoverer recovering from panics in the handlers, writing an internal error problem
+*/
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rvr := recover(); rvr != nil && rvr != http.ErrAbortHandler {
+				log.WithContext(r.Context()).Alertf("panic: %v\n%s", rvr, debug.Stack())
+				Error(w, r, CodeInternal, "")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+/*
+NotFoundHandler problem response for unknown routes
+*/
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, CodeNotFound, fmt.Sprintf("no route for %s", r.URL.Path))
+}
+
+/*
+MethodNotAllowedHandler problem response for unsupported methods of a route
+*/
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, CodeMethodNotAllowed, fmt.Sprintf("method %s not allowed", r.Method))
+}
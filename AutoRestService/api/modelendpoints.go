@@ -0,0 +1,354 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/willie68/AutoRestIoT/jobs"
+	"github.com/willie68/AutoRestIoT/logging"
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+ModelRoutes getting all routes for the documents of the models. Every document has a revision,
+the changing requests are honouring If-Match and If-Unmodified-Since against it.
+Documents of a defined model are validated against its definition.
+*/
+func ModelRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/{model}/", Documented(RouteDoc{
+		Summary:  "all documents of a model",
+		Tag:      "models",
+		Tenant:   true,
+		Response: []storage.Document{},
+		Errors:   []ErrorCode{CodeBadRequest},
+	}, GetDocumentsEndpoint))
+	router.Method(http.MethodPost, "/{model}/", Documented(RouteDoc{
+		Summary:  "creating a document",
+		Tag:      "models",
+		Tenant:   true,
+		Request:  map[string]interface{}{},
+		Response: storage.Document{},
+		Status:   http.StatusCreated,
+		Errors:   []ErrorCode{CodeBadRequest, CodeInvalidBody, CodeUnsupportedMediaType, CodeValidationFailed},
+	}, PostDocumentEndpoint))
+	router.Method(http.MethodPost, "/{model}/_import", Documented(RouteDoc{
+		Summary:  "importing documents from ndjson or csv as job, the report is the result of the job",
+		Tag:      "models",
+		Tenant:   true,
+		Response: jobs.Job{},
+		Status:   http.StatusAccepted,
+		Errors:   []ErrorCode{CodeBadRequest, CodeUnsupportedMediaType, CodeValidationFailed, CodeUnavailable},
+	}, PostImportEndpoint))
+	router.Method(http.MethodGet, "/{model}/_export", Documented(RouteDoc{
+		Summary: "streaming all documents as ndjson or csv, by the query parameter format or the Accept header",
+		Tag:     "models",
+		Tenant:  true,
+		Errors:  []ErrorCode{CodeBadRequest, CodeValidationFailed},
+	}, GetExportEndpoint))
+	router.Method(http.MethodPost, "/{model}/_export", Documented(RouteDoc{
+		Summary:  "exporting the documents as ndjson or csv as job, the file is downloaded with the download link of the job",
+		Tag:      "models",
+		Tenant:   true,
+		Response: jobs.Job{},
+		Status:   http.StatusAccepted,
+		Errors:   []ErrorCode{CodeBadRequest, CodeValidationFailed, CodeUnavailable},
+	}, PostExportEndpoint))
+	router.Method(http.MethodGet, "/{model}/{id}", Documented(RouteDoc{
+		Summary:  "a document, with ETag and Last-Modified of its revision",
+		Tag:      "models",
+		Tenant:   true,
+		Response: storage.Document{},
+		Errors:   []ErrorCode{CodeBadRequest, CodeNotFound},
+	}, GetDocumentEndpoint))
+	router.Method(http.MethodPut, "/{model}/{id}", Documented(RouteDoc{
+		Summary:  "replacing the data of a document",
+		Tag:      "models",
+		Tenant:   true,
+		Request:  map[string]interface{}{},
+		Response: storage.Document{},
+		Errors:   []ErrorCode{CodeBadRequest, CodeInvalidBody, CodeUnsupportedMediaType, CodeValidationFailed, CodeNotFound, CodePreconditionFailed},
+	}, PutDocumentEndpoint))
+	router.Method(http.MethodPatch, "/{model}/{id}", Documented(RouteDoc{
+		Summary:  "changing a document with a merge patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json)",
+		Tag:      "models",
+		Tenant:   true,
+		Request:  []PatchOperation{},
+		Response: storage.Document{},
+		Errors: []ErrorCode{CodeBadRequest, CodeInvalidBody, CodeUnsupportedMediaType, CodeInvalidPatch, CodeConflict,
+			CodeValidationFailed, CodeNotFound, CodePreconditionFailed},
+	}, PatchDocumentEndpoint))
+	router.Method(http.MethodDelete, "/{model}/{id}", Documented(RouteDoc{
+		Summary:  "moving a document into the trash",
+		Tag:      "models",
+		Tenant:   true,
+		Response: storage.Document{},
+		Errors:   []ErrorCode{CodeBadRequest, CodeNotFound, CodePreconditionFailed},
+	}, DeleteDocumentEndpoint))
+	return router
+}
+
+/*
+GetDocumentsEndpoint getting all documents of a model
+*/
+func GetDocumentsEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	Render(response, req, storage.List(tenant, model))
+}
+
+/*
+PostDocumentEndpoint creating a document, the Location header is the document resource
+*/
+func PostDocumentEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	data, p := decodeDocument(req)
+	if p == nil {
+		p = validateDocument(model, data)
+	}
+	if p != nil {
+		WriteProblem(response, req, p)
+		return
+	}
+	doc, err := storage.Writes(req.Context()).Create(tenant, model, data)
+	if err != nil {
+		Error(response, req, CodeInternal, fmt.Sprintf("can't create document: %s", err.Error()))
+		return
+	}
+	log.WithContext(req.Context()).With(logging.ScopeTenant, tenant).Debugf("document %s of model %s created", doc.ID, model)
+	response.Header().Set("Location", strings.TrimSuffix(req.URL.Path, "/")+"/"+doc.ID)
+	SetRevision(response, ETag(doc.Revision), doc.Modified)
+	render.Status(req, http.StatusCreated)
+	Render(response, req, doc)
+}
+
+/*
+GetDocumentEndpoint getting a document, If-None-Match and If-Modified-Since are answered with 304
+*/
+func GetDocumentEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	doc, err := storage.Get(tenant, model, chi.URLParam(req, "id"))
+	if err != nil {
+		documentError(response, req, err)
+		return
+	}
+	etag := ETag(doc.Revision)
+	if !CheckPreconditions(response, req, etag, doc.Modified) {
+		return
+	}
+	SetRevision(response, etag, doc.Modified)
+	response.Header().Set("Accept-Patch", AcceptPatch)
+	Render(response, req, doc)
+}
+
+/*
+PutDocumentEndpoint replacing the data of a document. With If-Match or If-Unmodified-Since a changed document
+is answered with 412, so no update of another client gets lost.
+*/
+func PutDocumentEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	data, p := decodeDocument(req)
+	if p == nil {
+		p = validateDocument(model, data)
+	}
+	if p != nil {
+		WriteProblem(response, req, p)
+		return
+	}
+	doc, err := storage.Writes(req.Context()).Update(tenant, model, chi.URLParam(req, "id"), func(doc storage.Document) (map[string]interface{}, error) {
+		if p := Precondition(req, ETag(doc.Revision), doc.Modified); p != nil {
+			return nil, p
+		}
+		return data, nil
+	})
+	if err != nil {
+		documentError(response, req, err)
+		return
+	}
+	SetRevision(response, ETag(doc.Revision), doc.Modified)
+	Render(response, req, doc)
+}
+
+/*
+PatchDocumentEndpoint changing a document by a merge patch or a JSON Patch. The patch is applied and the result
+validated atomically in the storage, preconditions like PUT.
+*/
+func PatchDocumentEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	patch, p := ReadPatch(req)
+	if p != nil {
+		response.Header().Set("Accept-Patch", AcceptPatch)
+		WriteProblem(response, req, p)
+		return
+	}
+	doc, err := storage.Writes(req.Context()).Update(tenant, model, chi.URLParam(req, "id"), func(doc storage.Document) (map[string]interface{}, error) {
+		if p := Precondition(req, ETag(doc.Revision), doc.Modified); p != nil {
+			return nil, p
+		}
+		actual, err := json.Marshal(doc.Data)
+		if err != nil {
+			return nil, err
+		}
+		patched, p := patch.Apply(actual)
+		if p != nil {
+			return nil, p
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(patched, &data); err != nil || data == nil {
+			return nil, NewProblem(CodeInvalidPatch, "the patched document must be an object")
+		}
+		if p := validateDocument(model, data); p != nil {
+			return nil, p
+		}
+		return data, nil
+	})
+	if err != nil {
+		documentError(response, req, err)
+		return
+	}
+	SetRevision(response, ETag(doc.Revision), doc.Modified)
+	Render(response, req, doc)
+}
+
+/*
+DeleteDocumentEndpoint moving a document into the trash, honouring If-Match and If-Unmodified-Since like PUT.
+It can be restored until the retention of the trash is expired.
+*/
+func DeleteDocumentEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant, model, ok := modelRequest(response, req)
+	if !ok {
+		return
+	}
+	id := chi.URLParam(req, "id")
+	var doc storage.Document
+	item, err := trash.Move(trash.KindDocument, tenant, model+"/"+id, nil, func(item trash.Item) error {
+		var err error
+		doc, err = storage.Writes(req.Context()).TrashDocument(tenant, model, id, item.ID, func(doc storage.Document) error {
+			if p := Precondition(req, ETag(doc.Revision), doc.Modified); p != nil {
+				return p
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		documentError(response, req, err)
+		return
+	}
+	log.WithContext(req.Context()).With(logging.ScopeTenant, tenant).Debugf("document %s of model %s moved to trash %s", doc.ID, model, item.ID)
+	Render(response, req, doc)
+}
+
+/*
+modelRequest the tenant and the model of the request, on an error the response is already written
+*/
+func modelRequest(response http.ResponseWriter, req *http.Request) (string, string, bool) {
+	tenant := getTenant(req)
+	if tenant == "" {
+		Error(response, req, CodeTenantMissing, "")
+		return "", "", false
+	}
+	model := chi.URLParam(req, "model")
+	if !storage.ValidModel(model) {
+		Error(response, req, CodeBadRequest, fmt.Sprintf("invalid model name: %s", model))
+		return "", "", false
+	}
+	return tenant, model, true
+}
+
+/*
+decodeDocument decoding the data of a document in any supported encoding. The result is converted to the json
+types, so the stored data is the same for all encodings.
+*/
+func decodeDocument(req *http.Request) (map[string]interface{}, *Problem) {
+	var decoded interface{}
+	if p := Decode(req, &decoded); p != nil {
+		return nil, p
+	}
+	raw, err := json.Marshal(jsonValue(decoded))
+	if err != nil {
+		return nil, NewProblem(CodeInvalidBody, err.Error())
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil || data == nil {
+		return nil, NewProblem(CodeInvalidBody, "the document must be an object")
+	}
+	return data, nil
+}
+
+/*
+validateDocument validating the data against the definition of the model
+*/
+func validateDocument(model string, data map[string]interface{}) *Problem {
+	errs := storage.Validate(model, data)
+	if len(errs) == 0 {
+		return nil
+	}
+	p := NewProblem(CodeValidationFailed, fmt.Sprintf("the document doesn't match the model %s", model))
+	for _, e := range errs {
+		p.WithErrors(FieldError{Field: e.Field, Message: e.Message})
+	}
+	return p
+}
+
+/*
+jsonValue converting the maps with any key type of cbor and msgpack to maps with string keys
+*/
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			m[fmt.Sprint(key)] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			m[key] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = jsonValue(e)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+/*
+documentError writing the error of the storage, a failed precondition is returned by the handlers as problem
+*/
+func documentError(response http.ResponseWriter, req *http.Request, err error) {
+	if p, ok := err.(*Problem); ok {
+		WriteProblem(response, req, p)
+		return
+	}
+	if err == storage.ErrNotFound {
+		Error(response, req, CodeNotFound, fmt.Sprintf("document %s not found", chi.URLParam(req, "id")))
+		return
+	}
+	Error(response, req, CodeInternal, err.Error())
+}
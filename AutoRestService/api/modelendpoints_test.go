@@ -0,0 +1,234 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+serveModel executing a request against the model routes of the tenant "tenant"
+*/
+func serveModel(t *testing.T, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(TenantHeader, "tenant")
+	if body != "" {
+		req.Header.Set("Content-Type", ContentTypeJSON)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	ModelRoutes().ServeHTTP(rec, req)
+	return rec
+}
+
+/*
+createDocument creating a document, returns its path and etag
+*/
+func createDocument(t *testing.T, body string) (string, string) {
+	t.Helper()
+	if err := storage.Start(storage.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	trash.Register(trash.KindDocument, storage.TrashHandler{})
+	rec := serveModel(t, http.MethodPost, "/devices/", body, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var doc storage.Document
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	path := rec.Header().Get("Location")
+	if path != "/devices/"+doc.ID {
+		t.Errorf("wrong location %s", path)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != ETag(1) || rec.Header().Get("Last-Modified") == "" {
+		t.Errorf("no revision headers: %v", rec.Header())
+	}
+	return path, etag
+}
+
+func TestDocumentRevision(t *testing.T) {
+	path, etag := createDocument(t, `{"name":"sensor","battery":80}`)
+	rec := serveModel(t, http.MethodGet, path, "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("status %d, etag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+	rec = serveModel(t, http.MethodGet, path, "", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d", rec.Code)
+	}
+	modified := rec.Header().Get("Last-Modified")
+	rec = serveModel(t, http.MethodGet, path, "", map[string]string{"If-Modified-Since": modified})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d", rec.Code)
+	}
+
+	rec = serveModel(t, http.MethodPut, path, `{"name":"sensor","battery":70}`, map[string]string{"If-Match": etag})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != ETag(2) {
+		t.Fatalf("status %d, etag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body.String())
+	}
+	var doc storage.Document
+	json.Unmarshal(rec.Body.Bytes(), &doc)
+	if doc.Revision != 2 || doc.Data["battery"] != 70.0 {
+		t.Errorf("wrong updated document: %+v", doc)
+	}
+}
+
+func TestLostUpdate(t *testing.T) {
+	path, etag := createDocument(t, `{"battery":80}`)
+	// the first client is changing the document
+	if rec := serveModel(t, http.MethodPut, path, `{"battery":70}`, map[string]string{"If-Match": etag}); rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	// the second client with the old revision
+	rec := serveModel(t, http.MethodPut, path, `{"battery":60}`, map[string]string{"If-Match": etag})
+	p := readProblem(t, rec, http.StatusPreconditionFailed)
+	if p.Code != CodePreconditionFailed || !strings.Contains(p.Detail, ETag(2)) {
+		t.Errorf("wrong problem: %+v", p)
+	}
+	rec = serveModel(t, http.MethodDelete, path, "", map[string]string{"If-Match": etag})
+	readProblem(t, rec, http.StatusPreconditionFailed)
+
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	rec = serveModel(t, http.MethodPut, path, `{"battery":60}`, map[string]string{"If-Unmodified-Since": past})
+	readProblem(t, rec, http.StatusPreconditionFailed)
+
+	doc, _ := storage.Get("tenant", "devices", strings.TrimPrefix(path, "/devices/"))
+	if doc.Revision != 2 || doc.Data["battery"] != 70.0 {
+		t.Errorf("document changed by a failed request: %+v", doc)
+	}
+
+	rec = serveModel(t, http.MethodDelete, path, "", map[string]string{"If-Match": ETag(2)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	readProblem(t, serveModel(t, http.MethodGet, path, "", nil), http.StatusNotFound)
+}
+
+func TestDocumentErrors(t *testing.T) {
+	createDocument(t, `{}`)
+	readProblem(t, serveModel(t, http.MethodPost, "/devices/", `[1,2]`, nil), http.StatusBadRequest)
+	readProblem(t, serveModel(t, http.MethodPut, "/devices/unknown", `{}`, nil), http.StatusNotFound)
+	readProblem(t, serveModel(t, http.MethodGet, "/dev.ices/", "", nil), http.StatusBadRequest)
+
+	req := httptest.NewRequest(http.MethodGet, "/devices/", nil)
+	rec := httptest.NewRecorder()
+	ModelRoutes().ServeHTTP(rec, req)
+	readProblem(t, rec, http.StatusBadRequest)
+}
+
+func TestPrecondition(t *testing.T) {
+	modified := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		method  string
+		header  string
+		value   string
+		failing bool
+	}{
+		{http.MethodPut, "If-Match", `"3"`, false},
+		{http.MethodPut, "If-Match", `"2", "3"`, false},
+		{http.MethodPut, "If-Match", `*`, false},
+		{http.MethodPut, "If-Match", `"2"`, true},
+		{http.MethodPut, "If-Match", `W/"3"`, true},
+		{http.MethodDelete, "If-Unmodified-Since", modified.Format(http.TimeFormat), false},
+		{http.MethodDelete, "If-Unmodified-Since", modified.Add(-time.Second).Format(http.TimeFormat), true},
+		{http.MethodPut, "If-None-Match", `*`, true},
+		{http.MethodGet, "If-None-Match", `*`, false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, "/", nil)
+		req.Header.Set(test.header, test.value)
+		p := Precondition(req, ETag(3), modified)
+		if (p != nil) != test.failing {
+			t.Errorf("%s %s: %s, failing %v", test.method, test.header, test.value, p != nil)
+		}
+		if p != nil && p.Status != http.StatusPreconditionFailed {
+			t.Errorf("status %d", p.Status)
+		}
+	}
+}
+
+func TestPatchDocument(t *testing.T) {
+	path, etag := createDocument(t, `{"name":"sensor","battery":80,"tags":["a"]}`)
+	rec := serveModel(t, http.MethodGet, path, "", nil)
+	if rec.Header().Get("Accept-Patch") != AcceptPatch {
+		t.Errorf("accept patch %q", rec.Header().Get("Accept-Patch"))
+	}
+
+	rec = serveModel(t, http.MethodPatch, path, `{"battery":70}`, nil)
+	readProblem(t, rec, http.StatusUnsupportedMediaType)
+	if rec.Header().Get("Accept-Patch") != AcceptPatch {
+		t.Error("no Accept-Patch on 415")
+	}
+
+	rec = serveModel(t, http.MethodPatch, path, `{"battery":70,"name":null}`,
+		map[string]string{"Content-Type": ContentTypeMergePatch, "If-Match": etag})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != ETag(2) {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var doc storage.Document
+	json.Unmarshal(rec.Body.Bytes(), &doc)
+	if _, ok := doc.Data["name"]; ok || doc.Data["battery"] != 70.0 {
+		t.Errorf("merge patch not applied: %+v", doc.Data)
+	}
+
+	rec = serveModel(t, http.MethodPatch, path, `[{"op":"add","path":"/tags/-","value":"b"}]`,
+		map[string]string{"Content-Type": ContentTypeJSONPatch})
+	json.Unmarshal(rec.Body.Bytes(), &doc)
+	if rec.Code != http.StatusOK || len(doc.Data["tags"].([]interface{})) != 2 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// lost update, failing test operation and invalid patch are keeping the document
+	readProblem(t, serveModel(t, http.MethodPatch, path, `{"battery":60}`,
+		map[string]string{"Content-Type": ContentTypeMergePatch, "If-Match": etag}), http.StatusPreconditionFailed)
+	readProblem(t, serveModel(t, http.MethodPatch, path, `[{"op":"remove","path":"/tags/0"},{"op":"test","path":"/battery","value":80}]`,
+		map[string]string{"Content-Type": ContentTypeJSONPatch}), http.StatusConflict)
+	readProblem(t, serveModel(t, http.MethodPatch, path, `[{"op":"remove","path":"/unknown"}]`,
+		map[string]string{"Content-Type": ContentTypeJSONPatch}), http.StatusUnprocessableEntity)
+	readProblem(t, serveModel(t, http.MethodPatch, path, `[{"op":"replace","path":"","value":[1]}]`,
+		map[string]string{"Content-Type": ContentTypeJSONPatch}), http.StatusUnprocessableEntity)
+	stored, _ := storage.Get("tenant", "devices", doc.ID)
+	if stored.Revision != 3 || len(stored.Data["tags"].([]interface{})) != 2 {
+		t.Errorf("document changed by a failed patch: %+v", stored)
+	}
+}
+
+func TestValidateDocument(t *testing.T) {
+	storage.SetModels([]storage.Model{{Name: "devices", Fields: []storage.Field{
+		{Name: "name", Type: storage.TypeString, Required: true},
+		{Name: "battery", Type: storage.TypeInteger},
+	}}})
+	defer storage.SetModels(nil)
+	path, _ := createDocument(t, `{"name":"sensor","battery":80}`)
+
+	p := readProblem(t, serveModel(t, http.MethodPost, "/devices/", `{"battery":8.5}`, nil), http.StatusUnprocessableEntity)
+	if p.Code != CodeValidationFailed || len(p.Errors) != 2 {
+		t.Errorf("wrong problem: %+v", p)
+	}
+	readProblem(t, serveModel(t, http.MethodPut, path, `{"battery":80}`, nil), http.StatusUnprocessableEntity)
+	p = readProblem(t, serveModel(t, http.MethodPatch, path, `{"name":null}`,
+		map[string]string{"Content-Type": ContentTypeMergePatch}), http.StatusUnprocessableEntity)
+	if len(p.Errors) != 1 || p.Errors[0].Field != "name" {
+		t.Errorf("wrong errors: %+v", p.Errors)
+	}
+	if rec := serveModel(t, http.MethodPatch, path, `{"battery":50,"extra":true}`,
+		map[string]string{"Content-Type": ContentTypeMergePatch}); rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	// models without definition are not validated
+	if rec := serveModel(t, http.MethodPost, "/readings/", `{"battery":"full"}`, nil); rec.Code != http.StatusCreated {
+		t.Errorf("status %d", rec.Code)
+	}
+}
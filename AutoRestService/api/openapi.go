@@ -0,0 +1,421 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+/*
+OpenAPI the parts of an OpenAPI 3 document used by this service
+*/
+type OpenAPI struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       OpenAPIInfo                             `json:"info"`
+	Servers    []OpenAPIServer                         `json:"servers"`
+	Security   []map[string][]string                   `json:"security"`
+	Paths      map[string]map[string]*OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                       `json:"components"`
+}
+
+/*
+OpenAPIInfo info section of the document
+*/
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+/*
+OpenAPIServer a server of the api
+*/
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+/*
+OpenAPIComponents the reusable schemas, parameters and security schemes
+*/
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema               `json:"schemas"`
+	Parameters      map[string]*OpenAPIParameter     `json:"parameters"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+/*
+OpenAPISecurityScheme an api key security scheme
+*/
+type OpenAPISecurityScheme struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+/*
+OpenAPIParameter a path or header parameter, or a reference to one
+*/
+type OpenAPIParameter struct {
+	Ref         string  `json:"$ref,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	In          string  `json:"in,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+/*
+OpenAPIOperation a single operation of a path
+*/
+type OpenAPIOperation struct {
+	OperationID string                      `json:"operationId,omitempty"`
+	Security    *[]map[string][]string      `json:"security,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	Tags        []string                    `json:"tags,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Parameters  []*OpenAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenAPIResponse `json:"responses"`
+}
+
+/*
+OpenAPIRequestBody the body of an operation
+*/
+type OpenAPIRequestBody struct {
+	Required bool                         `json:"required"`
+	Content  map[string]*OpenAPIMediaType `json:"content"`
+}
+
+/*
+OpenAPIResponse a response of an operation
+*/
+type OpenAPIResponse struct {
+	Description string                       `json:"description"`
+	Content     map[string]*OpenAPIMediaType `json:"content,omitempty"`
+}
+
+/*
+OpenAPIMediaType the schema of a body in one media type
+*/
+type OpenAPIMediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+/*
+Schema a json schema as used by OpenAPI 3
+*/
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+/*
+RouteDoc documentation of a route, the request and response are example values of the body types
+*/
+type RouteDoc struct {
+	Summary string
+	Tag     string
+	Tenant  bool
+	// Public the route is served without system id and api key
+	Public   bool
+	Request  interface{}
+	Response interface{}
+	Status   int
+	Errors   []ErrorCode
+}
+
+/*
+documented a handler with the documentation of its route, the OpenAPI document is generated from the routers
+*/
+type documented struct {
+	http.HandlerFunc
+	doc RouteDoc
+}
+
+/*
+Documented adding the documentation of the route to the handler, to be registered with router.Method
+*/
+func Documented(doc RouteDoc, handler http.HandlerFunc) http.Handler {
+	return documented{HandlerFunc: handler, doc: doc}
+}
+
+// errors possible on all routes
+var commonErrors = []ErrorCode{CodeNotAcceptable, CodeInternal}
+
+/*
+OpenAPI generating the OpenAPI document of this version from the registered routes
+*/
+func (v *Version) OpenAPI() (*OpenAPI, error) {
+	g := &generator{
+		doc: &OpenAPI{
+			OpenAPI: "3.0.3",
+			Info: OpenAPIInfo{
+				Title:       "AutoRest-Service",
+				Description: "The AutoRest service is a IoT backend service.",
+				Version:     v.Name,
+			},
+			// relative, the service is reachable under different urls
+			Servers: []OpenAPIServer{{URL: v.Path()}},
+			Security: []map[string][]string{
+				{"system": {}, "apikey": {}},
+			},
+			Paths: make(map[string]map[string]*OpenAPIOperation),
+			Components: OpenAPIComponents{
+				Schemas: make(map[string]*Schema),
+				Parameters: map[string]*OpenAPIParameter{
+					"idempotencyKey": {
+						Name:        IdempotencyKeyHeader,
+						In:          "header",
+						Description: "retries with the same key are getting the first response",
+						Schema:      &Schema{Type: "string"},
+					},
+					"tenant": {
+						Name:        TenantHeader,
+						In:          "header",
+						Description: "the tenant of the data",
+						Required:    true,
+						Schema:      &Schema{Type: "string"},
+					},
+				},
+				SecuritySchemes: map[string]OpenAPISecurityScheme{
+					"system": {Type: "apiKey", Name: SystemHeader, In: "header"},
+					"apikey": {Type: "apiKey", Name: APIKeyHeader, In: "header"},
+				},
+			},
+		},
+	}
+	g.problemSchema()
+
+	router := v.Router()
+	err := chi.Walk(router, func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		path := cleanPattern(route)
+		if g.doc.Paths[path] == nil {
+			g.doc.Paths[path] = make(map[string]*OpenAPIOperation)
+		}
+		var rd RouteDoc
+		if d, ok := handler.(documented); ok {
+			rd = d.doc
+		}
+		g.doc.Paths[path][strings.ToLower(method)] = g.operation(method, path, rd, v.Deprecated())
+		return nil
+	})
+	return g.doc, err
+}
+
+type generator struct {
+	doc *OpenAPI
+}
+
+func (g *generator) operation(method string, path string, rd RouteDoc, deprecated bool) *OpenAPIOperation {
+	op := &OpenAPIOperation{
+		OperationID: operationID(method, path),
+		Summary:     rd.Summary,
+		Deprecated:  deprecated,
+		Responses:   make(map[string]*OpenAPIResponse),
+	}
+	if rd.Tag != "" {
+		op.Tags = []string{rd.Tag}
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			op.Parameters = append(op.Parameters, &OpenAPIParameter{
+				Name:     strings.Split(strings.Trim(segment, "{}"), ":")[0],
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+	}
+	errs := append([]ErrorCode{}, commonErrors...)
+	if rd.Public {
+		// an empty list overrides the global security requirement
+		op.Security = &[]map[string][]string{}
+	} else {
+		errs = append(errs, CodeUnauthorized)
+	}
+	if method == http.MethodPost || method == http.MethodPatch {
+		op.Parameters = append(op.Parameters, &OpenAPIParameter{Ref: "#/components/parameters/idempotencyKey"})
+		errs = append(errs, CodeIdempotencyKeyReused, CodeConflict)
+	}
+	if rd.Tenant {
+		op.Parameters = append(op.Parameters, &OpenAPIParameter{Ref: "#/components/parameters/tenant"})
+		errs = append(errs, CodeTenantMissing)
+	}
+	if rd.Request != nil {
+		schema := g.schema(reflect.TypeOf(rd.Request))
+		op.RequestBody = &OpenAPIRequestBody{Required: true, Content: make(map[string]*OpenAPIMediaType)}
+		for mediaType, c := range codecs {
+			if c.Unmarshal != nil {
+				op.RequestBody.Content[mediaType] = &OpenAPIMediaType{Schema: schema}
+			}
+		}
+		errs = append(errs, CodeInvalidBody, CodeUnsupportedMediaType)
+	}
+	errs = append(errs, rd.Errors...)
+
+	status := rd.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := &OpenAPIResponse{Description: http.StatusText(status)}
+	if rd.Response != nil {
+		schema := g.schema(reflect.TypeOf(rd.Response))
+		response.Content = make(map[string]*OpenAPIMediaType)
+		for _, mediaType := range MediaTypes() {
+			response.Content[mediaType] = &OpenAPIMediaType{Schema: schema}
+		}
+	}
+	op.Responses[fmt.Sprintf("%d", status)] = response
+	if method == http.MethodGet && rd.Response != nil {
+		// the etag of the content is checked by Render
+		op.Parameters = append(op.Parameters, &OpenAPIParameter{
+			Name:   "If-None-Match",
+			In:     "header",
+			Schema: &Schema{Type: "string"},
+		})
+		op.Responses[fmt.Sprintf("%d", http.StatusNotModified)] = &OpenAPIResponse{Description: http.StatusText(http.StatusNotModified)}
+	}
+
+	for _, code := range errs {
+		def := ErrorCatalog[code]
+		key := fmt.Sprintf("%d", def.Status)
+		if r, ok := op.Responses[key]; ok {
+			r.Description += ", " + string(code)
+			continue
+		}
+		op.Responses[key] = &OpenAPIResponse{
+			Description: string(code),
+			Content: map[string]*OpenAPIMediaType{
+				ProblemContentType: {Schema: &Schema{Ref: "#/components/schemas/Problem"}},
+			},
+		}
+	}
+	return op
+}
+
+/*
+problemSchema the schema of the problem responses with all error codes
+*/
+func (g *generator) problemSchema() {
+	g.schema(reflect.TypeOf(Problem{}))
+	codes := make([]string, 0, len(ErrorCatalog))
+	for code := range ErrorCatalog {
+		codes = append(codes, string(code))
+	}
+	sort.Strings(codes)
+	g.doc.Components.Schemas["Problem"].Properties["code"].Enum = codes
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+/*
+schema the schema of a go type, named structs are added to the components and referenced
+*/
+func (g *generator) schema(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return &Schema{Type: "string", Format: "date-time"}
+		}
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		ref := &Schema{Ref: "#/components/schemas/" + t.Name()}
+		if _, ok := g.doc.Components.Schemas[t.Name()]; !ok {
+			// registered before the fields, for recursive types
+			g.doc.Components.Schemas[t.Name()] = &Schema{}
+			g.doc.Components.Schemas[t.Name()] = g.structSchema(t)
+		}
+		return ref
+	default:
+		// interface{}, any value
+		return &Schema{}
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		omitempty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			parts := strings.Split(tag, ",")
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, option := range parts[1:] {
+				omitempty = omitempty || option == "omitempty"
+			}
+		}
+		s.Properties[name] = g.schema(field.Type)
+		if !omitempty && field.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+/*
+operationID a stable id from method and path, like getAdminLogging
+*/
+func operationID(method string, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '_' || r == '.'
+	}) {
+		b.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return b.String()
+}
+
+/*
+GetOpenAPIEndpoint getting the OpenAPI document of this version
+*/
+func (v *Version) GetOpenAPIEndpoint(response http.ResponseWriter, req *http.Request) {
+	doc, err := v.OpenAPI()
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	Render(response, req, doc)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	doc, err := ActualVersion().OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Security) != 1 {
+		t.Errorf("global security missing: %v", doc.Security)
+	}
+	for path, ops := range doc.Paths {
+		for method, op := range ops {
+			// every route is documented at its registration
+			if op.Summary == "" || len(op.Tags) == 0 {
+				t.Errorf("%s %s not documented", method, path)
+			}
+		}
+	}
+	op := doc.Paths["/trash/{id}"]["delete"]
+	if op == nil || op.Summary != "deleting an item permanently" || op.Responses["401"] == nil || op.Security != nil {
+		t.Errorf("wrong protected operation: %+v", op)
+	}
+	if len(op.Parameters) != 2 || op.Parameters[0].Name != "id" {
+		t.Errorf("wrong parameters: %+v", op.Parameters)
+	}
+}
+
+func TestOpenAPIPublicRoutes(t *testing.T) {
+	doc, err := ActualVersion().OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(doc)
+	var raw struct {
+		Paths map[string]map[string]map[string]interface{} `json:"paths"`
+	}
+	json.Unmarshal(data, &raw)
+	for _, path := range []string{"/openapi.json", "/docs"} {
+		op := raw.Paths[path]["get"]
+		security, ok := op["security"].([]interface{})
+		if !ok || len(security) != 0 {
+			t.Errorf("%s: security %v, want an empty list", path, op["security"])
+		}
+		if _, ok := op["responses"].(map[string]interface{})["401"]; ok {
+			t.Errorf("%s: public route with 401", path)
+		}
+	}
+	if _, ok := raw.Paths["/_batch"]["post"]["security"]; ok {
+		t.Error("protected route with own security")
+	}
+}
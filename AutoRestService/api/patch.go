@@ -0,0 +1,365 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// media types of the PATCH request bodies
+const (
+	ContentTypeMergePatch = "application/merge-patch+json"
+	ContentTypeJSONPatch  = "application/json-patch+json"
+)
+
+// AcceptPatch the value of the Accept-Patch header of the patchable resources
+const AcceptPatch = ContentTypeMergePatch + ", " + ContentTypeJSONPatch
+
+/*
+PatchOperation a single operation of a RFC 6902 JSON Patch
+*/
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+/*
+RequestPatch the body of a PATCH request with its media type
+*/
+type RequestPatch struct {
+	MediaType string
+	Body      []byte
+}
+
+/*
+ReadPatch reading the body of a PATCH request, by its Content-Type a RFC 7396 merge patch or a RFC 6902 JSON Patch.
+The body is read before the document is locked for the update.
+*/
+func ReadPatch(r *http.Request) (RequestPatch, *Problem) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != ContentTypeMergePatch && mediaType != ContentTypeJSONPatch) {
+		return RequestPatch{}, NewProblem(CodeUnsupportedMediaType, fmt.Sprintf("supported media types: %s", AcceptPatch))
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return RequestPatch{}, NewProblem(CodeInvalidBody, err.Error())
+	}
+	return RequestPatch{MediaType: mediaType, Body: body}, nil
+}
+
+/*
+Apply applying the patch to the json document. The result has to be validated like a complete document before it's stored.
+*/
+func (p RequestPatch) Apply(doc []byte) ([]byte, *Problem) {
+	var result []byte
+	var err error
+	if p.MediaType == ContentTypeMergePatch {
+		result, err = MergePatch(doc, p.Body)
+	} else {
+		var ops []PatchOperation
+		if err := json.Unmarshal(p.Body, &ops); err != nil {
+			return nil, NewProblem(CodeInvalidBody, err.Error())
+		}
+		result, err = JSONPatch(doc, ops)
+	}
+	if err != nil {
+		if _, ok := err.(*patchTestError); ok {
+			return nil, NewProblem(CodeConflict, err.Error())
+		}
+		return nil, NewProblem(CodeInvalidPatch, err.Error())
+	}
+	return result, nil
+}
+
+/*
+MergePatch applying a RFC 7396 merge patch to the json document, null values are removing members
+*/
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if len(doc) > 0 {
+		if err := json.Unmarshal(doc, &target); err != nil {
+			return nil, fmt.Errorf("can't parse document: %s", err.Error())
+		}
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("can't parse merge patch: %s", err.Error())
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+			continue
+		}
+		t[key] = mergeValue(t[key], value)
+	}
+	return t
+}
+
+type patchTestError struct {
+	path string
+}
+
+func (e *patchTestError) Error() string {
+	return fmt.Sprintf("test failed for %s", e.path)
+}
+
+/*
+JSONPatch applying the operations of a RFC 6902 JSON Patch to the json document.
+The operations are applied all or none, the first failing operation is returned as error.
+*/
+func JSONPatch(doc []byte, ops []PatchOperation) ([]byte, error) {
+	var root interface{}
+	if err := json.Unmarshal(doc, &root); err != nil {
+		return nil, fmt.Errorf("can't parse document: %s", err.Error())
+	}
+	for i, op := range ops {
+		var err error
+		root, err = applyOperation(root, op)
+		if err != nil {
+			if _, ok := err.(*patchTestError); ok {
+				return nil, err
+			}
+			return nil, fmt.Errorf("operation %d (%s %s): %s", i, op.Op, op.Path, err.Error())
+		}
+	}
+	return json.Marshal(root)
+}
+
+func applyOperation(root interface{}, op PatchOperation) (interface{}, error) {
+	value := func() (interface{}, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("value missing")
+		}
+		var v interface{}
+		err := json.Unmarshal(op.Value, &v)
+		return v, err
+	}
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, op.Path, v)
+	case "remove":
+		root, _, err := removeValue(root, op.Path)
+		return root, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if root, _, err = removeValue(root, op.Path); err != nil {
+			return nil, err
+		}
+		return addValue(root, op.Path, v)
+	case "move":
+		if op.Path != op.From && strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("can't move %s into itself", op.From)
+		}
+		root, v, err := removeValue(root, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(root, op.Path, v)
+	case "copy":
+		v, err := getValue(root, op.From)
+		if err != nil {
+			return nil, err
+		}
+		// a deep copy, the value must not be shared
+		data, _ := json.Marshal(v)
+		var c interface{}
+		json.Unmarshal(data, &c)
+		return addValue(root, op.Path, c)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		actual, err := getValue(root, op.Path)
+		if err != nil || !reflect.DeepEqual(actual, v) {
+			return nil, &patchTestError{path: op.Path}
+		}
+		return root, nil
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+/*
+parsePointer splitting a RFC 6901 json pointer into its unescaped tokens
+*/
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("wrong json pointer: %s", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("wrong array index: %s", token)
+	}
+	max := length - 1
+	if allowEnd {
+		max = length
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index out of bounds: %s", token)
+	}
+	return index, nil
+}
+
+func getValue(root interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := root
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found: %s", pointer)
+			}
+			current = v
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path not found: %s", pointer)
+		}
+	}
+	return current, nil
+}
+
+/*
+parent the container of the value the pointer is pointing to and the last token
+*/
+func parent(root interface{}, pointer string) (interface{}, string, string, error) {
+	index := strings.LastIndex(pointer, "/")
+	if index < 0 {
+		return nil, "", "", fmt.Errorf("wrong json pointer: %s", pointer)
+	}
+	container, err := getValue(root, pointer[:index])
+	if err != nil {
+		return nil, "", "", err
+	}
+	tokens, _ := parsePointer(pointer[index:])
+	return container, pointer[:index], tokens[0], nil
+}
+
+func addValue(root interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	container, parentPointer, token, err := parent(root, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch node := container.(type) {
+	case map[string]interface{}:
+		node[token] = value
+		return root, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(node), true)
+		if err != nil {
+			return nil, err
+		}
+		node = append(node, nil)
+		copy(node[index+1:], node[index:])
+		node[index] = value
+		// the slice header has changed, so it's set again in its parent
+		return addOrSet(root, parentPointer, node)
+	default:
+		return nil, fmt.Errorf("path not found: %s", pointer)
+	}
+}
+
+func removeValue(root interface{}, pointer string) (interface{}, interface{}, error) {
+	if pointer == "" {
+		return nil, root, nil
+	}
+	container, parentPointer, token, err := parent(root, pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch node := container.(type) {
+	case map[string]interface{}:
+		v, ok := node[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("path not found: %s", pointer)
+		}
+		delete(node, token)
+		return root, v, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := node[index]
+		list := append(append(make([]interface{}, 0, len(node)-1), node[:index]...), node[index+1:]...)
+		root, err = addOrSet(root, parentPointer, list)
+		return root, v, err
+	default:
+		return nil, nil, fmt.Errorf("path not found: %s", pointer)
+	}
+}
+
+/*
+addOrSet setting an array in its parent container, without inserting into a parent array
+*/
+func addOrSet(root interface{}, pointer string, value interface{}) (interface{}, error) {
+	if pointer == "" {
+		return value, nil
+	}
+	container, _, token, err := parent(root, pointer)
+	if err != nil {
+		return nil, err
+	}
+	switch node := container.(type) {
+	case map[string]interface{}:
+		node[token] = value
+	case []interface{}:
+		index, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = value
+	}
+	return root, nil
+}
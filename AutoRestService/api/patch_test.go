@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func jsonEqual(t *testing.T, actual []byte, expected string) bool {
+	t.Helper()
+	var a, e interface{}
+	if err := json.Unmarshal(actual, &a); err != nil {
+		t.Fatalf("no json: %s", actual)
+	}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatalf("no json: %s", expected)
+	}
+	return reflect.DeepEqual(a, e)
+}
+
+// the examples of RFC 7396 appendix A
+func TestMergePatch(t *testing.T) {
+	tests := []struct{ doc, patch, result string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, test := range tests {
+		result, err := MergePatch([]byte(test.doc), []byte(test.patch))
+		if err != nil {
+			t.Errorf("%s + %s: %v", test.doc, test.patch, err)
+			continue
+		}
+		if !jsonEqual(t, result, test.result) {
+			t.Errorf("%s + %s = %s, want %s", test.doc, test.patch, result, test.result)
+		}
+	}
+}
+
+func applyJSONPatch(t *testing.T, doc, patch string) ([]byte, error) {
+	t.Helper()
+	var ops []PatchOperation
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		t.Fatal(err)
+	}
+	return JSONPatch([]byte(doc), ops)
+}
+
+// examples of RFC 6902 appendix A
+func TestJSONPatch(t *testing.T) {
+	tests := []struct{ doc, patch, result string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"foo":"bar","baz":null}`},
+		{`{"foo":["bar"]}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/-","value":"x"}]`,
+			`{"foo":["bar"],"baz":["bar","x"]}`},
+		{`[]`, `[{"op":"add","path":"/-","value":1},{"op":"add","path":"/-","value":2}]`, `[1,2]`},
+	}
+	for _, test := range tests {
+		result, err := applyJSONPatch(t, test.doc, test.patch)
+		if err != nil {
+			t.Errorf("%s + %s: %v", test.doc, test.patch, err)
+			continue
+		}
+		if !jsonEqual(t, result, test.result) {
+			t.Errorf("%s + %s = %s, want %s", test.doc, test.patch, result, test.result)
+		}
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	tests := []struct{ doc, patch string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"x"}]`},
+		{`{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/-"}]`},
+		{`{"foo":["bar"]}`, `[{"op":"replace","path":"/foo/01","value":"x"}]`},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/x"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`},
+		{`{"foo":"bar"}`, `[{"op":"unknown","path":"/foo"}]`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"baz","value":1}]`},
+	}
+	for _, test := range tests {
+		if result, err := applyJSONPatch(t, test.doc, test.patch); err == nil {
+			t.Errorf("%s + %s: no error, result %s", test.doc, test.patch, result)
+		}
+	}
+}
+
+func TestJSONPatchTestFailure(t *testing.T) {
+	// the failing test operation is stopping the whole patch
+	_, err := applyJSONPatch(t, `{"baz":"qux","foo":["a",2,"c"]}`,
+		`[{"op":"replace","path":"/baz","value":"x"},{"op":"test","path":"/foo/1","value":"2"}]`)
+	if _, ok := err.(*patchTestError); !ok {
+		t.Fatalf("wrong error: %v", err)
+	}
+	patch := RequestPatch{MediaType: ContentTypeJSONPatch, Body: []byte(`[{"op":"test","path":"/baz","value":"other"}]`)}
+	if _, p := patch.Apply([]byte(`{"baz":"qux"}`)); p == nil || p.Code != CodeConflict {
+		t.Errorf("wrong problem: %v", p)
+	}
+}
+
+func TestReadPatch(t *testing.T) {
+	for _, ct := range []string{"", ContentTypeJSON, "application/json-patch", "text/plain"} {
+		req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", ct)
+		if _, p := ReadPatch(req); p == nil || p.Code != CodeUnsupportedMediaType {
+			t.Errorf("content type %q: %v", ct, p)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(`{"a":null}`))
+	req.Header.Set("Content-Type", ContentTypeMergePatch+"; charset=utf-8")
+	patch, p := ReadPatch(req)
+	if p != nil || patch.MediaType != ContentTypeMergePatch || string(patch.Body) != `{"a":null}` {
+		t.Errorf("wrong patch %+v: %v", patch, p)
+	}
+}
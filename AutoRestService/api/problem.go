@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// ProblemContentType content type of the RFC 7807 error responses
+const ProblemContentType = "application/problem+json"
+
+// problemTypePrefix prefix of the problem type uri, the code in kebab case is appended
+const problemTypePrefix = "urn:autorest:problem:"
+
+/*
+ErrorCode stable, machine readable code of an error. Clients should use the code, not the message.
+*/
+type ErrorCode string
+
+// all error codes of this service
+const (
+	CodeBadRequest           ErrorCode = "BAD_REQUEST"
+	CodeInvalidBody          ErrorCode = "INVALID_BODY"
+	CodeValidationFailed     ErrorCode = "VALIDATION_FAILED"
+	CodeInvalidPatch         ErrorCode = "INVALID_PATCH"
+	CodeConflict             ErrorCode = "CONFLICT"
+	CodeIdempotencyKeyReused ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	CodeTenantMissing        ErrorCode = "TENANT_MISSING"
+	CodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	CodeForbidden            ErrorCode = "FORBIDDEN"
+	CodeNotFound             ErrorCode = "NOT_FOUND"
+	CodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeNotAcceptable        ErrorCode = "NOT_ACCEPTABLE"
+	CodePreconditionFailed   ErrorCode = "PRECONDITION_FAILED"
+	CodeUnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodeTooLarge             ErrorCode = "REQUEST_TOO_LARGE"
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"
+	CodeUnavailable          ErrorCode = "SERVICE_UNAVAILABLE"
+)
+
+/*
+ErrorDefinition status and title of an error code
+*/
+type ErrorDefinition struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+}
+
+/*
+ErrorCatalog all known error codes with their http status and title
+*/
+var ErrorCatalog = map[ErrorCode]ErrorDefinition{
+	CodeBadRequest:           {http.StatusBadRequest, "Bad request"},
+	CodeInvalidBody:          {http.StatusBadRequest, "The request body can't be parsed"},
+	CodeValidationFailed:     {http.StatusUnprocessableEntity, "The request contains invalid values"},
+	CodeInvalidPatch:         {http.StatusUnprocessableEntity, "The patch can't be applied to the document"},
+	CodeConflict:             {http.StatusConflict, "The document is not in the expected state"},
+	CodeIdempotencyKeyReused: {http.StatusUnprocessableEntity, "The idempotency key was used for a different request"},
+	CodeTenantMissing:        {http.StatusBadRequest, "The tenant header is missing"},
+	CodeUnauthorized:         {http.StatusUnauthorized, "System id or api key not correct"},
+	CodeForbidden:            {http.StatusForbidden, "Access denied"},
+	CodeNotFound:             {http.StatusNotFound, "Resource not found"},
+	CodeMethodNotAllowed:     {http.StatusMethodNotAllowed, "Method not allowed"},
+	CodeNotAcceptable:        {http.StatusNotAcceptable, "The requested media type is not supported"},
+	CodePreconditionFailed:   {http.StatusPreconditionFailed, "The document has been changed by someone else"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "The media type of the request body is not supported"},
+	CodeTooLarge:             {http.StatusRequestEntityTooLarge, "The request body is too large"},
+	CodeInternal:             {http.StatusInternalServerError, "Internal server error"},
+	CodeUnavailable:          {http.StatusServiceUnavailable, "Service unavailable"},
+}
+
+/*
+FieldError a single validation error of a request field
+*/
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+/*
+Problem RFC 7807 problem details, extended with the error code, the request id and validation errors
+*/
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+/*
+NewProblem creates a problem for the error code, unknown codes are internal errors
+*/
+func NewProblem(code ErrorCode, detail string) *Problem {
+	def, ok := ErrorCatalog[code]
+	if !ok {
+		def = ErrorCatalog[CodeInternal]
+	}
+	return &Problem{
+		Type:   ProblemType(code),
+		Title:  def.Title,
+		Status: def.Status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+/*
+ProblemType the type uri of the error code, like urn:autorest:problem:tenant-missing
+*/
+func ProblemType(code ErrorCode) string {
+	return problemTypePrefix + strings.ReplaceAll(strings.ToLower(string(code)), "_", "-")
+}
+
+/*
+WithErrors adding validation errors
+*/
+func (p *Problem) WithErrors(errs ...FieldError) *Problem {
+	p.Errors = append(p.Errors, errs...)
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("%s: %s", p.Code, p.Title)
+	}
+	return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+}
+
+/*
+Error writes a problem response for the error code
+*/
+func Error(w http.ResponseWriter, r *http.Request, code ErrorCode, detail string) {
+	WriteProblem(w, r, NewProblem(code, detail))
+}
+
+/*
+ValidationError writes a problem response with all validation errors
+*/
+func ValidationError(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	WriteProblem(w, r, NewProblem(CodeValidationFailed, "").WithErrors(errs...))
+}
+
+/*
+WriteProblem writes the problem as application/problem+json, instance and request id are taken from the request
+*/
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if r != nil {
+		if p.Instance == "" {
+			p.Instance = r.URL.Path
+		}
+		if p.RequestID == "" {
+			p.RequestID = middleware.GetReqID(r.Context())
+		}
+	}
+	if p.Status >= http.StatusInternalServerError {
+		log.Alertf("%s %s: %s", p.Instance, p.RequestID, p.Error())
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(data)
+}
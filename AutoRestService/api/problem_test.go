@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/*
+readProblem checking the status and the content type of a problem response, returns the problem
+*/
+func readProblem(t *testing.T, rec *httptest.ResponseRecorder, status int) Problem {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("content type %s", ct)
+	}
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("no problem: %v", err)
+	}
+	return p
+}
+
+func TestErrorCatalog(t *testing.T) {
+	for code, def := range ErrorCatalog {
+		if def.Status < 400 || def.Title == "" {
+			t.Errorf("%s: wrong definition %+v", code, def)
+		}
+	}
+	if p := NewProblem("UNKNOWN", ""); p.Status != http.StatusInternalServerError {
+		t.Errorf("unknown code has status %d", p.Status)
+	}
+	if ProblemType(CodeTenantMissing) != "urn:autorest:problem:tenant-missing" {
+		t.Errorf("wrong type %s", ProblemType(CodeTenantMissing))
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ValidationError(w, r, []FieldError{{Field: "name", Message: "required"}})
+	}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/config/", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(rec, req)
+	p := readProblem(t, rec, http.StatusUnprocessableEntity)
+	if p.Code != CodeValidationFailed || p.Instance != "/api/v1/config/" || p.RequestID != "req-1" {
+		t.Errorf("wrong problem: %+v", p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "name" {
+		t.Errorf("validation errors missing: %+v", p.Errors)
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	handler := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if p := readProblem(t, rec, http.StatusInternalServerError); p.Code != CodeInternal || p.Detail != "" {
+		t.Errorf("wrong problem: %+v", p)
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/jobs"
+	"github.com/willie68/AutoRestIoT/retention"
+)
+
+// JobRetention job type of a retention run
+const JobRetention = "retention"
+
+func init() {
+	jobs.Register(JobRetention, runRetention)
+}
+
+/*
+RetentionRoutes getting all routes for the retention endpoint
+*/
+func RetentionRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/", Documented(RouteDoc{
+		Summary:  "the metrics of the expiry worker, deleted documents by model and reason",
+		Tag:      "admin",
+		Response: retention.Stats{},
+	}, GetRetentionEndpoint))
+	router.Method(http.MethodPost, "/", Documented(RouteDoc{
+		Summary:  "deleting all expired documents now as job",
+		Tag:      "admin",
+		Response: jobs.Job{},
+		Status:   http.StatusAccepted,
+	}, PostRetentionEndpoint))
+	return router
+}
+
+/*
+GetRetentionEndpoint getting the metrics of the expiry worker
+*/
+func GetRetentionEndpoint(response http.ResponseWriter, req *http.Request) {
+	Render(response, req, retention.GetStats())
+}
+
+/*
+PostRetentionEndpoint deleting all expired documents now as job
+*/
+func PostRetentionEndpoint(response http.ResponseWriter, req *http.Request) {
+	Accepted(response, req, JobRetention, nil)
+}
+
+func runRetention(task *jobs.Task, params json.RawMessage) (interface{}, error) {
+	task.SetProgress(0, "deleting expired documents")
+	result, err := retention.Run(task)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
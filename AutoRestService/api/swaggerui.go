@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/willie68/AutoRestIoT/config"
+)
+
+//go:embed static/swaggerui.html
+var swaggerUIPage string
+
+var swaggerUITemplate = template.Must(template.New("swaggerui").Parse(swaggerUIPage))
+
+/*
+publicPath checks if the path is the api documentation of a version, which is served without system id and api key
+*/
+func publicPath(path string) bool {
+	for _, v := range versions {
+		if path == v.Path()+"/openapi.json" || path == v.Path()+"/docs" {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+GetSwaggerUIEndpoint the swagger ui page for this version, only if enabled in the config.
+The page is embedded, the swagger ui scripts and styles are loaded from the configured assets url.
+*/
+func (v *Version) GetSwaggerUIEndpoint(response http.ResponseWriter, req *http.Request) {
+	cfg := config.Get().OpenAPI
+	if !cfg.SwaggerUI {
+		Error(response, req, CodeNotFound, "swagger ui is disabled")
+		return
+	}
+	var b bytes.Buffer
+	err := swaggerUITemplate.Execute(&b, map[string]string{
+		"Version": v.Name,
+		"Assets":  strings.TrimSuffix(cfg.Assets, "/"),
+		"Spec":    v.Path() + "/openapi.json",
+	})
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	response.Header().Set("Content-Type", "text/html; charset=utf-8")
+	response.Write(b.Bytes())
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"log"
 	"net/http"
 	"strings"
 )
@@ -16,7 +15,6 @@ const SystemHeader = "X-mcs-system"
 SysAPIKey defining a handler for checking system id and api key
 */
 type SysAPIKey struct {
-	log      *log.Logger
 	SystemID string
 	Apikey   string
 }
@@ -38,13 +36,13 @@ Handler the handler checks systemid and apikey headers
 func (s *SysAPIKey) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := strings.TrimSuffix(r.URL.Path, "/")
-		if !strings.HasPrefix(path, "/health") {
+		if path != "/health" && !strings.HasPrefix(path, "/health/") && !publicPath(path) {
 			if s.SystemID != r.Header.Get(SystemHeader) {
-				Msg(w, http.StatusBadRequest, "either system id or apikey not correct")
+				Error(w, r, CodeUnauthorized, "")
 				return
 			}
 			if s.Apikey != strings.ToLower(r.Header.Get(APIKeyHeader)) {
-				Msg(w, http.StatusBadRequest, "either system id or apikey not correct")
+				Error(w, r, CodeUnauthorized, "")
 				return
 			}
 		}
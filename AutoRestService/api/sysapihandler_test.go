@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSysAPIKey(t *testing.T) {
+	handler := NewSysAPIHandler("system", "key").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for path, public := range map[string]bool{
+		"/health/health":            true,
+		"/health/readiness":         true,
+		"/api/v1/openapi.json":      true,
+		"/api/v2/docs":              true,
+		"/api/v2/docs/":             true,
+		"/api/v1/trash/docs":        false,
+		"/api/v2/jobs/openapi.json": false,
+		"/api/v3/docs":              false,
+		"/healthz":                  false,
+		"/api/v1/config/":           false,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if public && rec.Code != http.StatusNoContent {
+			t.Errorf("%s: status %d, want public", path, rec.Code)
+		}
+		if !public {
+			if p := readProblem(t, rec, http.StatusUnauthorized); p.Code != CodeUnauthorized {
+				t.Errorf("%s: wrong problem %+v", path, p)
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/trash/docs", nil)
+	req.Header.Set(SystemHeader, "system")
+	req.Header.Set(APIKeyHeader, "KEY")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status %d with the right api key", rec.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+TrashRoutes getting all routes for the trash endpoint
+*/
+func TrashRoutes() *chi.Mux {
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/", Documented(RouteDoc{
+		Summary:  "all deleted items of the tenant",
+		Tag:      "trash",
+		Tenant:   true,
+		Response: []trash.Item{},
+	}, GetTrashEndpoint))
+	router.Method(http.MethodGet, "/{id}", Documented(RouteDoc{
+		Summary:  "a deleted item",
+		Tag:      "trash",
+		Tenant:   true,
+		Response: trash.Item{},
+		Errors:   []ErrorCode{CodeNotFound},
+	}, GetTrashItemEndpoint))
+	router.Method(http.MethodPost, "/{id}/restore", Documented(RouteDoc{
+		Summary:  "restoring a deleted item",
+		Tag:      "trash",
+		Tenant:   true,
+		Response: trash.Item{},
+		Errors:   []ErrorCode{CodeNotFound},
+	}, PostRestoreTrashItemEndpoint))
+	router.Method(http.MethodDelete, "/{id}", Documented(RouteDoc{
+		Summary:  "deleting an item permanently",
+		Tag:      "trash",
+		Tenant:   true,
+		Response: trash.Item{},
+		Errors:   []ErrorCode{CodeNotFound},
+	}, DeleteTrashItemEndpoint))
+	return router
+}
+
+/*
+GetTrashEndpoint getting all deleted items of the tenant
+*/
+func GetTrashEndpoint(response http.ResponseWriter, req *http.Request) {
+	tenant := getTenant(req)
+	if tenant == "" {
+		Error(response, req, CodeTenantMissing, "")
+		return
+	}
+	Render(response, req, trash.List(tenant))
+}
+
+/*
+GetTrashItemEndpoint getting a deleted item
+*/
+func GetTrashItemEndpoint(response http.ResponseWriter, req *http.Request) {
+	item, ok := tenantTrashItem(response, req)
+	if !ok {
+		return
+	}
+	Render(response, req, item)
+}
+
+/*
+PostRestoreTrashItemEndpoint restoring a deleted item
+*/
+func PostRestoreTrashItemEndpoint(response http.ResponseWriter, req *http.Request) {
+	item, ok := tenantTrashItem(response, req)
+	if !ok {
+		return
+	}
+	item, err := trash.Restore(item.ID)
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	log.WithContext(req.Context()).Infof("%s %s restored from trash", item.Kind, item.Ref)
+	Render(response, req, item)
+}
+
+/*
+DeleteTrashItemEndpoint deleting an item permanently
+*/
+func DeleteTrashItemEndpoint(response http.ResponseWriter, req *http.Request) {
+	item, ok := tenantTrashItem(response, req)
+	if !ok {
+		return
+	}
+	item, err := trash.Purge(item.ID)
+	if err != nil {
+		Error(response, req, CodeInternal, err.Error())
+		return
+	}
+	log.WithContext(req.Context()).Alertf("%s %s purged", item.Kind, item.Ref)
+	Render(response, req, item)
+}
+
+/*
+tenantTrashItem the item of the request, items of other tenants are not found
+*/
+func tenantTrashItem(response http.ResponseWriter, req *http.Request) (trash.Item, bool) {
+	tenant := getTenant(req)
+	if tenant == "" {
+		Error(response, req, CodeTenantMissing, "")
+		return trash.Item{}, false
+	}
+	item, ok := trash.Get(chi.URLParam(req, "id"))
+	if !ok || item.Tenant != tenant {
+		Error(response, req, CodeNotFound, fmt.Sprintf("item %s not found in trash", chi.URLParam(req, "id")))
+		return trash.Item{}, false
+	}
+	return item, true
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+serveVersion executing a request of the tenant "tenant" against an api version
+*/
+func serveVersion(t *testing.T, name, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	v, ok := GetVersion(name)
+	if !ok {
+		t.Fatalf("version %s not found", name)
+	}
+	router := chi.NewRouter()
+	router.Mount(v.Path(), v.Router())
+	req := httptest.NewRequest(method, v.Path()+path, nil)
+	req.Header.Set(TenantHeader, "tenant")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDeleteDocumentIntoTrash(t *testing.T) {
+	path, etag := createDocument(t, `{"name":"sensor"}`)
+	id := strings.TrimPrefix(path, "/devices/")
+	if rec := serveModel(t, http.MethodDelete, path, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	readProblem(t, serveModel(t, http.MethodGet, path, "", nil), http.StatusNotFound)
+	readProblem(t, serveModel(t, http.MethodDelete, path, "", nil), http.StatusNotFound)
+
+	var items []trash.Item
+	json.Unmarshal(serveVersion(t, "2", http.MethodGet, "/trash/").Body.Bytes(), &items)
+	if len(items) == 0 || items[0].Kind != trash.KindDocument || items[0].Ref != "devices/"+id {
+		t.Fatalf("wrong trash: %+v", items)
+	}
+	if rec := serveVersion(t, "2", http.MethodPost, "/trash/"+items[0].ID+"/restore"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveModel(t, http.MethodGet, path, "", nil); rec.Code != http.StatusOK || rec.Header().Get("ETag") != etag {
+		t.Fatalf("document not restored: %d", rec.Code)
+	}
+
+	serveModel(t, http.MethodDelete, path, "", nil)
+	json.Unmarshal(serveVersion(t, "2", http.MethodGet, "/trash/").Body.Bytes(), &items)
+	if rec := serveVersion(t, "2", http.MethodDelete, "/trash/"+items[0].ID); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := storage.RestoreTrash("tenant", items[0].ID); n != 0 {
+		t.Error("purged document restored")
+	}
+}
+
+func TestTrashRestart(t *testing.T) {
+	// the trash in the directory of the documents, like the default of the config
+	dir := t.TempDir()
+	start := func() {
+		t.Helper()
+		if err := storage.Start(storage.Config{Dir: dir}); err != nil {
+			t.Fatal(err)
+		}
+		trash.Stop()
+		if err := trash.Start(trash.Config{Retention: 3600, Storage: filepath.Join(dir, ".trash")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start()
+	t.Cleanup(func() {
+		// the following tests are using the trash in memory again
+		trash.Stop()
+		trash.Start(trash.Config{})
+		trash.Stop()
+	})
+	trash.Register(trash.KindDocument, storage.TrashHandler{})
+	path := serveModel(t, http.MethodPost, "/devices/", `{"name":"sensor"}`, nil).Header().Get("Location")
+	if rec := serveModel(t, http.MethodDelete, path, "", nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	start()
+	var items []trash.Item
+	json.Unmarshal(serveVersion(t, "2", http.MethodGet, "/trash/").Body.Bytes(), &items)
+	if len(items) != 1 {
+		t.Fatalf("trash lost on restart: %+v", items)
+	}
+	if rec := serveVersion(t, "2", http.MethodPost, "/trash/"+items[0].ID+"/restore"); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveModel(t, http.MethodGet, path, "", nil); rec.Code != http.StatusOK {
+		t.Errorf("document not restored: %d", rec.Code)
+	}
+}
+
+func TestDeleteConfigVersions(t *testing.T) {
+	createDocument(t, `{"name":"sensor"}`)
+	trash.Register(trash.KindStore, storage.TrashHandler{})
+
+	// version 1 answers with the tenant
+	rec := serveVersion(t, "1", http.MethodDelete, "/config/")
+	var tenant string
+	if err := json.Unmarshal(rec.Body.Bytes(), &tenant); err != nil || rec.Code != http.StatusOK || tenant != "tenant" {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(storage.List("tenant", "devices")) != 0 {
+		t.Error("store not deleted")
+	}
+
+	createDocument(t, `{"name":"sensor"}`)
+	rec = serveVersion(t, "2", http.MethodDelete, "/config/")
+	var item trash.Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil || item.Kind != trash.KindStore || item.Ref != "tenant" {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := trash.Restore(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(storage.List("tenant", "devices")) != 1 {
+		t.Error("store not restored")
+	}
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// headers for deprecated api versions
+const (
+	DeprecationHeader = "Deprecation"
+	SunsetHeader      = "Sunset"
+)
+
+/*
+Version a served api version with its own route set
+*/
+type Version struct {
+	// Name of the version, the routes are mounted under /api/v{Name}
+	Name string
+	// Routes adding the routes of this version
+	Routes func(r chi.Router)
+	// Deprecation since this time the version is deprecated, zero if not
+	Deprecation time.Time
+	// Sunset at this time the version will be removed, zero if not planned
+	Sunset time.Time
+
+	stats      versionStats
+	router     http.Handler
+	routerOnce sync.Once
+}
+
+type versionStats struct {
+	requests uint64
+	status   [6]uint64
+	mu       sync.Mutex
+	routes   map[string]uint64
+}
+
+/*
+VersionInfo information and usage of an api version
+*/
+type VersionInfo struct {
+	Version     string            `json:"version"`
+	Path        string            `json:"path"`
+	Deprecated  bool              `json:"deprecated"`
+	Deprecation string            `json:"deprecation,omitempty"`
+	Sunset      string            `json:"sunset,omitempty"`
+	Requests    uint64            `json:"requests"`
+	Status      map[string]uint64 `json:"status"`
+	Routes      map[string]uint64 `json:"routes"`
+}
+
+var versions []*Version
+
+func init() {
+	// in init, because the admin routes are using the versions too
+	versions = []*Version{
+		{
+			Name:   "1",
+			Routes: routesV1,
+		},
+		{
+			Name:   "2",
+			Routes: routesV2,
+		},
+	}
+}
+
+/*
+routes the routes shared by all versions. A version with changed endpoints gets its own function,
+delegating to this one for the rest.
+*/
+func routes(r chi.Router) {
+	r.Mount("/models", ModelRoutes())
+	r.Mount("/admin", AdminRoutes())
+	r.Mount("/jobs", JobRoutes())
+	r.Mount("/trash", TrashRoutes())
+}
+
+/*
+routesV1 deleting a store answers with the tenant
+*/
+func routesV1(r chi.Router) {
+	r.Mount("/config", ConfigRoutesV1())
+	routes(r)
+}
+
+/*
+routesV2 deleting a store answers with the trash item
+*/
+func routesV2(r chi.Router) {
+	r.Mount("/config", ConfigRoutes())
+	routes(r)
+}
+
+/*
+Versions all served api versions, the last one is the actual version
+*/
+func Versions() []*Version {
+	return versions
+}
+
+/*
+ActualVersion the newest api version
+*/
+func ActualVersion() *Version {
+	return versions[len(versions)-1]
+}
+
+/*
+GetVersion getting an api version by its name
+*/
+func GetVersion(name string) (*Version, bool) {
+	for _, v := range versions {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+/*
+Deprecate marking an api version as deprecated, with an optional sunset time
+*/
+func Deprecate(name string, deprecation time.Time, sunset time.Time) error {
+	v, ok := GetVersion(name)
+	if !ok {
+		return fmt.Errorf("unknown api version: %s", name)
+	}
+	if v == ActualVersion() {
+		return fmt.Errorf("the actual api version %s can't be deprecated", name)
+	}
+	v.Deprecation = deprecation
+	v.Sunset = sunset
+	return nil
+}
+
+/*
+Path base path of the version
+*/
+func (v *Version) Path() string {
+	return "/api/v" + v.Name
+}
+
+/*
+Deprecated checks if the version is deprecated
+*/
+func (v *Version) Deprecated() bool {
+	return !v.Deprecation.IsZero() && !time.Now().Before(v.Deprecation)
+}
+
+/*
+Router the router with all routes of this version
+*/
+func (v *Version) Router() *chi.Mux {
+	router := chi.NewRouter()
+	router.Use(v.middleware, Idempotency)
+	router.NotFound(NotFoundHandler)
+	router.MethodNotAllowed(MethodNotAllowedHandler)
+	v.Routes(router)
+	router.Method(http.MethodGet, "/openapi.json", Documented(RouteDoc{
+		Summary:  "the OpenAPI document of this api version",
+		Tag:      "api",
+		Public:   true,
+		Response: map[string]interface{}{},
+	}, v.GetOpenAPIEndpoint))
+	router.Method(http.MethodGet, "/docs", Documented(RouteDoc{
+		Summary: "swagger ui for this api version, if enabled",
+		Tag:     "api",
+		Public:  true,
+	}, v.GetSwaggerUIEndpoint))
+	router.Method(http.MethodPost, "/_batch", Documented(RouteDoc{
+		Summary:  "executing multiple operations in one request",
+		Tag:      "api",
+		Request:  BatchRequest{},
+		Response: BatchResponse{},
+		Errors:   []ErrorCode{CodeValidationFailed},
+	}, v.PostBatchEndpoint))
+	return router
+}
+
+/*
+middleware adding the deprecation headers and counting the requests of this version
+*/
+func (v *Version) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !v.Deprecation.IsZero() {
+			// RFC 9745, the header is set in advance too, the date shows when the deprecation starts
+			w.Header().Set(DeprecationHeader, fmt.Sprintf("@%d", v.Deprecation.Unix()))
+			w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", ActualVersion().Path()))
+		}
+		if !v.Sunset.IsZero() {
+			// RFC 8594
+			w.Header().Set(SunsetHeader, v.Sunset.UTC().Format(http.TimeFormat))
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		v.count(r, ww.Status())
+	})
+}
+
+func (v *Version) count(r *http.Request, status int) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	atomic.AddUint64(&v.stats.requests, 1)
+	if class := status / 100; class > 0 && class < len(v.stats.status) {
+		atomic.AddUint64(&v.stats.status[class], 1)
+	}
+	route := "unknown"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = r.Method + " " + cleanPattern(rctx.RoutePattern())
+	}
+	v.stats.mu.Lock()
+	if v.stats.routes == nil {
+		v.stats.routes = make(map[string]uint64)
+	}
+	v.stats.routes[route]++
+	v.stats.mu.Unlock()
+}
+
+/*
+Info the information and usage of this version
+*/
+func (v *Version) Info() VersionInfo {
+	info := VersionInfo{
+		Version:    v.Name,
+		Path:       v.Path(),
+		Deprecated: v.Deprecated(),
+		Requests:   atomic.LoadUint64(&v.stats.requests),
+		Status:     make(map[string]uint64),
+		Routes:     make(map[string]uint64),
+	}
+	if !v.Deprecation.IsZero() {
+		info.Deprecation = v.Deprecation.Format(time.RFC3339)
+	}
+	if !v.Sunset.IsZero() {
+		info.Sunset = v.Sunset.Format(time.RFC3339)
+	}
+	for class := 1; class < len(v.stats.status); class++ {
+		if count := atomic.LoadUint64(&v.stats.status[class]); count > 0 {
+			info.Status[fmt.Sprintf("%dxx", class)] = count
+		}
+	}
+	v.stats.mu.Lock()
+	for route, count := range v.stats.routes {
+		info.Routes[route] = count
+	}
+	v.stats.mu.Unlock()
+	return info
+}
+
+/*
+GetVersionsEndpoint getting all api versions with their deprecation and usage
+*/
+func GetVersionsEndpoint(response http.ResponseWriter, req *http.Request) {
+	list := make([]VersionInfo, 0, len(versions))
+	for _, v := range versions {
+		list = append(list, v.Info())
+	}
+	Render(response, req, list)
+}
+
+/*
+cleanPattern removing the wildcards of mounted sub routers from a chi route pattern
+*/
+func cleanPattern(pattern string) string {
+	pattern = strings.ReplaceAll(pattern, "/*", "")
+	for strings.Contains(pattern, "//") {
+		pattern = strings.ReplaceAll(pattern, "//", "/")
+	}
+	if pattern = strings.TrimSuffix(pattern, "/"); pattern == "" {
+		return "/"
+	}
+	return pattern
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+func testVersion() *Version {
+	return &Version{
+		Name: "9",
+		Routes: func(r chi.Router) {
+			r.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			})
+		},
+	}
+}
+
+func TestVersionHeaders(t *testing.T) {
+	v := testVersion()
+	v.Deprecation = time.Unix(1700000000, 0)
+	v.Sunset = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	router := v.Router()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if rec.Header().Get(DeprecationHeader) != "@1700000000" {
+		t.Errorf("deprecation header %q", rec.Header().Get(DeprecationHeader))
+	}
+	if rec.Header().Get(SunsetHeader) != "Tue, 01 Jan 2030 00:00:00 GMT" {
+		t.Errorf("sunset header %q", rec.Header().Get(SunsetHeader))
+	}
+	if rec.Header().Get("Link") != "<"+ActualVersion().Path()+">; rel=\"successor-version\"" {
+		t.Errorf("link header %q", rec.Header().Get("Link"))
+	}
+	if !v.Deprecated() {
+		t.Error("not deprecated")
+	}
+}
+
+func TestVersionStats(t *testing.T) {
+	v := testVersion()
+	router := v.Router()
+	for _, path := range []string{"/items/1", "/items/2", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	info := v.Info()
+	if info.Requests != 3 || info.Status["2xx"] != 2 || info.Status["4xx"] != 1 {
+		t.Errorf("wrong counts: %+v", info)
+	}
+	if info.Routes["GET /items/{id}"] != 2 {
+		t.Errorf("wrong routes: %v", info.Routes)
+	}
+	if info.Deprecated || info.Path != "/api/v9" {
+		t.Errorf("wrong info: %+v", info)
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	if err := Deprecate(ActualVersion().Name, time.Now(), time.Time{}); err == nil {
+		t.Error("actual version deprecated")
+	}
+	if err := Deprecate("0", time.Now(), time.Time{}); err == nil {
+		t.Error("unknown version deprecated")
+	}
+}
+
+func TestCleanPattern(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"/api/v1/*/config/*/": "/api/v1/config",
+		"/*":                  "/",
+		"/jobs/{id}":          "/jobs/{id}",
+	} {
+		if got := cleanPattern(pattern); got != want {
+			t.Errorf("%s: got %s, want %s", pattern, got, want)
+		}
+	}
+}
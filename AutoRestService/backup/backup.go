@@ -0,0 +1,443 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+var log = logging.ServiceLogger{Package: "backup"}
+
+// manifestName name of the manifest in the archive
+const manifestName = "manifest.json"
+
+// formatVersion version of the archive format
+const formatVersion = 1
+
+// fileSuffix suffix of the backup archives
+const fileSuffix = ".tar.gz"
+
+// AllTenants backup of the full instance
+const AllTenants = ""
+
+/*
+Source the data of the tenants, implemented by the storage
+*/
+type Source interface {
+	// Tenants all tenants with data
+	Tenants() ([]string, error)
+	// Backup writing all data of the tenant with add, the names are relative to the tenant
+	Backup(tenant string, add func(name string, size int64, r io.Reader) error) error
+	// Restore writing a single entry of a backup into the tenant
+	Restore(tenant string, name string, r io.Reader) error
+}
+
+/*
+FileEntry a file of the archive with its checksum
+*/
+type FileEntry struct {
+	Tenant string `json:"tenant"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+/*
+Manifest the content of a backup archive
+*/
+type Manifest struct {
+	Version  int         `json:"version"`
+	Created  time.Time   `json:"created"`
+	SystemID string      `json:"systemID"`
+	Tenant   string      `json:"tenant,omitempty"`
+	Tenants  []string    `json:"tenants"`
+	Files    []FileEntry `json:"files"`
+}
+
+/*
+Info a backup in the backup directory
+*/
+type Info struct {
+	Name    string    `json:"name"`
+	Tenant  string    `json:"tenant,omitempty"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+/*
+metadata the description of an archive in the comment of its gzip header, so it's read without reading the archive.
+The comment must be latin-1, so the tenant is hex encoded.
+*/
+type metadata struct {
+	Tenant  string    `json:"tenant"`
+	Created time.Time `json:"created"`
+}
+
+var (
+	sourceMutex sync.Mutex
+	source      Source
+)
+
+/*
+SetSource setting the data source of the backups
+*/
+func SetSource(s Source) {
+	sourceMutex.Lock()
+	defer sourceMutex.Unlock()
+	source = s
+}
+
+func getSource() (Source, error) {
+	sourceMutex.Lock()
+	defer sourceMutex.Unlock()
+	if source == nil {
+		return nil, fmt.Errorf("no backup source registered")
+	}
+	return source, nil
+}
+
+var namePattern = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}Z-[A-Za-z0-9_.-]+\.tar\.gz$`)
+
+/*
+ValidName checks if the name is the name of a backup archive, so no paths are possible
+*/
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+/*
+archiveName the name of a new archive, the label is only readable for humans, the tenant is taken from the metadata.
+The random suffix separates the backups of the same second and of tenants with the same label.
+*/
+func archiveName(created time.Time, tenant string) string {
+	label := "all"
+	if tenant != AllTenants {
+		label = "tenant_" + regexp.MustCompile(`[^A-Za-z0-9_.-]`).ReplaceAllString(tenant, "_")
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return created.Format("20060102T150405Z") + "-" + label + "-" + hex.EncodeToString(suffix) + fileSuffix
+}
+
+/*
+Create creating a compressed backup of the tenant, or of all tenants, in the directory.
+The archive is written to a temporary file and renamed at the end, so there are no incomplete backups.
+*/
+func Create(dir string, systemID string, tenant string) (Info, error) {
+	src, err := getSource()
+	if err != nil {
+		return Info{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Info{}, fmt.Errorf("can't create backup directory: %s", err.Error())
+	}
+	created := time.Now().UTC()
+	manifest := Manifest{
+		Version:  formatVersion,
+		Created:  created,
+		SystemID: systemID,
+		Tenant:   tenant,
+		Tenants:  []string{tenant},
+		Files:    make([]FileEntry, 0),
+	}
+	if tenant == AllTenants {
+		if manifest.Tenants, err = src.Tenants(); err != nil {
+			return Info{}, fmt.Errorf("can't get tenants: %s", err.Error())
+		}
+	}
+
+	name := archiveName(created, tenant)
+	tmp, err := ioutil.TempFile(dir, ".backup-*")
+	if err != nil {
+		return Info{}, fmt.Errorf("can't create backup: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	gz := gzip.NewWriter(tmp)
+	comment, err := json.Marshal(metadata{Tenant: hex.EncodeToString([]byte(tenant)), Created: created})
+	if err != nil {
+		return Info{}, err
+	}
+	gz.Comment = string(comment)
+	tw := tar.NewWriter(gz)
+	for _, t := range manifest.Tenants {
+		err := src.Backup(t, func(entry string, size int64, r io.Reader) error {
+			if strings.Contains(entry, "..") || strings.HasPrefix(entry, "/") {
+				return fmt.Errorf("wrong entry name: %s", entry)
+			}
+			h := sha256.New()
+			err := tw.WriteHeader(&tar.Header{
+				Name:    path(t, entry),
+				Mode:    0600,
+				Size:    size,
+				ModTime: created,
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, io.TeeReader(r, h)); err != nil {
+				return err
+			}
+			manifest.Files = append(manifest.Files, FileEntry{Tenant: t, Name: entry, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+			return nil
+		})
+		if err != nil {
+			return Info{}, fmt.Errorf("can't backup tenant %s: %s", t, err.Error())
+		}
+	}
+	// the manifest is the last entry, it contains the checksums of all files
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Info{}, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0600, Size: int64(len(data)), ModTime: created}); err != nil {
+		return Info{}, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return Info{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return Info{}, fmt.Errorf("can't store backup: %s", err.Error())
+	}
+	log.Infof("backup %s created, %d tenants, %d files", name, len(manifest.Tenants), len(manifest.Files))
+	return info(dir, name)
+}
+
+/*
+path the path of a tenant file in the archive
+*/
+func path(tenant string, name string) string {
+	return "tenants/" + hex.EncodeToString([]byte(tenant)) + "/" + name
+}
+
+/*
+ReadManifest reading the manifest of a backup and verifying the checksums of all files
+*/
+func ReadManifest(file string) (Manifest, error) {
+	var manifest Manifest
+	sums := make(map[string]hash.Hash)
+	sizes := make(map[string]int64)
+	err := walk(file, func(name string, r io.Reader) error {
+		if name == manifestName {
+			return json.NewDecoder(r).Decode(&manifest)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		sums[name] = h
+		sizes[name] = n
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+	if manifest.Version == 0 {
+		return manifest, fmt.Errorf("no manifest in backup")
+	}
+	if manifest.Version > formatVersion {
+		return manifest, fmt.Errorf("backup format %d not supported", manifest.Version)
+	}
+	if len(sums) != len(manifest.Files) {
+		return manifest, fmt.Errorf("backup contains %d files, manifest %d", len(sums), len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		h, ok := sums[path(f.Tenant, f.Name)]
+		if !ok {
+			return manifest, fmt.Errorf("file %s of tenant %s missing", f.Name, f.Tenant)
+		}
+		if hex.EncodeToString(h.Sum(nil)) != f.SHA256 || sizes[path(f.Tenant, f.Name)] != f.Size {
+			return manifest, fmt.Errorf("checksum of file %s of tenant %s wrong", f.Name, f.Tenant)
+		}
+	}
+	return manifest, nil
+}
+
+/*
+Restore restoring a backup after verifying it. With tenant only this tenant is restored, with target the data
+is restored into the target tenant, this is only possible for a single tenant.
+*/
+func Restore(file string, tenant string, target string) (Manifest, error) {
+	src, err := getSource()
+	if err != nil {
+		return Manifest{}, err
+	}
+	manifest, err := ReadManifest(file)
+	if err != nil {
+		return manifest, fmt.Errorf("backup not valid: %s", err.Error())
+	}
+	if tenant == AllTenants && manifest.Tenant != AllTenants {
+		tenant = manifest.Tenant
+	}
+	if target != "" && tenant == AllTenants {
+		return manifest, fmt.Errorf("a target tenant needs a single tenant to restore")
+	}
+	names := make(map[string]FileEntry)
+	found := false
+	for _, f := range manifest.Files {
+		names[path(f.Tenant, f.Name)] = f
+	}
+	for _, t := range manifest.Tenants {
+		found = found || t == tenant
+	}
+	if tenant != AllTenants && !found {
+		return manifest, fmt.Errorf("tenant %s not in backup", tenant)
+	}
+	err = walk(file, func(name string, r io.Reader) error {
+		f, ok := names[name]
+		if !ok || (tenant != AllTenants && f.Tenant != tenant) {
+			return nil
+		}
+		to := f.Tenant
+		if target != "" {
+			to = target
+		}
+		return src.Restore(to, f.Name, r)
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("can't restore backup: %s", err.Error())
+	}
+	log.Infof("backup %s restored, tenant: %s, target: %s", filepath.Base(file), tenant, target)
+	return manifest, nil
+}
+
+func walk(file string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+List all backups in the directory, newest first
+*/
+func List(dir string) ([]Info, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Info, 0)
+	for _, file := range files {
+		if file.IsDir() || !ValidName(file.Name()) {
+			continue
+		}
+		i, err := info(dir, file.Name())
+		if err != nil {
+			continue
+		}
+		list = append(list, i)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+func info(dir string, name string) (Info, error) {
+	file := filepath.Join(dir, name)
+	stat, err := os.Stat(file)
+	if err != nil {
+		return Info{}, err
+	}
+	if meta, ok := readMetadata(file); ok {
+		tenant, err := hex.DecodeString(meta.Tenant)
+		if err != nil {
+			return Info{}, err
+		}
+		return Info{Name: name, Tenant: string(tenant), Size: stat.Size(), Created: meta.Created}, nil
+	}
+	// archives of older versions without metadata, the tenant is taken from the label
+	created, err := time.Parse("20060102T150405Z", name[:16])
+	if err != nil {
+		return Info{}, err
+	}
+	i := Info{Name: name, Size: stat.Size(), Created: created}
+	label := strings.TrimSuffix(name[17:], fileSuffix)
+	if strings.HasPrefix(label, "tenant_") {
+		i.Tenant = strings.TrimPrefix(label, "tenant_")
+	}
+	return i, nil
+}
+
+/*
+readMetadata reading the metadata from the gzip header of the archive
+*/
+func readMetadata(file string) (metadata, bool) {
+	var meta metadata
+	f, err := os.Open(file)
+	if err != nil {
+		return meta, false
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return meta, false
+	}
+	defer gz.Close()
+	if gz.Comment == "" || json.Unmarshal([]byte(gz.Comment), &meta) != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+/*
+Delete deleting a backup
+*/
+func Delete(dir string, name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("wrong backup name: %s", name)
+	}
+	return os.Remove(filepath.Join(dir, name))
+}
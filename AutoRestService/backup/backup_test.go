@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+memorySource a source with the files of the tenants in memory
+*/
+type memorySource map[string]map[string]string
+
+func (s memorySource) Tenants() ([]string, error) {
+	list := make([]string, 0)
+	for tenant := range s {
+		list = append(list, tenant)
+	}
+	return list, nil
+}
+
+func (s memorySource) Backup(tenant string, add func(name string, size int64, r io.Reader) error) error {
+	for name, data := range s[tenant] {
+		if err := add(name, int64(len(data)), strings.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s memorySource) Restore(tenant string, name string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s[tenant] == nil {
+		s[tenant] = make(map[string]string)
+	}
+	s[tenant][name] = string(data)
+	return nil
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	source := memorySource{"a": {"x.json": "[1]", "y.json": "[2]"}, "b/c": {"x.json": "[3]"}}
+	SetSource(source)
+	defer SetSource(nil)
+	info, err := Create(dir, "system", AllTenants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidName(info.Name) || info.Tenant != "" {
+		t.Errorf("wrong info: %+v", info)
+	}
+	manifest, err := ReadManifest(filepath.Join(dir, info.Name))
+	if err != nil || len(manifest.Tenants) != 2 || len(manifest.Files) != 3 || manifest.SystemID != "system" {
+		t.Fatalf("wrong manifest %+v: %v", manifest, err)
+	}
+
+	if _, err := Restore(filepath.Join(dir, info.Name), AllTenants, "target"); err == nil {
+		t.Error("all tenants restored into one")
+	}
+	if _, err := Restore(filepath.Join(dir, info.Name), "b/c", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if len(source["target"]) != 1 || source["target"]["x.json"] != "[3]" {
+		t.Errorf("wrong restore: %v", source["target"])
+	}
+	if _, err := Restore(filepath.Join(dir, info.Name), "unknown", ""); err == nil {
+		t.Error("unknown tenant restored")
+	}
+}
+
+func TestReadManifestChecksum(t *testing.T) {
+	dir := t.TempDir()
+	SetSource(memorySource{"a": {"x.json": "[1]"}})
+	defer SetSource(nil)
+	info, err := Create(dir, "system", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// copying the archive with a changed file
+	file := filepath.Join(dir, info.Name)
+	changed := filepath.Join(dir, "changed.tar.gz")
+	out, _ := os.Create(changed)
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	err = walk(file, func(name string, r io.Reader) error {
+		data, _ := ioutil.ReadAll(r)
+		if name != manifestName {
+			data = []byte("[2]")
+		}
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		_, err := tw.Write(data)
+		return err
+	})
+	tw.Close()
+	gzw.Close()
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(changed); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("changed file not detected: %v", err)
+	}
+	if _, err := Restore(changed, "a", ""); err == nil {
+		t.Error("changed backup restored")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	names := []string{
+		archiveName(now.Add(-3*time.Hour), AllTenants),
+		archiveName(now.Add(-2*time.Hour), AllTenants),
+		archiveName(now.Add(-1*time.Hour), AllTenants),
+		archiveName(now.Add(-48*time.Hour), "a"),
+		archiveName(now.Add(-1*time.Hour), "a"),
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Prune(dir, 2, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := List(dir)
+	if len(list) != 3 {
+		t.Errorf("wrong backups kept: %+v", list)
+	}
+	for _, i := range list {
+		if i.Name == names[0] || i.Name == names[3] {
+			t.Errorf("backup %s not deleted", i.Name)
+		}
+	}
+	if Delete(dir, "../config.yaml") == nil {
+		t.Error("file outside of the backups deleted")
+	}
+}
+
+func TestCreateSameLabel(t *testing.T) {
+	dir := t.TempDir()
+	SetSource(memorySource{"a/b": {"x.json": "[1]"}, "a_b": {"x.json": "[2]"}})
+	defer SetSource(nil)
+	// backups in the same second and of tenants with the same label are kept apart
+	for _, tenant := range []string{"a/b", "a/b", "a_b"} {
+		if _, err := Create(dir, "system", tenant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, _ := List(dir)
+	if len(list) != 3 {
+		t.Fatalf("backups overwritten: %+v", list)
+	}
+	tenants := make(map[string]int)
+	for _, i := range list {
+		tenants[i.Tenant]++
+	}
+	if tenants["a/b"] != 2 || tenants["a_b"] != 1 {
+		t.Errorf("wrong tenants: %v", tenants)
+	}
+	if !list[0].Created.After(list[1].Created) {
+		t.Errorf("backups not ordered: %+v", list)
+	}
+
+	// the retention counts the backups by the real tenant
+	if err := Prune(dir, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = List(dir)
+	if len(list) != 2 || list[0].Tenant == list[1].Tenant {
+		t.Errorf("wrong backups kept: %+v", list)
+	}
+}
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Config configuration of the scheduled backups
+*/
+type Config struct {
+	Dir      string
+	Interval int
+	Keep     int
+	MaxAge   int
+}
+
+var (
+	scheduleMutex sync.Mutex
+	stopSchedule  chan struct{}
+)
+
+/*
+Schedule creating a backup of all tenants every interval seconds and pruning the old backups afterwards.
+A running schedule is replaced, an interval of 0 stops the schedule.
+*/
+func Schedule(config Config, systemID string) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	if stopSchedule != nil {
+		close(stopSchedule)
+		stopSchedule = nil
+	}
+	if config.Interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	stopSchedule = stop
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if _, err := Create(config.Dir, systemID, AllTenants); err != nil {
+				log.Alertf("scheduled backup failed: %s", err.Error())
+				continue
+			}
+			if err := Prune(config.Dir, config.Keep, time.Duration(config.MaxAge)*time.Second); err != nil {
+				log.Alertf("can't prune backups: %s", err.Error())
+			}
+		}
+	}()
+}
+
+/*
+StopSchedule stopping the scheduled backups
+*/
+func StopSchedule() {
+	Schedule(Config{}, "")
+}
+
+/*
+Prune deleting the backups older than maxAge and keeping only the newest keep backups of every tenant
+and of the full instance. 0 disables the limit.
+*/
+func Prune(dir string, keep int, maxAge time.Duration) error {
+	list, err := List(dir)
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	for _, i := range list {
+		counts[i.Tenant]++
+		tooMany := keep > 0 && counts[i.Tenant] > keep
+		tooOld := maxAge > 0 && time.Since(i.Created) > maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := Delete(dir, i.Name); err != nil {
+			return err
+		}
+		log.Infof("backup %s deleted by retention", i.Name)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formats of the bulk data
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// media types of the bulk formats
+const (
+	ContentTypeNDJSON = "application/x-ndjson"
+	ContentTypeCSV    = "text/csv"
+)
+
+// maxLineErrors max number of line errors in a report, the following errors are only counted
+const maxLineErrors = 1000
+
+// maxLineSize max size of a single ndjson line
+const maxLineSize = 16 * 1024 * 1024
+
+/*
+Document a single document of the bulk data
+*/
+type Document map[string]interface{}
+
+/*
+Reader reading the documents of a bulk stream one by one
+*/
+type Reader interface {
+	// Read the next document and its line number, io.EOF at the end
+	Read() (Document, int, error)
+}
+
+/*
+Writer writing the documents of a bulk stream one by one
+*/
+type Writer interface {
+	Write(doc Document) error
+	// Flush writing all buffered data
+	Flush() error
+}
+
+/*
+Mapping mapping of csv columns to document fields, columns without mapping are taken with the column name
+*/
+type Mapping map[string]string
+
+/*
+ParseMapping parsing a mapping like "column=field,column2=field2"
+*/
+func ParseMapping(value string) (Mapping, error) {
+	m := make(Mapping)
+	if strings.TrimSpace(value) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("wrong mapping: %s", pair)
+		}
+		m[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return m, nil
+}
+
+func (m Mapping) field(column string) string {
+	if field, ok := m[column]; ok {
+		return field
+	}
+	return column
+}
+
+/*
+column the csv column of a field, the reverse of field
+*/
+func (m Mapping) column(field string) string {
+	for column, f := range m {
+		if f == field {
+			return column
+		}
+	}
+	return field
+}
+
+/*
+NewReader a reader for the format
+*/
+func NewReader(format string, r io.Reader, mapping Mapping) (Reader, error) {
+	switch format {
+	case FormatNDJSON:
+		return NewNDJSONReader(r), nil
+	case FormatCSV:
+		return NewCSVReader(r, mapping)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+/*
+NewWriter a writer for the format, csv needs the columns
+*/
+func NewWriter(format string, w io.Writer, fields []string, mapping Mapping) (Writer, error) {
+	switch format {
+	case FormatNDJSON:
+		return NewNDJSONWriter(w), nil
+	case FormatCSV:
+		return NewCSVWriter(w, fields, mapping), nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+/*
+NDJSONReader reading newline delimited json, one document per line, empty lines are skipped
+*/
+type NDJSONReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+/*
+NewNDJSONReader creates a reader for newline delimited json
+*/
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &NDJSONReader{scanner: scanner}
+}
+
+/*
+Read the next document, a line which is no json object is returned as LineError
+*/
+func (r *NDJSONReader) Read() (Document, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := bytes.TrimSpace(r.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var doc Document
+		if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+			msg := "no json object"
+			if err != nil {
+				msg = err.Error()
+			}
+			return nil, r.line, &LineError{Line: r.line, Message: msg}
+		}
+		return doc, r.line, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, r.line, err
+	}
+	return nil, r.line, io.EOF
+}
+
+/*
+CSVReader reading csv with a header line, the columns are mapped to fields.
+Numbers, booleans and json arrays and objects are converted, empty cells are omitted.
+*/
+type CSVReader struct {
+	reader *csv.Reader
+	fields []string
+}
+
+/*
+NewCSVReader creates a reader for csv, the first line is the header
+*/
+func NewCSVReader(r io.Reader, mapping Mapping) (*CSVReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read csv header: %s", err.Error())
+	}
+	fields := make([]string, len(header))
+	for i, column := range header {
+		fields[i] = mapping.field(strings.TrimSpace(column))
+	}
+	return &CSVReader{reader: reader, fields: fields}, nil
+}
+
+/*
+Read the next document, a line with the wrong number of cells is returned as LineError
+*/
+func (r *CSVReader) Read() (Document, int, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		if perr, ok := err.(*csv.ParseError); ok {
+			return nil, perr.Line, &LineError{Line: perr.Line, Message: perr.Err.Error()}
+		}
+		return nil, 0, err
+	}
+	line, _ := r.reader.FieldPos(0)
+	if len(record) != len(r.fields) {
+		return nil, line, &LineError{Line: line, Message: fmt.Sprintf("%d cells, expected %d", len(record), len(r.fields))}
+	}
+	doc := make(Document, len(record))
+	for i, cell := range record {
+		if cell == "" || r.fields[i] == "" {
+			continue
+		}
+		doc[r.fields[i]] = parseCell(cell)
+	}
+	return doc, line, nil
+}
+
+func parseCell(cell string) interface{} {
+	switch {
+	case cell == "true":
+		return true
+	case cell == "false":
+		return false
+	case strings.HasPrefix(cell, "{"), strings.HasPrefix(cell, "["), strings.ContainsAny(cell[:1], "-0123456789"):
+		// only valid json values, so ids like 007 are kept as string
+		var v interface{}
+		if err := json.Unmarshal([]byte(cell), &v); err == nil {
+			return v
+		}
+	}
+	return cell
+}
+
+/*
+NDJSONWriter writing newline delimited json
+*/
+type NDJSONWriter struct {
+	writer *bufio.Writer
+	enc    *json.Encoder
+}
+
+/*
+NewNDJSONWriter creates a writer for newline delimited json
+*/
+func NewNDJSONWriter(w io.Writer) *NDJSONWriter {
+	writer := bufio.NewWriter(w)
+	return &NDJSONWriter{writer: writer, enc: json.NewEncoder(writer)}
+}
+
+/*
+Write writing the document as one line
+*/
+func (w *NDJSONWriter) Write(doc Document) error {
+	return w.enc.Encode(doc)
+}
+
+/*
+Flush writing all buffered data
+*/
+func (w *NDJSONWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+/*
+CSVWriter writing csv with a header line, nested values are written as json
+*/
+type CSVWriter struct {
+	writer  *csv.Writer
+	fields  []string
+	mapping Mapping
+	header  bool
+}
+
+/*
+NewCSVWriter creates a writer for csv with the fields as columns, without fields the fields of the first
+document are used
+*/
+func NewCSVWriter(w io.Writer, fields []string, mapping Mapping) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w), fields: fields, mapping: mapping}
+}
+
+/*
+Write writing the document as one line, the header is written before the first document
+*/
+func (w *CSVWriter) Write(doc Document) error {
+	if !w.header {
+		if len(w.fields) == 0 {
+			for field := range doc {
+				w.fields = append(w.fields, field)
+			}
+			sort.Strings(w.fields)
+		}
+		columns := make([]string, len(w.fields))
+		for i, field := range w.fields {
+			columns[i] = w.mapping.column(field)
+		}
+		if err := w.writer.Write(columns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	record := make([]string, len(w.fields))
+	for i, field := range w.fields {
+		record[i] = formatCell(doc[field])
+	}
+	return w.writer.Write(record)
+}
+
+/*
+Flush writing all buffered data
+*/
+func (w *CSVWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func formatCell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,113 @@
+package bulk
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMapping(t *testing.T) {
+	m, err := ParseMapping(" Name = name ,Value=value")
+	if err != nil || !reflect.DeepEqual(m, Mapping{"Name": "name", "Value": "value"}) {
+		t.Errorf("wrong mapping %v: %v", m, err)
+	}
+	if m.field("Other") != "Other" || m.column("value") != "Value" {
+		t.Error("wrong mapped names")
+	}
+	for _, value := range []string{"Name", "=name", "Name=", "a=b,"} {
+		if _, err := ParseMapping(value); err == nil {
+			t.Errorf("%q: no error", value)
+		}
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	data := "{\"a\":1}\n\nnot json\n[1]\n{\"a\":\"fail\"}\n{\"a\":2}\n"
+	var imported []Document
+	report, err := Import(NewNDJSONReader(strings.NewReader(data)), func(doc Document) error {
+		if doc["a"] == "fail" {
+			return fmt.Errorf("failed")
+		}
+		imported = append(imported, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Lines != 5 || report.Imported != 2 || report.Failed != 3 || len(imported) != 2 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	// the empty line is counted for the line numbers
+	lines := []int{3, 4, 5}
+	for i, lerr := range report.Errors {
+		if lerr.Line != lines[i] {
+			t.Errorf("error %d in line %d", i, lerr.Line)
+		}
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	data := "Name,Value,Tags,ID\nsensor,1.5,\"[\"\"a\"\"]\",007\nshort,1\nempty,,true,x\n"
+	reader, err := NewCSVReader(strings.NewReader(data), Mapping{"Name": "name", "Value": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imported []Document
+	report, err := Import(reader, func(doc Document) error {
+		imported = append(imported, doc)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 2 || report.Failed != 1 || report.Errors[0].Line != 3 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	want := Document{"name": "sensor", "value": 1.5, "Tags": []interface{}{"a"}, "ID": "007"}
+	if !reflect.DeepEqual(imported[0], want) {
+		t.Errorf("wrong document %v", imported[0])
+	}
+	if _, ok := imported[1]["value"]; ok || imported[1]["Tags"] != true {
+		t.Errorf("wrong document %v", imported[1])
+	}
+
+	if _, err := NewCSVReader(strings.NewReader(""), nil); err == nil {
+		t.Error("csv without header")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	docs := []Document{{"name": "a", "value": 1.0, "tags": []interface{}{"x"}}, {"name": "b,c"}}
+	tests := []struct {
+		format string
+		fields []string
+		want   string
+	}{
+		{FormatNDJSON, nil, "{\"name\":\"a\",\"tags\":[\"x\"],\"value\":1}\n{\"name\":\"b,c\"}\n"},
+		{FormatCSV, nil, "Name,tags,value\na,\"[\"\"x\"\"]\",1\n\"b,c\",,\n"},
+		{FormatCSV, []string{"value", "name"}, "value,Name\n1,a\n,\"b,c\"\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writer, err := NewWriter(test.format, &buf, test.fields, Mapping{"Name": "name"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			if err := writer.Write(doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s %v: %q", test.format, test.fields, buf.String())
+		}
+	}
+	if _, err := NewWriter("xml", &bytes.Buffer{}, nil, nil); err == nil {
+		t.Error("unknown format")
+	}
+}
@@ -0,0 +1,73 @@
+package bulk
+
+import (
+	"fmt"
+	"io"
+)
+
+/*
+LineError an error of a single line of the bulk data
+*/
+type LineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+/*
+Report the result of an import
+*/
+type Report struct {
+	Lines    int         `json:"lines"`
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Errors   []LineError `json:"errors,omitempty"`
+	// Truncated more errors than listed
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func (r *Report) fail(err *LineError) {
+	r.Failed++
+	if len(r.Errors) < maxLineErrors {
+		r.Errors = append(r.Errors, *err)
+	} else {
+		r.Truncated = true
+	}
+}
+
+/*
+Handler handling a single document of an import, an error is reported for the line and the import continues
+*/
+type Handler func(doc Document) error
+
+/*
+Import reading all documents and handing them one by one to the handler, so the data is never read completely
+into memory. Errors of single lines are reported, an error of the stream stops the import.
+*/
+func Import(reader Reader, handler Handler) (Report, error) {
+	report := Report{Errors: make([]LineError, 0)}
+	for {
+		doc, line, err := reader.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			lerr, ok := err.(*LineError)
+			if !ok {
+				return report, err
+			}
+			report.Lines++
+			report.fail(lerr)
+			continue
+		}
+		report.Lines++
+		if err := handler(doc); err != nil {
+			report.fail(&LineError{Line: line, Message: err.Error()})
+			continue
+		}
+		report.Imported++
+	}
+}
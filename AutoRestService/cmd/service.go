@@ -4,36 +4,46 @@ import (
 	"context"
 	"crypto/md5"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	api "github.com/willie68/AutoRestIoT/api"
+	"github.com/willie68/AutoRestIoT/backup"
+	"github.com/willie68/AutoRestIoT/bulk"
 	"github.com/willie68/AutoRestIoT/health"
 
 	"github.com/willie68/AutoRestIoT/internal/crypt"
+	"github.com/willie68/AutoRestIoT/jobs"
+	"github.com/willie68/AutoRestIoT/registry"
+	"github.com/willie68/AutoRestIoT/retention"
+	"github.com/willie68/AutoRestIoT/storage"
+	"github.com/willie68/AutoRestIoT/tracing"
+	"github.com/willie68/AutoRestIoT/trash"
 
-	consulApi "github.com/hashicorp/consul/api"
 	config "github.com/willie68/AutoRestIoT/config"
 	"github.com/willie68/AutoRestIoT/logging"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"github.com/go-chi/render"
 
 	flag "github.com/spf13/pflag"
 )
 
-/*
-apiVersion implementing api version for this service
-*/
-const apiVersion = "1"
 const servicename = "autorest-srv"
 
+// version, commit and build time of this service, will be set by the build
+var version = "dev"
+var commit string
+var buildTime string
+
 var port int
 var sslport int
 var system string
@@ -42,8 +52,23 @@ var registryURL string
 var apikey string
 var ssl bool
 var configFile string
+var keyFile string
+var encryptFile string
+var convertFile string
+var convertTo string
+var mapping string
+var importFile string
+var exportFile string
+var model string
+var backupTenant bool
+var restoreFile string
+var tenant string
+var target string
 var serviceConfig config.Config
-var consulAgent *consulApi.Agent
+var serviceRegistry *registry.Registry
+var mdnsAdvertiser *registry.Advertiser
+var stopWatch context.CancelFunc
+var gelfCheck bool
 var log logging.ServiceLogger
 
 func init() {
@@ -56,35 +81,56 @@ func init() {
 	flag.StringVarP(&configFile, "config", "c", config.File, "this is the path and filename to the config file")
 	flag.StringVarP(&serviceURL, "serviceURL", "u", "", "service url from outside")
 	flag.StringVarP(&registryURL, "registryURL", "r", "", "registry url where to connect to consul")
+	flag.StringVarP(&keyFile, "keyfile", "k", "", "file with the key of the encrypted secret file, the key can be given via "+config.SecretKeyEnv+" too")
+	flag.StringVarP(&encryptFile, "encrypt", "e", "", "encrypts the given secret file with the key and writes it to stdout")
+	flag.StringVar(&convertFile, "convert", "", "validates and converts the given ndjson or csv bulk file and writes it to stdout")
+	flag.StringVar(&convertTo, "to", bulk.FormatNDJSON, "format of the converted bulk file, ndjson or csv")
+	flag.StringVar(&mapping, "mapping", "", "mapping of csv columns to fields for the conversion, the import or the export, like column=field,column2=field2")
+	flag.StringVar(&importFile, "import", "", "imports the given ndjson or csv bulk file into the model of the tenant, like the import endpoint")
+	flag.StringVar(&exportFile, "export", "", "exports the model of the tenant into the given ndjson or csv file, like the export endpoint")
+	flag.StringVar(&model, "model", "", "model for --import and --export")
+	flag.BoolVar(&backupTenant, "backup", false, "creates a backup of the tenant, or of all tenants without --tenant, in the backup directory")
+	flag.StringVar(&restoreFile, "restore", "", "restores the given backup file, only the tenant with --tenant, into another tenant with --target")
+	flag.StringVar(&tenant, "tenant", "", "tenant for --backup, --restore, --import and --export")
+	flag.StringVar(&target, "target", "", "target tenant for --restore")
 }
 
 func routes() *chi.Mux {
 	myHandler := api.NewSysAPIHandler(serviceConfig.SystemID, apikey)
-	baseURL := fmt.Sprintf("/api/v%s", apiVersion)
 	router := chi.NewRouter()
 	router.Use(
-		render.SetContentType(render.ContentTypeJSON),
+		api.StreamingDeadlines,
+		api.RequestID,
+		tracing.Middleware,
 		middleware.Logger,
 		middleware.DefaultCompress,
-		middleware.Recoverer,
+		api.Recoverer,
 		myHandler.Handler,
 	)
+	router.NotFound(api.NotFoundHandler)
+	router.MethodNotAllowed(api.MethodNotAllowedHandler)
 
 	router.Route("/", func(r chi.Router) {
-		r.Mount(baseURL+"/config", api.ConfigRoutes())
+		for _, version := range api.Versions() {
+			r.Mount(version.Path(), version.Router())
+		}
 		r.Mount("/health", health.Routes())
 	})
+	// the operations of a batch are passing the same middlewares
+	api.SetRootHandler(router)
 	return router
 }
 
 func healthRoutes() *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(
-		render.SetContentType(render.ContentTypeJSON),
+		api.RequestID,
 		middleware.Logger,
 		middleware.DefaultCompress,
-		middleware.Recoverer,
+		api.Recoverer,
 	)
+	router.NotFound(api.NotFoundHandler)
+	router.MethodNotAllowed(api.MethodNotAllowedHandler)
 
 	router.Route("/", func(r chi.Router) {
 		r.Mount("/health", health.Routes())
@@ -96,23 +142,74 @@ func main() {
 	log.Info("starting server")
 	flag.Parse()
 
+	if encryptFile != "" {
+		encryptSecretFile()
+		return
+	}
+	if convertFile != "" {
+		convertBulkFile()
+		return
+	}
+
 	config.File = configFile
+	config.SetFlags(config.Flags{
+		Port:        port,
+		Sslport:     sslport,
+		SystemID:    system,
+		ServiceURL:  serviceURL,
+		RegistryURL: registryURL,
+		KeyFile:     keyFile,
+	})
 	if err := config.Load(); err != nil {
-		log.Alertf("can't load config file: %s", err.Error())
+		if verr, ok := err.(*config.ValidationError); ok {
+			for _, msg := range verr.Errors {
+				log.Alertf("config: %s", msg)
+			}
+			log.Fatalf("can't load config: %d errors found", len(verr.Errors))
+		}
+		log.Fatalf("can't load config: %s", err.Error())
 	}
 	serviceConfig = config.Get()
-	initConfig()
 	initGraylog()
+	if backupTenant || restoreFile != "" {
+		runBackup()
+		return
+	}
+	if importFile != "" || exportFile != "" {
+		runBulk()
+		return
+	}
+	initTracing()
+	config.OnChange(applyConfig)
+	var watchCtx context.Context
+	watchCtx, stopWatch = context.WithCancel(context.Background())
+	config.WatchConsulKV(watchCtx)
+	config.HandleReloadSignal()
+	if serviceConfig.Reload.Watch {
+		config.WatchFiles(time.Second * time.Duration(serviceConfig.Reload.Interval))
+	}
 
 	healthCheckConfig := health.CheckConfig(serviceConfig.HealthCheck)
 
 	health.InitHealthSystem(healthCheckConfig)
 
-	defer log.Close()
-
-	if serviceConfig.SystemID == "" {
-		log.Fatal("system id not given, can't start! Please use config file or -s parameter")
+	if err := storage.Start(storage.Config(serviceConfig.Storage)); err != nil {
+		log.Fatalf("can't start storage: %s", err.Error())
+	}
+	storage.SetModels(storageModels(serviceConfig.Models))
+	backup.SetSource(storage.BackupSource{})
+	if err := jobs.Start(jobs.Config(serviceConfig.Jobs)); err != nil {
+		log.Fatalf("can't start jobs: %s", err.Error())
 	}
+	backup.Schedule(backup.Config(serviceConfig.Backup), serviceConfig.SystemID)
+	trash.Register(trash.KindStore, storage.TrashHandler{})
+	trash.Register(trash.KindDocument, storage.TrashHandler{})
+	if err := trash.Start(trash.Config(serviceConfig.Trash)); err != nil {
+		log.Fatalf("can't start trash: %s", err.Error())
+	}
+	retention.SetStore(storage.RetentionStore{})
+	registerRetention(nil, serviceConfig.Models)
+	retention.Schedule(retentionConfig(serviceConfig.Retention))
 
 	gc := crypt.GenerateCertificate{
 		Organization: "EASY SOFTWARE",
@@ -129,6 +226,11 @@ func main() {
 	}
 
 	api.SystemID = serviceConfig.SystemID
+	api.Build = api.BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	}
 	apikey = getApikey()
 	api.APIKey = apikey
 	log.Infof("systemid: %s", serviceConfig.SystemID)
@@ -138,6 +240,7 @@ func main() {
 	if serviceConfig.RegistryURL != "" {
 		log.Infof("registryURL: %s", serviceConfig.RegistryURL)
 	}
+	initAPIVersions()
 	router := routes()
 	walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
 		log.Infof("%s %s", method, route)
@@ -170,7 +273,7 @@ func main() {
 		}
 		go func() {
 			log.Infof("starting https server on address: %s", sslsrv.Addr)
-			if err := sslsrv.ListenAndServeTLS("", ""); err != nil {
+			if err := sslsrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 				log.Alertf("error starting server: %s", err.Error())
 			}
 		}()
@@ -183,7 +286,7 @@ func main() {
 		}
 		go func() {
 			log.Infof("starting http server on address: %s", srv.Addr)
-			if err := srv.ListenAndServe(); err != nil {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				log.Alertf("error starting server: %s", err.Error())
 			}
 		}()
@@ -198,7 +301,7 @@ func main() {
 		}
 		go func() {
 			log.Infof("starting http server on address: %s", srv.Addr)
-			if err := srv.ListenAndServe(); err != nil {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 				log.Alertf("error starting server: %s", err.Error())
 			}
 		}()
@@ -207,77 +310,492 @@ func main() {
 	if serviceConfig.RegistryURL != "" {
 		initRegistry()
 	}
+	if serviceConfig.MDNS.Enabled {
+		initMDNS()
+	}
+	health.SetReady(true)
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	sig := <-c
+	log.Infof("received %s, shutting down", sig)
+
+	shutdown(srv, sslsrv)
+
+	log.Info("finished")
+	log.Close()
+
+	os.Exit(0)
+}
+
+/*
+shutdown stopping the service in order: readiness off, deregistration, draining, servers, background workers
+*/
+func shutdown(servers ...*http.Server) {
+	// no new traffic from load balancers or the registry
+	health.SetReady(false)
+	if stopWatch != nil {
+		stopWatch()
+	}
+	deregister()
+
+	if serviceConfig.Shutdown.Drain > 0 {
+		log.Infof("draining for %d seconds", serviceConfig.Shutdown.Drain)
+		time.Sleep(time.Second * time.Duration(serviceConfig.Shutdown.Drain))
+	}
 
 	log.Info("waiting for clients")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	ctx, cancel := shutdownContext()
+	for _, server := range servers {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(ctx); err != nil {
+			log.Alertf("error stopping server %s: %s", server.Addr, err.Error())
+		}
+	}
+	cancel()
+
+	health.Stop()
+	backup.StopSchedule()
+	trash.Stop()
+	retention.StopSchedule()
+	// every step gets the full timeout, the servers may have used up theirs
+	ctx, cancel = shutdownContext()
+	jobs.Stop(ctx)
+	cancel()
+
+	ctx, cancel = shutdownContext()
 	defer cancel()
+	if err := tracing.Shutdown(ctx); err != nil {
+		log.Alertf("can't flush traces: %s", err.Error())
+	}
+}
 
-	srv.Shutdown(ctx)
-	if ssl {
-		sslsrv.Shutdown(ctx)
+/*
+shutdownContext a context with the shutdown timeout for a single step of the shutdown
+*/
+func shutdownContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), time.Second*time.Duration(serviceConfig.Shutdown.Timeout))
+}
+
+func initGraylog() {
+	level, err := logging.ParseLevel(serviceConfig.Logging.Level)
+	if err != nil {
+		log.Alertf("wrong log level: %s", err.Error())
 	}
+	logging.ConfigureLevel(level)
+	logging.HandleLevelSignal()
 
-	log.Info("finished")
+	initSinks()
+	initGelf()
+}
 
-	os.Exit(0)
+func initSinks() {
+	sinks := make([]logging.SinkConfig, 0, len(serviceConfig.Logging.Sinks))
+	for _, sink := range serviceConfig.Logging.Sinks {
+		sinks = append(sinks, logging.SinkConfig(sink))
+	}
+	if err := log.InitSinks(sinks); err != nil {
+		log.Alertf("can't initialise log sinks: %s", err.Error())
+	}
 }
 
-func initGraylog() {
+func initGelf() {
 	log.GelfURL = serviceConfig.Logging.Gelfurl
 	log.GelfPort = serviceConfig.Logging.Gelfport
+	log.GelfProtocol = serviceConfig.Logging.Gelfprotocol
+	log.GelfBufferSize = serviceConfig.Logging.Gelfbuffer
+	log.GelfCAFile = serviceConfig.Logging.GelfCAFile
+	log.GelfInsecure = serviceConfig.Logging.GelfInsecure
+	log.GelfLevel = serviceConfig.Logging.GelfLevel
 	log.SystemID = serviceConfig.SystemID
 
-	log.InitGelf()
+	if err := log.InitGelf(); err != nil {
+		log.Alertf("can't initialise gelf logging: %s", err.Error())
+		return
+	}
+	if log.GelfURL != "" && !gelfCheck {
+		health.RegisterCheck("graylog", false, log.CheckGelf)
+		gelfCheck = true
+	}
+}
+
+/*
+applyConfig applying the changed settings at runtime, the config package reports all other changes as restart required
+*/
+func applyConfig(old config.Config, new config.Config) {
+	serviceConfig.Logging = new.Logging
+	serviceConfig.HealthCheck = new.HealthCheck
+	serviceConfig.Backup = new.Backup
+	serviceConfig.Trash = new.Trash
+	serviceConfig.Retention = new.Retention
+	serviceConfig.Models = new.Models
+
+	if old.Logging.Level != new.Logging.Level {
+		level, err := logging.ParseLevel(new.Logging.Level)
+		if err != nil {
+			log.Alertf("wrong log level: %s", err.Error())
+		} else {
+			logging.ConfigureLevel(level)
+			log.Infof("log level changed to %s", level)
+		}
+	}
+	if !reflect.DeepEqual(old.Logging.Sinks, new.Logging.Sinks) {
+		log.Info("log sinks changed")
+		initSinks()
+	}
+	if !reflect.DeepEqual(gelfSettings(old.Logging), gelfSettings(new.Logging)) {
+		log.Info("graylog settings changed")
+		initGelf()
+	}
+	if old.HealthCheck.Period != new.HealthCheck.Period {
+		health.SetPeriod(new.HealthCheck.Period)
+	}
+	if old.Trash.Retention != new.Trash.Retention {
+		trash.SetRetention(new.Trash.Retention)
+	}
+	if !reflect.DeepEqual(old.Retention, new.Retention) {
+		log.Info("retention settings changed")
+		retention.Schedule(retentionConfig(new.Retention))
+	}
+	if !reflect.DeepEqual(old.Models, new.Models) {
+		log.Info("model definitions changed")
+		storage.SetModels(storageModels(new.Models))
+		registerRetention(old.Models, new.Models)
+	}
+	if old.Backup != new.Backup {
+		log.Info("backup settings changed")
+		backup.Schedule(backup.Config(new.Backup), new.SystemID)
+	}
+}
+
+/*
+retentionConfig the config of the expiry worker
+*/
+func retentionConfig(c config.Retention) retention.Config {
+	policies := make(map[string]retention.Policy)
+	for model, p := range c.Policies {
+		policies[model] = retention.Policy(p)
+	}
+	return retention.Config{Interval: c.Interval, Batch: c.Batch, Policies: policies}
+}
+
+/*
+registerRetention registering the retention policies of the model definitions, the policies of the removed
+models are removed
+*/
+func registerRetention(old []config.Model, models []config.Model) {
+	for _, m := range old {
+		retention.Register(m.Name, retention.Policy{})
+	}
+	for _, m := range models {
+		if err := retention.Register(m.Name, retention.Policy(m.Retention)); err != nil {
+			log.Alertf("%s", err.Error())
+		}
+	}
+}
+
+/*
+storageModels the model definitions of the storage
+*/
+func storageModels(models []config.Model) []storage.Model {
+	list := make([]storage.Model, 0, len(models))
+	for _, m := range models {
+		fields := make([]storage.Field, 0, len(m.Fields))
+		for _, f := range m.Fields {
+			fields = append(fields, storage.Field(f))
+		}
+		list = append(list, storage.Model{Name: m.Name, Fields: fields})
+	}
+	return list
+}
+
+/*
+gelfSettings only the graylog part of the logging config
+*/
+func gelfSettings(l config.Logging) config.Logging {
+	l.Level = ""
+	l.Sinks = nil
+	return l
+}
+
+func initTracing() {
+	if err := tracing.Init(tracing.Config(serviceConfig.Tracing), servicename, serviceConfig.SystemID); err != nil {
+		log.Alertf("can't initialise tracing: %s", err.Error())
+		return
+	}
+	if serviceConfig.Tracing.Enabled {
+		log.Infof("tracing active, endpoint: %s", serviceConfig.Tracing.Endpoint)
+	}
 }
 
 func initRegistry() {
 	//register to consul, if configured
-	consulConfig := consulApi.DefaultConfig()
-	consulURL, err := url.Parse(serviceConfig.RegistryURL)
-	consulConfig.Scheme = consulURL.Scheme
-	consulConfig.Address = fmt.Sprintf("%s:%s", consulURL.Hostname(), consulURL.Port())
-	consulClient, err := consulApi.NewClient(consulConfig)
+	interval := time.Second * time.Duration(serviceConfig.Registry.Interval)
+	deregisterAfter := time.Second * time.Duration(serviceConfig.Registry.DeregisterAfter)
+	reg, err := registry.New(registry.Config{
+		URL:         serviceConfig.RegistryURL,
+		Token:       serviceConfig.Registry.Token,
+		ServiceName: servicename,
+		ServiceID:   serviceConfig.Registry.ServiceID,
+		ServiceURL:  serviceConfig.ServiceURL,
+		Tags:        append(versionTags(), serviceConfig.Registry.Tags...),
+		Meta: map[string]string{
+			"version":     version,
+			"system_id":   serviceConfig.SystemID,
+			"api_version": api.ActualVersion().Name,
+		},
+		Check:           serviceConfig.Registry.Check,
+		Interval:        interval,
+		DeregisterAfter: deregisterAfter,
+		Healthy:         health.Healthy,
+	})
 	if err != nil {
-		log.Alertf("can't connect to consul. %v", err)
+		log.Alertf("can't register to consul. %s", err)
+		return
 	}
-	consulAgent = consulClient.Agent()
+	reg.Start()
+	serviceRegistry = reg
+}
 
-	check := new(consulApi.AgentServiceCheck)
-	check.HTTP = fmt.Sprintf("%s/health/health", serviceConfig.ServiceURL)
-	check.Timeout = (time.Minute * 1).String()
-	check.Interval = (time.Second * 30).String()
-	check.TLSSkipVerify = true
-	serviceDef := &consulApi.AgentServiceRegistration{
-		Name:  servicename,
-		Check: check,
+/*
+versionTags a tag for every served api version, like v1
+*/
+func versionTags() []string {
+	tags := make([]string, 0)
+	for _, version := range api.Versions() {
+		tags = append(tags, "v"+version.Name)
 	}
+	return tags
+}
 
-	err = consulAgent.ServiceRegister(serviceDef)
+/*
+initAPIVersions marking the configured api versions as deprecated
+*/
+func initAPIVersions() {
+	for _, version := range serviceConfig.APIVersions {
+		// the dates are checked by the config validation
+		deprecation, _ := config.ParseDate(version.Deprecation)
+		sunset, _ := config.ParseDate(version.Sunset)
+		if err := api.Deprecate(version.Version, deprecation, sunset); err != nil {
+			log.Alertf("can't deprecate api version: %s", err.Error())
+		}
+	}
+}
 
+func initMDNS() {
+	port := serviceConfig.Port
+	if ssl {
+		port = serviceConfig.Sslport
+	}
+	advertiser, err := registry.NewAdvertiser(registry.MDNSConfig{
+		Instance:  serviceConfig.MDNS.Instance,
+		Service:   serviceConfig.MDNS.Service,
+		Domain:    serviceConfig.MDNS.Domain,
+		Interface: serviceConfig.MDNS.Interface,
+		Port:      port,
+		TXT: []string{
+			"apiversion=" + api.ActualVersion().Name,
+			"path=" + api.ActualVersion().Path(),
+			"systemid=" + serviceConfig.SystemID,
+			"version=" + version,
+			fmt.Sprintf("tls=%t", ssl),
+			fmt.Sprintf("sslport=%d", serviceConfig.Sslport),
+			fmt.Sprintf("port=%d", serviceConfig.Port),
+		},
+	})
 	if err != nil {
-		log.Alertf("can't register to consul. %s", err)
-		time.Sleep(time.Second * 60)
+		log.Alertf("can't start mdns advertisement. %s", err)
+		return
 	}
+	mdnsAdvertiser = advertiser
+}
 
+func deregister() {
+	if mdnsAdvertiser != nil {
+		if err := mdnsAdvertiser.Stop(); err != nil {
+			log.Alertf("can't stop mdns advertisement. %s", err)
+		}
+	}
+	if serviceRegistry == nil {
+		return
+	}
+	if err := serviceRegistry.Stop(); err != nil {
+		log.Alertf("can't deregister from consul. %s", err)
+	}
 }
 
-func initConfig() {
-	if port > 0 {
-		serviceConfig.Port = port
+/*
+encryptSecretFile encrypting a plain secret file, the result is written to stdout
+*/
+func encryptSecretFile() {
+	key, err := crypt.LoadKey(config.SecretKeyEnv, keyFile)
+	if err != nil {
+		log.Fatalf("can't load key: %s", err.Error())
+	}
+	data, err := ioutil.ReadFile(encryptFile)
+	if err != nil {
+		log.Fatalf("can't read secret file: %s", err.Error())
+	}
+	if crypt.IsEncrypted(data) {
+		log.Fatal("secret file is already encrypted")
+	}
+	encrypted, err := crypt.Encrypt(data, key)
+	if err != nil {
+		log.Fatalf("can't encrypt secret file: %s", err.Error())
+	}
+	os.Stdout.Write(encrypted)
+}
+
+/*
+convertBulkFile converting a bulk file between ndjson and csv, the file is streamed to stdout,
+the errors per line are logged. The format of the file is taken from the extension.
+*/
+func convertBulkFile() {
+	columns, err := bulk.ParseMapping(mapping)
+	if err != nil {
+		log.Fatalf("can't parse mapping: %s", err.Error())
+	}
+	file, err := os.Open(convertFile)
+	if err != nil {
+		log.Fatalf("can't open bulk file: %s", err.Error())
 	}
-	if sslport > 0 {
-		serviceConfig.Sslport = sslport
+	defer file.Close()
+	reader, err := bulk.NewReader(bulkFormat(convertFile), file, columns)
+	if err != nil {
+		log.Fatalf("can't read bulk file: %s", err.Error())
+	}
+	writer, err := bulk.NewWriter(convertTo, os.Stdout, nil, columns)
+	if err != nil {
+		log.Fatalf("can't write bulk file: %s", err.Error())
 	}
-	if system != "" {
-		serviceConfig.SystemID = system
+	report, err := bulk.Import(reader, bulk.Handler(writer.Write))
+	writer.Flush()
+	for _, lerr := range report.Errors {
+		log.Alertf("%s: %s", convertFile, lerr.Error())
 	}
-	if serviceURL != "" {
-		serviceConfig.ServiceURL = serviceURL
+	if err != nil {
+		log.Fatalf("conversion stopped after %d lines: %s", report.Lines, err.Error())
+	}
+	log.Infof("%d lines, %d converted, %d failed", report.Lines, report.Imported, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+/*
+runBackup creating or restoring a backup from the command line
+*/
+func runBackup() {
+	if err := storage.Start(storage.Config(serviceConfig.Storage)); err != nil {
+		log.Fatalf("can't start storage: %s", err.Error())
+	}
+	backup.SetSource(storage.BackupSource{})
+	if restoreFile != "" {
+		manifest, err := backup.Restore(restoreFile, tenant, target)
+		if err != nil {
+			log.Fatalf("can't restore backup: %s", err.Error())
+		}
+		log.Infof("backup of %s restored, %d tenants, %d files", manifest.Created.Format(time.RFC3339), len(manifest.Tenants), len(manifest.Files))
+		return
+	}
+	info, err := backup.Create(serviceConfig.Backup.Dir, serviceConfig.SystemID, tenant)
+	if err != nil {
+		log.Fatalf("can't create backup: %s", err.Error())
+	}
+	log.Infof("backup %s created", filepath.Join(serviceConfig.Backup.Dir, info.Name))
+}
+
+/*
+runBulk importing or exporting the documents of a model from the command line. The bulk jobs are running like
+in the service, but with their own temporary job storage, so no jobs of the service are started here.
+The format of the file is taken from the extension.
+*/
+func runBulk() {
+	if tenant == "" || model == "" {
+		log.Fatal("--import and --export need --tenant and --model")
+	}
+	columns, err := bulk.ParseMapping(mapping)
+	if err != nil {
+		log.Fatalf("can't parse mapping: %s", err.Error())
+	}
+	if err := storage.Start(storage.Config(serviceConfig.Storage)); err != nil {
+		log.Fatalf("can't start storage: %s", err.Error())
+	}
+	storage.SetModels(storageModels(serviceConfig.Models))
+	dir, err := ioutil.TempDir("", "autorest-bulk")
+	if err != nil {
+		log.Fatalf("can't create job storage: %s", err.Error())
+	}
+	if err := jobs.Start(jobs.Config{Workers: 1, Storage: dir}); err != nil {
+		os.RemoveAll(dir)
+		log.Fatalf("can't start jobs: %s", err.Error())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	if importFile != "" {
+		if err = importBulkFile(ctx, columns); err != nil {
+			err = fmt.Errorf("can't import %s: %s", importFile, err.Error())
+		}
+	}
+	if err == nil && exportFile != "" {
+		if err = exportBulkFile(ctx, columns); err != nil {
+			err = fmt.Errorf("can't export %s: %s", exportFile, err.Error())
+		}
+	}
+	stop()
+	jobs.Stop(context.Background())
+	os.RemoveAll(dir)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func importBulkFile(ctx context.Context, columns bulk.Mapping) error {
+	file, err := os.Open(importFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	params := api.BulkParams{Model: model, Format: bulkFormat(importFile), Mapping: columns}
+	report, err := api.RunImport(ctx, tenant, params, file)
+	if err != nil {
+		return err
+	}
+	for _, lerr := range report.Errors {
+		log.Alertf("%s: %s", importFile, lerr.Error())
+	}
+	log.Infof("%d lines, %d imported, %d failed", report.Lines, report.Imported, report.Failed)
+	return nil
+}
+
+func exportBulkFile(ctx context.Context, columns bulk.Mapping) error {
+	file, err := os.Create(exportFile)
+	if err != nil {
+		return err
+	}
+	params := api.BulkParams{Model: model, Format: bulkFormat(exportFile), Mapping: columns}
+	result, err := api.RunExport(ctx, tenant, params, file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(exportFile)
+		return err
+	}
+	log.Infof("%d documents exported", result.Documents)
+	return nil
+}
+
+/*
+bulkFormat the format of a bulk file by its extension
+*/
+func bulkFormat(file string) string {
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		return bulk.FormatCSV
 	}
+	return bulk.FormatNDJSON
 }
 
 func getApikey() string {
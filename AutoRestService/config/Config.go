@@ -10,20 +10,325 @@ type Config struct {
 	ServiceURL string `yaml:"serviceURL"`
 	//this is the url where to register this service
 	RegistryURL string `yaml:"registryURL"`
+	//configuration of the consul registration
+	Registry Registry `yaml:"registry"`
+	//reading the configuration from the consul kv store of the registry
+	ConsulKV ConsulKV `yaml:"consulkv"`
+	//advertising this service via mDNS/DNS-SD in the local network
+	MDNS MDNS `yaml:"mdns"`
 	//this is the url where to register this service
 	SystemID string `yaml:"systemID"`
 
-	SecretFile string  `yaml:"secretfile"`
-	Logging    Logging `yaml:"logging"`
+	SecretFile string `yaml:"secretfile"`
+	//encryption of the secret file and the providers for ${secret:path} references
+	Secrets Secrets `yaml:"secrets"`
+
+	Logging Logging `yaml:"logging"`
 
 	HealthCheck HealthCheck `yaml:"healthcheck"`
+
+	Tracing Tracing `yaml:"tracing"`
+
+	Shutdown Shutdown `yaml:"shutdown"`
+
+	Reload LiveReload `yaml:"reload"`
+
+	//deprecation of old api versions
+	APIVersions []APIVersion `yaml:"apiversions"`
+
+	//the generated api documentation
+	OpenAPI OpenAPI `yaml:"openapi"`
+
+	//storing the responses of requests with an Idempotency-Key header for retries
+	Idempotency Idempotency `yaml:"idempotency"`
+
+	//executing multiple operations in one request
+	Batch Batch `yaml:"batch"`
+
+	//background jobs for long running operations
+	Jobs Jobs `yaml:"jobs"`
+
+	//the stored documents of the models
+	Storage Storage `yaml:"storage"`
+
+	//definitions of the models, documents of other models are stored without validation
+	Models []Model `yaml:"models"`
+
+	//backups of the tenant data
+	Backup Backup `yaml:"backup"`
+
+	//deleted stores and documents, which can be restored
+	Trash Trash `yaml:"trash"`
+
+	//expiry of old documents by the retention policies of the models
+	Retention Retention `yaml:"retention"`
+
+	// all secret values resolved from references, for redacting
+	resolved []string
+}
+
+// Secrets configuration of the secret file encryption and the secret providers
+type Secrets struct {
+	//file with the key of an encrypted secret file, the key can be given via AUTOREST_SECRET_KEY too
+	KeyFile string `yaml:"keyfile"`
+	//providers for ${secret:path} references in order of lookup, default is the secret file and the environment
+	Providers []SecretProvider `yaml:"providers"`
+}
+
+// SecretProvider configuration of a single secret provider
+type SecretProvider struct {
+	//type of the provider: file (the secret file), env, dir or vault
+	Type string `yaml:"type"`
+	//directory of the mounted secrets for dir, e.g. /run/secrets
+	Path string `yaml:"path"`
+	//url of the vault server
+	URL string `yaml:"url"`
+	//mount of the vault kv engine, default is secret
+	Mount string `yaml:"mount"`
+	//version of the vault kv engine, 1 or 2, default is 2
+	KVVersion int `yaml:"kvversion"`
+	//vault token, default is the environment variable VAULT_TOKEN
+	Token string `yaml:"token" redact:"true"`
+	//file with the vault token
+	TokenFile string `yaml:"tokenfile"`
+}
+
+// Registry configuration of the consul registration
+type Registry struct {
+	//unique id of this instance, default is generated from service name, system id, host and port
+	ServiceID string `yaml:"serviceid"`
+	//additional tags of the service
+	Tags []string `yaml:"tags"`
+	//type of the health check: http or ttl
+	Check string `yaml:"check"`
+	//interval in seconds of the health check and the re-registration
+	Interval int `yaml:"interval"`
+	//seconds after consul removes a critical service, 0 for never
+	DeregisterAfter int `yaml:"deregisterafter"`
+	//acl token for consul, should be set in the secret file
+	Token string `yaml:"token" redact:"true"`
+}
+
+// ConsulKV configuration of the consul kv config source
+type ConsulKV struct {
+	//merge the settings of the kv prefix over the config file
+	Enabled bool `yaml:"enabled"`
+	//kv prefix, default is autorest/{systemID}
+	Prefix string `yaml:"prefix"`
+	//watch the prefix and apply changes at runtime
+	Watch bool `yaml:"watch"`
+}
+
+// MDNS configuration of the mDNS/DNS-SD service advertisement
+type MDNS struct {
+	//start the mDNS responder
+	Enabled bool `yaml:"enabled"`
+	//instance name, default is the hostname
+	Instance string `yaml:"instance"`
+	//DNS-SD service type, default is _autorest._tcp
+	Service string `yaml:"service"`
+	//domain, default is local.
+	Domain string `yaml:"domain"`
+	//network interface to advertise on, default is the system default
+	Interface string `yaml:"interface"`
 }
 
 type Logging struct {
+	//global log level: debug, info, alert or fatal, can be changed at runtime via the admin api
+	Level    string `yaml:"level"`
 	Gelfurl  string `yaml:"gelf-url"`
 	Gelfport int    `yaml:"gelf-port"`
+	//transport protocol for gelf, udp, tcp or tls
+	Gelfprotocol string `yaml:"gelf-protocol"`
+	//size of the buffer for async sending of log messages
+	Gelfbuffer int `yaml:"gelf-buffer"`
+	//ca file for verifying the graylog server certificate on tls
+	GelfCAFile string `yaml:"gelf-cafile"`
+	//don't verify the graylog server certificate on tls
+	GelfInsecure bool `yaml:"gelf-insecure"`
+	//minimum level of messages send to graylog
+	GelfLevel string `yaml:"gelf-level"`
+	//additional log sinks, if none is configured, all messages are written to stderr
+	Sinks []LogSink `yaml:"sinks"`
+}
+
+// LogSink configuration of a single log sink
+type LogSink struct {
+	//type of the sink: stdout, file or syslog
+	Type string `yaml:"type"`
+	//minimum level of the messages: debug, info, alert or fatal
+	Level string `yaml:"level"`
+	//format of the messages: text or json
+	Format string `yaml:"format"`
+
+	//name of the log file
+	Filename string `yaml:"filename"`
+	//maximum size of the log file in MB before rotating
+	MaxSize int `yaml:"maxsize"`
+	//time based rotation: hourly or daily
+	Rotate string `yaml:"rotate"`
+	//maximum age of rotated files in days
+	MaxAge int `yaml:"maxage"`
+	//maximum count of rotated files
+	MaxBackups int `yaml:"maxbackups"`
+	//compress rotated files
+	Compress bool `yaml:"compress"`
+
+	//network for syslog: udp, tcp or unix
+	Network string `yaml:"network"`
+	//address of the syslog server or path to the unix socket
+	Address string `yaml:"address"`
+	//syslog facility, like local0
+	Facility string `yaml:"facility"`
+	//app name for syslog messages
+	AppName string `yaml:"appname"`
 }
 
 type HealthCheck struct {
 	Period int `yaml:"period"`
 }
+
+// Shutdown configuration of the graceful shutdown
+type Shutdown struct {
+	//seconds to wait after the readiness is switched off, so load balancers can stop sending requests
+	Drain int `yaml:"drain"`
+	//max seconds to wait for running requests
+	Timeout int `yaml:"timeout"`
+}
+
+// LiveReload configuration of the live reload of the config and secret file, a reload can be triggered with SIGHUP too
+type LiveReload struct {
+	//watch the config and the secret file for changes
+	Watch bool `yaml:"watch"`
+	//seconds between the checks of the files
+	Interval int `yaml:"interval"`
+}
+
+// APIVersion deprecation of an api version
+type APIVersion struct {
+	//the api version, like 1
+	Version string `yaml:"version"`
+	//since this date the version is deprecated, RFC 3339 or 2006-01-02
+	Deprecation string `yaml:"deprecation"`
+	//at this date the version will be removed
+	Sunset string `yaml:"sunset"`
+}
+
+// Idempotency configuration of the Idempotency-Key handling
+type Idempotency struct {
+	//seconds a response is stored for retries with the same key, 0 disables the handling
+	Window int `yaml:"window"`
+	//max size in bytes of a request body with a key, 0 is unlimited
+	MaxBody int64 `yaml:"maxbody"`
+	//max size in bytes of all stored responses, the oldest are removed first. 0 is unlimited
+	MaxSize int64 `yaml:"maxsize"`
+}
+
+// Storage configuration of the document storage
+type Storage struct {
+	//directory of the documents. Without the documents are only kept in memory
+	Dir string `yaml:"dir"`
+}
+
+// Model definition of a model
+type Model struct {
+	//name of the model in the url /models/{name}/
+	Name string `yaml:"name"`
+	//the defined fields, other fields of the documents are allowed too
+	Fields []ModelField `yaml:"fields"`
+	//retention policy of the model, overrides the policy of the model in retention.policies
+	Retention RetentionPolicy `yaml:"retention"`
+}
+
+// ModelField a defined field of a model
+type ModelField struct {
+	Name string `yaml:"name"`
+	//string, number, integer, boolean, time, object or array
+	Type     string `yaml:"type"`
+	Required bool   `yaml:"required"`
+}
+
+// Trash configuration of the trash for deleted stores and documents
+type Trash struct {
+	//seconds a deleted item can be restored, afterwards it's purged
+	Retention int `yaml:"retention"`
+	//directory where the trash is stored, default is .trash in the directory of the documents.
+	//Without both the trash is only kept in memory
+	Storage string `yaml:"storage"`
+}
+
+// Retention configuration of the expiry worker
+type Retention struct {
+	//seconds between the runs of the expiry worker, 0 disables the worker
+	Interval int `yaml:"interval"`
+	//max number of documents deleted in one step
+	Batch int `yaml:"batch"`
+	//retention policies by model name, the policy of a model definition overrides this
+	Policies map[string]RetentionPolicy `yaml:"policies"`
+}
+
+// RetentionPolicy retention policy of a model
+type RetentionPolicy struct {
+	//seconds a document is kept, measured by field
+	MaxAge int `yaml:"maxage"`
+	//timestamp field for maxage and maxcount, empty is the creation time of the document
+	Field string `yaml:"field"`
+	//max number of documents per device, the oldest documents are deleted
+	MaxCount int `yaml:"maxcount"`
+	//field with the device of a document, needed for maxcount
+	DeviceField string `yaml:"devicefield"`
+	//field with the expiry time of a single document
+	TTLField string `yaml:"ttlfield"`
+}
+
+// Backup configuration of the backups
+type Backup struct {
+	//directory of the backup archives
+	Dir string `yaml:"dir"`
+	//seconds between the scheduled backups of all tenants, 0 disables the schedule
+	Interval int `yaml:"interval"`
+	//number of backups kept per tenant and of all tenants, 0 is unlimited
+	Keep int `yaml:"keep"`
+	//seconds a backup is kept, 0 is unlimited
+	MaxAge int `yaml:"maxage"`
+}
+
+// Jobs configuration of the background jobs
+type Jobs struct {
+	//number of jobs running in parallel
+	Workers int `yaml:"workers"`
+	//max number of waiting jobs
+	Queue int `yaml:"queue"`
+	//directory where the jobs are stored, so they survive a restart. Without the jobs are only kept in memory
+	Storage string `yaml:"storage"`
+	//seconds a finished job is kept
+	Retention int `yaml:"retention"`
+}
+
+// Batch configuration of the batch endpoint
+type Batch struct {
+	//max number of operations in one batch, 0 is unlimited
+	MaxOperations int `yaml:"maxoperations"`
+}
+
+// OpenAPI configuration of the generated api documentation
+type OpenAPI struct {
+	//serving a swagger ui under /api/v{version}/docs
+	SwaggerUI bool `yaml:"swaggerui"`
+	//base url of the swagger-ui-dist scripts and styles
+	Assets string `yaml:"assets"`
+}
+
+// Tracing configuration of the opentelemetry tracing
+type Tracing struct {
+	//export spans to the otlp endpoint
+	Enabled bool `yaml:"enabled"`
+	//host:port of the otlp http receiver
+	Endpoint string `yaml:"endpoint"`
+	//url path of the otlp traces endpoint, default /v1/traces
+	URLPath string `yaml:"urlpath"`
+	//use http instead of https
+	Insecure bool `yaml:"insecure"`
+	//ratio of sampled traces, between 0 and 1
+	SampleRate float64 `yaml:"samplerate"`
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"github.com/willie68/AutoRestIoT/logging"
+	"github.com/willie68/AutoRestIoT/registry"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	consulKVRootPrefix = "autorest"
+	consulKVWaitTime   = 5 * time.Minute
+	consulKVMinBackoff = 1 * time.Second
+	consulKVMaxBackoff = 1 * time.Minute
+)
+
+var log = logging.ServiceLogger{Package: "config"}
+
+var kvMutex sync.Mutex
+var kvIndex uint64
+var kvWatching bool
+
+/*
+consulKVPrefix the kv prefix of this service with a trailing slash, default is autorest/{systemID}/
+*/
+func consulKVPrefix(c Config) string {
+	prefix := strings.Trim(c.ConsulKV.Prefix, "/")
+	if prefix == "" {
+		prefix = consulKVRootPrefix + "/" + c.SystemID
+	}
+	return prefix + "/"
+}
+
+func consulKVClient(c Config) (*consulApi.Client, error) {
+	if c.RegistryURL == "" {
+		return nil, errors.New("consul kv enabled, but no registry url given")
+	}
+	return registry.NewClient(c.RegistryURL, c.Registry.Token)
+}
+
+/*
+loadConsulKV merging the settings of the consul kv prefix over the config
+*/
+func loadConsulKV(c *Config) error {
+	client, err := consulKVClient(*c)
+	if err != nil {
+		return err
+	}
+	prefix := consulKVPrefix(*c)
+	pairs, meta, err := client.KV().List(prefix, nil)
+	if err != nil {
+		return fmt.Errorf("can't read consul kv %s: %s", prefix, err.Error())
+	}
+	kvMutex.Lock()
+	kvIndex = meta.LastIndex
+	kvMutex.Unlock()
+	return mergeKV(c, prefix, pairs)
+}
+
+/*
+mergeKV every key below the prefix is a path into the config, e.g. autorest/mysystem/logging/level.
+The values are parsed as yaml, so lists and maps are possible too.
+*/
+func mergeKV(c *Config, prefix string, pairs consulApi.KVPairs) error {
+	tree := make(map[string]interface{})
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		if key == "" || strings.HasSuffix(key, "/") {
+			// folders
+			continue
+		}
+		var value interface{}
+		if err := yaml.Unmarshal(pair.Value, &value); err != nil {
+			value = string(pair.Value)
+		}
+		node := tree
+		segments := strings.Split(key, "/")
+		for _, segment := range segments[:len(segments)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[segment] = child
+			}
+			node = child
+		}
+		node[segments[len(segments)-1]] = value
+	}
+	if len(tree) == 0 {
+		return nil
+	}
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return fmt.Errorf("can't convert consul kv: %s", err.Error())
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("can't merge consul kv: %s", err.Error())
+	}
+	return nil
+}
+
+/*
+WatchConsulKV watching the consul kv prefix, on changes the config will be reloaded and the listeners are informed.
+The watch ends, when the context is done.
+*/
+func WatchConsulKV(ctx context.Context) {
+	c := Get()
+	if !c.ConsulKV.Enabled || !c.ConsulKV.Watch {
+		return
+	}
+	kvMutex.Lock()
+	defer kvMutex.Unlock()
+	if kvWatching {
+		return
+	}
+	kvWatching = true
+	go watchConsulKV(ctx)
+}
+
+func watchConsulKV(ctx context.Context) {
+	defer func() {
+		kvMutex.Lock()
+		kvWatching = false
+		kvMutex.Unlock()
+	}()
+	backoff := time.Duration(0)
+	for ctx.Err() == nil {
+		c := Get()
+		prefix := consulKVPrefix(c)
+		client, err := consulKVClient(c)
+		if err == nil {
+			kvMutex.Lock()
+			index := kvIndex
+			kvMutex.Unlock()
+			var meta *consulApi.QueryMeta
+			opts := &consulApi.QueryOptions{
+				WaitIndex: index,
+				WaitTime:  consulKVWaitTime,
+			}
+			_, meta, err = client.KV().List(prefix, opts.WithContext(ctx))
+			if err == nil {
+				backoff = 0
+				// a lower index means consul was reset, so it's handled as change too
+				if meta.LastIndex != index {
+					kvMutex.Lock()
+					kvIndex = meta.LastIndex
+					kvMutex.Unlock()
+					reloadConsulKV()
+				}
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Alertf("can't watch consul kv %s: %s", prefix, err.Error())
+		backoff *= 2
+		if backoff == 0 {
+			backoff = consulKVMinBackoff
+		}
+		if backoff > consulKVMaxBackoff {
+			backoff = consulKVMaxBackoff
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+	}
+}
+
+/*
+reloadConsulKV reloads the whole config with the actual kv settings
+*/
+func reloadConsulKV() {
+	if err := Reload("consul kv"); err != nil {
+		log.Alertf("can't reload config, keeping the actual config: %s", err.Error())
+	}
+}
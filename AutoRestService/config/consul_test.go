@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+func TestMergeKV(t *testing.T) {
+	c := defaultConfig
+	prefix := "autorest/sys/"
+	pairs := consulApi.KVPairs{
+		{Key: prefix},
+		{Key: prefix + "logging/"},
+		{Key: prefix + "logging/level", Value: []byte("DEBUG")},
+		{Key: prefix + "healthcheck/period", Value: []byte("60")},
+		{Key: prefix + "backup/dir", Value: []byte("/var/backups")},
+	}
+	if err := mergeKV(&c, prefix, pairs); err != nil {
+		t.Fatal(err)
+	}
+	if c.Logging.Level != "DEBUG" || c.HealthCheck.Period != 60 || c.Backup.Dir != "/var/backups" {
+		t.Errorf("kv not merged: %+v %+v %+v", c.Logging, c.HealthCheck, c.Backup)
+	}
+	if c.Shutdown.Timeout != defaultConfig.Shutdown.Timeout {
+		t.Error("settings not in the kv changed")
+	}
+}
+
+func TestConsulKVPrefix(t *testing.T) {
+	c := defaultConfig
+	if prefix := consulKVPrefix(c); prefix != "autorest/autorest-srv/" {
+		t.Errorf("wrong default prefix %s", prefix)
+	}
+	c.ConsulKV.Prefix = "/services/autorest/"
+	if prefix := consulKVPrefix(c); prefix != "services/autorest/" {
+		t.Errorf("wrong prefix %s", prefix)
+	}
+}
+
+func TestWatchConsulKVStops(t *testing.T) {
+	blocking := make(chan struct{}, 1)
+	cancelled := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "7")
+		if r.URL.Query().Get("index") == "" {
+			w.Write([]byte("[]"))
+			return
+		}
+		// a blocking query, answered only when the index changes
+		blocking <- struct{}{}
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	}))
+	defer server.Close()
+
+	old := Get()
+	defer set(old)
+	c := defaultConfig
+	c.RegistryURL = server.URL
+	c.ConsulKV.Enabled = true
+	c.ConsulKV.Watch = true
+	set(c)
+	kvMutex.Lock()
+	kvIndex = 7
+	kvMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	WatchConsulKV(ctx)
+	select {
+	case <-blocking:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no blocking query")
+	}
+	cancel()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocking query not cancelled")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		kvMutex.Lock()
+		watching := kvWatching
+		kvMutex.Unlock()
+		if !watching {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,156 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefix of all environment variables of the config
+const EnvPrefix = "AUTOREST"
+
+/*
+Flags the command line parameters, which are overriding all other config sources. Empty values are not set.
+*/
+type Flags struct {
+	Port        int
+	Sslport     int
+	SystemID    string
+	ServiceURL  string
+	RegistryURL string
+	KeyFile     string
+}
+
+var flags Flags
+
+/*
+SetFlags setting the command line parameters, must be called before Load
+*/
+func SetFlags(f Flags) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	flags = f
+}
+
+func applyFlags(c *Config) {
+	configMutex.RLock()
+	f := flags
+	configMutex.RUnlock()
+	if f.Port > 0 {
+		c.Port = f.Port
+	}
+	if f.Sslport > 0 {
+		c.Sslport = f.Sslport
+	}
+	if f.SystemID != "" {
+		c.SystemID = f.SystemID
+	}
+	if f.ServiceURL != "" {
+		c.ServiceURL = f.ServiceURL
+	}
+	if f.RegistryURL != "" {
+		c.RegistryURL = f.RegistryURL
+	}
+	if f.KeyFile != "" {
+		c.Secrets.KeyFile = f.KeyFile
+	}
+}
+
+/*
+applyEnv overriding the config with the AUTOREST_* environment variables. The name of the variable is the
+path of the yaml keys in upper case, separated by _, e.g. AUTOREST_LOGGING_GELF_URL for logging/gelf-url.
+Lists of strings are comma separated, all other lists and maps are given as yaml or json.
+*/
+func applyEnv(c *Config) error {
+	errs := make([]string, 0)
+	applyEnvStruct(reflect.ValueOf(c).Elem(), EnvPrefix, &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func applyEnvStruct(v reflect.Value, prefix string, errs *[]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + envName(key)
+		if field.Type.Kind() == reflect.Struct {
+			applyEnvStruct(v.Field(i), name, errs)
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), value); err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+}
+
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(key))
+}
+
+func setEnvValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("not a number: %s", value)
+		}
+		field.SetInt(i)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("not a boolean: %s", value)
+		}
+		field.SetBool(b)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("not a number: %s", value)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			list := make([]string, 0)
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+			return nil
+		}
+		return setYAMLValue(field, value)
+	default:
+		return setYAMLValue(field, value)
+	}
+	return nil
+}
+
+func setYAMLValue(field reflect.Value, value string) error {
+	ptr := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return fmt.Errorf("can't unmarshal value: %s", err.Error())
+	}
+	field.Set(ptr.Elem())
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	t.Setenv("AUTOREST_PORT", "8000")
+	t.Setenv("AUTOREST_LOGGING_GELF_URL", "graylog.local")
+	t.Setenv("AUTOREST_LOGGING_GELF_INSECURE", "true")
+	t.Setenv("AUTOREST_REGISTRY_TAGS", "a, b,,c")
+	t.Setenv("AUTOREST_LOGGING_SINKS", `[{type: stdout, format: json}]`)
+	c := defaultConfig
+	if err := applyEnv(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 8000 || c.Logging.Gelfurl != "graylog.local" || !c.Logging.GelfInsecure {
+		t.Errorf("values not set: %d %s %t", c.Port, c.Logging.Gelfurl, c.Logging.GelfInsecure)
+	}
+	if tags := c.Registry.Tags; len(tags) != 3 || tags[0] != "a" || tags[1] != "b" || tags[2] != "c" {
+		t.Errorf("wrong list: %v", tags)
+	}
+	if len(c.Logging.Sinks) != 1 || c.Logging.Sinks[0].Format != "json" {
+		t.Errorf("yaml value not set: %+v", c.Logging.Sinks)
+	}
+	if c.Sslport != defaultConfig.Sslport {
+		t.Error("value without variable changed")
+	}
+}
+
+func TestApplyEnvErrors(t *testing.T) {
+	t.Setenv("AUTOREST_PORT", "eighty")
+	t.Setenv("AUTOREST_MDNS_ENABLED", "sometimes")
+	c := defaultConfig
+	err := applyEnv(&c)
+	v, ok := err.(*ValidationError)
+	if !ok || len(v.Errors) != 2 {
+		t.Fatalf("wrong errors: %v", err)
+	}
+	if !hasError(v.Errors, "AUTOREST_PORT") || !hasError(v.Errors, "AUTOREST_MDNS_ENABLED") {
+		t.Errorf("variable names not reported: %v", v.Errors)
+	}
+}
+
+func TestFlagsOverrideEnv(t *testing.T) {
+	t.Setenv("AUTOREST_PORT", "8000")
+	t.Setenv("AUTOREST_SYSTEMID", "env-system")
+	SetFlags(Flags{Port: 7000})
+	defer SetFlags(Flags{})
+	c := defaultConfig
+	if err := applyOverrides(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Port != 7000 || c.SystemID != "env-system" {
+		t.Errorf("wrong order of the overrides: %d %s", c.Port, c.SystemID)
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 
+	"github.com/willie68/AutoRestIoT/internal/crypt"
 	"gopkg.in/yaml.v3"
 )
 
-var config = Config{
+var defaultConfig = Config{
 	Port:       9080,
 	Sslport:    9443,
 	ServiceURL: "http://127.0.0.1",
@@ -16,51 +19,227 @@ var config = Config{
 	HealthCheck: HealthCheck{
 		Period: 30,
 	},
+	Shutdown: Shutdown{
+		Drain:   5,
+		Timeout: 15,
+	},
+	Reload: LiveReload{
+		Interval: 5,
+	},
+	Trash: Trash{
+		Retention: 2592000,
+	},
+	Retention: Retention{
+		Interval: 300,
+		Batch:    1000,
+	},
+	Backup: Backup{
+		Dir:  "backups",
+		Keep: 7,
+	},
+	Jobs: Jobs{
+		Workers:   2,
+		Queue:     1000,
+		Retention: 604800,
+	},
+	Batch: Batch{
+		MaxOperations: 100,
+	},
+	Idempotency: Idempotency{
+		Window:  86400,
+		MaxBody: 1048576,
+		MaxSize: 67108864,
+	},
+	OpenAPI: OpenAPI{
+		Assets: "https://unpkg.com/swagger-ui-dist@5",
+	},
 }
 
+var config = defaultConfig
+var configMutex sync.RWMutex
+
+// Listener will be called after the config has changed
+type Listener func(old Config, new Config)
+
+var listeners []Listener
+
 // File the config file
 var File = "config/service.yaml"
 
 // Get returns loaded config
 func Get() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
 	return config
 }
 
-// Load loads the config
+// OnChange registers a listener for config changes
+func OnChange(listener Listener) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	listeners = append(listeners, listener)
+}
+
+/*
+Load loads the config. The sources are applied in this order, every source overrides the one before:
+defaults < config file < secret file < consul kv < AUTOREST_* environment variables < command line flags.
+Afterwards the config is validated, a ValidationError contains all errors found.
+*/
 func Load() error {
-	_, err := os.Stat(File)
+	c, err := build(false)
 	if err != nil {
 		return err
 	}
+	set(c)
+	return nil
+}
+
+/*
+build building the config from all sources and validating it. Without requireKV an unreachable consul is only logged.
+*/
+func build(requireKV bool) (Config, error) {
+	c, err := loadFile()
+	if err != nil {
+		return c, err
+	}
+	// the secret file and the registry url may be set via env or flags, so the overrides are applied first too
+	applyOverrides(&c)
+	errs := make([]string, 0)
+	secretValues, err := readSecret(&c)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	resolver, err := newResolver(c, secretValues)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	if c.ConsulKV.Enabled {
+		// the consul token may be a secret reference, errors are reported after the kv is merged
+		resolveSecrets(&c, resolver)
+		if err := loadConsulKV(&c); err != nil {
+			if requireKV {
+				return c, err
+			}
+			log.Alertf("can't load consul kv, using the local config: %s", err.Error())
+		}
+	}
+	if err := applyOverrides(&c); err != nil {
+		errs = append(errs, err.(*ValidationError).Errors...)
+	}
+	defaultTrashStorage(&c)
+	errs = append(errs, resolveSecrets(&c, resolver)...)
+	if err := Validate(c); err != nil {
+		errs = append(errs, err.(*ValidationError).Errors...)
+	}
+	if len(errs) > 0 {
+		return c, &ValidationError{Errors: errs}
+	}
+	return c, nil
+}
+
+// trashDir directory of the trash in the document storage, if the trash has no own directory
+const trashDir = ".trash"
+
+/*
+defaultTrashStorage storing the trash in the document storage, if the documents are stored but the trash is not.
+Otherwise the trashed documents could neither be restored nor purged after a restart.
+*/
+func defaultTrashStorage(c *Config) {
+	if c.Trash.Storage == "" && c.Storage.Dir != "" {
+		c.Trash.Storage = filepath.Join(c.Storage.Dir, trashDir)
+	}
+}
+
+func applyOverrides(c *Config) error {
+	err := applyEnv(c)
+	applyFlags(c)
+	return err
+}
+
+/*
+set replaces the actual config and informs the listeners
+*/
+func set(c Config) {
+	configMutex.Lock()
+	old := config
+	config = c
+	list := make([]Listener, len(listeners))
+	copy(list, listeners)
+	configMutex.Unlock()
+	for _, listener := range list {
+		listener(old, c)
+	}
+}
+
+/*
+loadFile loading the config file over the default config
+*/
+func loadFile() (Config, error) {
+	c := defaultConfig
+	_, err := os.Stat(File)
+	if os.IsNotExist(err) {
+		// the config can be given completely via environment
+		log.Infof("config file %s not found, using defaults", File)
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("can't load config file: %s", err.Error())
+	}
 	data, err := ioutil.ReadFile(File)
 	if err != nil {
-		return fmt.Errorf("can't load config file: %s", err.Error())
+		return c, fmt.Errorf("can't load config file: %s", err.Error())
 	}
-	err = yaml.Unmarshal(data, &config)
+	err = yaml.Unmarshal(data, &c)
 	if err != nil {
-		return fmt.Errorf("can't unmarshal config file: %s", err.Error())
+		return c, fmt.Errorf("can't unmarshal config file: %s", err.Error())
 	}
-	return readSecret()
+	return c, nil
 }
 
-func readSecret() error {
-	secretFile := config.SecretFile
-	if secretFile != "" {
-		data, err := ioutil.ReadFile(secretFile)
+/*
+readSecret reading the secret file, an encrypted file is decrypted with the key of AUTOREST_SECRET_KEY or the key file.
+Returns the content for the file secret provider.
+*/
+func readSecret(c *Config) (map[string]interface{}, error) {
+	secretFile := c.SecretFile
+	if secretFile == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(secretFile); os.IsNotExist(err) {
+		// reported by the validation
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't load secret file: %s", err.Error())
+	}
+	if crypt.IsEncrypted(data) {
+		key, err := crypt.LoadKey(SecretKeyEnv, c.Secrets.KeyFile)
 		if err != nil {
-			return fmt.Errorf("can't load secret file: %s", err.Error())
+			return nil, fmt.Errorf("can't decrypt secret file: %s", err.Error())
 		}
-		var secretConfig Secret = Secret{}
-		err = yaml.Unmarshal(data, &secretConfig)
+		data, err = crypt.Decrypt(data, key)
 		if err != nil {
-			return fmt.Errorf("can't unmarshal secret file: %s", err.Error())
+			return nil, fmt.Errorf("can't decrypt secret file: %s", err.Error())
 		}
-		mergeSecret(secretConfig)
 	}
-	return nil
+	var secretConfig Secret = Secret{}
+	err = yaml.Unmarshal(data, &secretConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can't unmarshal secret file: %s", err.Error())
+	}
+	mergeSecret(c, secretConfig)
+	values := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("can't unmarshal secret file: %s", err.Error())
+	}
+	return values, nil
 }
 
-func mergeSecret(secret Secret) {
-	//	config.MongoDB.Username = secret.MongoDB.Username
-	//	config.MongoDB.Password = secret.MongoDB.Password
+func mergeSecret(c *Config, secret Secret) {
+	//	c.MongoDB.Username = secret.MongoDB.Username
+	//	c.MongoDB.Password = secret.MongoDB.Password
+	if secret.Consul.Token != "" {
+		c.Registry.Token = secret.Consul.Token
+	}
 }
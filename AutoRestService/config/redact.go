@@ -0,0 +1,130 @@
+package config
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted the replacement for secret values
+const Redacted = "*****"
+
+// secretKeys keys with secret values, compared with the lower case key name
+var secretKeys = []string{"password", "passwd", "secret", "token", "apikey", "api-key", "api_key", "credentials"}
+
+/*
+Redact a copy of the config with all secret values replaced. Secret fields are marked with the tag redact:"true",
+or have a key name ending with one of the secretKeys, in structs and in maps.
+Values resolved from secret references and passwords in urls are replaced too.
+*/
+func Redact(c Config) Config {
+	redactValue(reflect.ValueOf(&c).Elem(), false, c.resolved)
+	return c
+}
+
+func redactValue(v reflect.Value, secret bool, resolved []string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				// unexported
+				continue
+			}
+			key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			redactValue(v.Field(i), field.Tag.Get("redact") == "true" || secretKey(key), resolved)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		// copying the slice, the original config shares the backing array
+		list := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(list, v)
+		for i := 0; i < list.Len(); i++ {
+			redactValue(list.Index(i), secret, resolved)
+		}
+		v.Set(list)
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		// copying the map, the original config shares it
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			keySecret := secret
+			if iter.Key().Kind() == reflect.String {
+				keySecret = keySecret || secretKey(iter.Key().String())
+			}
+			redactValue(value, keySecret, resolved)
+			m.SetMapIndex(iter.Key(), value)
+		}
+		v.Set(m)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		value := reflect.New(v.Elem().Type()).Elem()
+		value.Set(v.Elem())
+		redactValue(value, secret, resolved)
+		v.Set(value)
+	case reflect.String:
+		if v.String() == "" {
+			return
+		}
+		if secret || containsSecret(v.String(), resolved) {
+			v.SetString(Redacted)
+			return
+		}
+		if u, err := url.Parse(v.String()); err == nil && u.User != nil {
+			v.SetString(u.Redacted())
+		}
+	}
+}
+
+/*
+secretKey checking the key name, a key is secret if it's one of the secretKeys or ends with one, e.g. gelf-token,
+but not tokenizer
+*/
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range secretKeys {
+		if strings.HasSuffix(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+containsSecret checking, if the value contains one of the resolved secrets, references may be part of a value
+*/
+func containsSecret(value string, resolved []string) bool {
+	for _, secret := range resolved {
+		if secret != "" && strings.Contains(value, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Map the config as map with the yaml keys, e.g. for json output
+*/
+func Map(c Config) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
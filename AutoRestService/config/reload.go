@@ -0,0 +1,176 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sections of the config which are applied at runtime, all other changes need a restart of the service
+var reloadable = []string{"logging", "healthcheck", "openapi", "idempotency", "batch", "backup", "trash", "retention", "models"}
+
+var reloadMutex sync.Mutex
+var fileWatching bool
+
+/*
+Changes comparing two configs, returns the yaml paths of all changed settings, e.g. logging.level
+*/
+func Changes(old Config, new Config) []string {
+	changes := make([]string, 0)
+	diffValue(reflect.ValueOf(old), reflect.ValueOf(new), "", &changes)
+	return changes
+}
+
+func diffValue(old reflect.Value, new reflect.Value, path string, changes *[]string) {
+	if old.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			*changes = append(*changes, path)
+		}
+		return
+	}
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		if path != "" {
+			key = path + "." + key
+		}
+		diffValue(old.Field(i), new.Field(i), key, changes)
+	}
+}
+
+/*
+RestartRequired filters the changes, which can't be applied at runtime
+*/
+func RestartRequired(changes []string) []string {
+	list := make([]string, 0)
+	for _, change := range changes {
+		if !isReloadable(change) {
+			list = append(list, change)
+		}
+	}
+	return list
+}
+
+func isReloadable(path string) bool {
+	section := strings.Split(path, ".")[0]
+	for _, r := range reloadable {
+		if r == section {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+effective the new config with the settings of the old config, which need a restart.
+So Get always returns the config the service is running with.
+*/
+func effective(old Config, new Config) Config {
+	o := reflect.ValueOf(old)
+	n := reflect.ValueOf(&new).Elem()
+	t := o.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key != "" && !isReloadable(key) {
+			n.Field(i).Set(o.Field(i))
+		}
+	}
+	// the old settings may contain old secrets
+	new.resolved = append(append([]string{}, old.resolved...), new.resolved...)
+	return new
+}
+
+/*
+Reload rebuilding the config from all sources. The reloadable settings are applied by the listeners,
+changes of all other settings are only reported, they need a restart.
+*/
+func Reload(source string) error {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	c, err := build(true)
+	if err != nil {
+		return err
+	}
+	old := Get()
+	changes := Changes(old, c)
+	if len(changes) == 0 {
+		log.Infof("config reloaded (%s), nothing changed", source)
+		return nil
+	}
+	log.Infof("config changed (%s): %s", source, strings.Join(changes, ", "))
+	if restart := RestartRequired(changes); len(restart) > 0 {
+		log.Alertf("changed settings need a restart of the service: %s", strings.Join(restart, ", "))
+	}
+	set(effective(old, c))
+	return nil
+}
+
+/*
+HandleReloadSignal reloading the config on SIGHUP
+*/
+func HandleReloadSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := Reload("SIGHUP"); err != nil {
+				log.Alertf("can't reload config, keeping the actual config: %s", err.Error())
+			}
+		}
+	}()
+}
+
+/*
+WatchFiles polling the config and the secret file for changes, on changes the config will be reloaded
+*/
+func WatchFiles(interval time.Duration) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	if fileWatching || interval <= 0 {
+		return
+	}
+	fileWatching = true
+	go func() {
+		stamps := fileStamps()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			actual := fileStamps()
+			if actual == stamps {
+				continue
+			}
+			stamps = actual
+			if err := Reload("file change"); err != nil {
+				log.Alertf("can't reload config, keeping the actual config: %s", err.Error())
+			}
+		}
+	}()
+}
+
+/*
+fileStamps modification times and sizes of the config and the secret file, as a comparable string
+*/
+func fileStamps() string {
+	files := []string{File, Get().SecretFile}
+	var b strings.Builder
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		b.WriteString(file)
+		if info, err := os.Stat(file); err == nil {
+			b.WriteString(info.ModTime().String())
+			b.WriteString(strconv.FormatInt(info.Size(), 10))
+		}
+		b.WriteString("|")
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestChanges(t *testing.T) {
+	old := defaultConfig
+	new := defaultConfig
+	new.Port = 8000
+	new.Logging.Level = "DEBUG"
+	new.Registry.Tags = []string{"a"}
+	changes := Changes(old, new)
+	if len(changes) != 3 || changes[0] != "port" || changes[1] != "registry.tags" || changes[2] != "logging.level" {
+		t.Errorf("wrong changes: %v", changes)
+	}
+	restart := RestartRequired(changes)
+	if len(restart) != 2 || restart[0] != "port" || restart[1] != "registry.tags" {
+		t.Errorf("wrong restart list: %v", restart)
+	}
+}
+
+func TestEffective(t *testing.T) {
+	old := defaultConfig
+	new := defaultConfig
+	new.Port = 8000
+	new.Logging.Level = "DEBUG"
+	new.Backup.Keep = 3
+	c := effective(old, new)
+	if c.Port != old.Port {
+		t.Error("setting needing a restart applied")
+	}
+	if c.Logging.Level != "DEBUG" || c.Backup.Keep != 3 {
+		t.Error("reloadable settings not applied")
+	}
+}
+
+func TestReload(t *testing.T) {
+	oldFile, oldConfig := File, Get()
+	defer func() {
+		File = oldFile
+		set(oldConfig)
+	}()
+	File = filepath.Join(t.TempDir(), "service.yaml")
+	if err := ioutil.WriteFile(File, []byte("port: 8000\nlogging:\n    level: INFO\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	var notified Config
+	OnChange(func(old Config, new Config) { notified = new })
+	defer func() {
+		configMutex.Lock()
+		listeners = listeners[:len(listeners)-1]
+		configMutex.Unlock()
+	}()
+
+	ioutil.WriteFile(File, []byte("port: 8001\nlogging:\n    level: DEBUG\n"), 0600)
+	if err := Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	c := Get()
+	if c.Logging.Level != "DEBUG" || notified.Logging.Level != "DEBUG" {
+		t.Error("logging level not reloaded")
+	}
+	if c.Port != 8000 {
+		t.Errorf("port changed to %d without a restart", c.Port)
+	}
+
+	// an invalid file keeps the actual config
+	ioutil.WriteFile(File, []byte("port: -1\nlogging:\n    level: INFO\n"), 0600)
+	if err := Reload("test"); err == nil {
+		t.Error("invalid config reloaded")
+	}
+	if Get().Logging.Level != "DEBUG" {
+		t.Error("config changed by an invalid file")
+	}
+}
+
+func TestTrashStorageDefault(t *testing.T) {
+	c := defaultConfig
+	c.Storage.Dir = "data/documents"
+	defaultTrashStorage(&c)
+	if c.Trash.Storage != filepath.Join("data/documents", trashDir) {
+		t.Errorf("wrong trash storage: %s", c.Trash.Storage)
+	}
+	// an own directory and the memory storage are kept
+	c.Trash.Storage = "data/trash"
+	defaultTrashStorage(&c)
+	c2 := defaultConfig
+	defaultTrashStorage(&c2)
+	if c.Trash.Storage != "data/trash" || c2.Trash.Storage != "" {
+		t.Errorf("trash storage changed: %s, %s", c.Trash.Storage, c2.Trash.Storage)
+	}
+}
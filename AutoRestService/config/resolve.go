@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/willie68/AutoRestIoT/secrets"
+)
+
+// SecretKeyEnv environment variable with the key of an encrypted secret file
+const SecretKeyEnv = "AUTOREST_SECRET_KEY"
+
+/*
+newResolver creates the secret resolver for the configured providers, default is the secret file and the environment
+*/
+func newResolver(c Config, secretValues map[string]interface{}) (*secrets.Resolver, error) {
+	configs := c.Secrets.Providers
+	if len(configs) == 0 {
+		configs = []SecretProvider{{Type: secrets.TypeFile}, {Type: secrets.TypeEnv}}
+	}
+	providers := make([]secrets.Provider, 0, len(configs))
+	for i, pc := range configs {
+		switch pc.Type {
+		case secrets.TypeFile:
+			providers = append(providers, &secrets.MapProvider{Data: secretValues})
+		case secrets.TypeEnv:
+			providers = append(providers, &secrets.EnvProvider{})
+		case secrets.TypeDir:
+			providers = append(providers, &secrets.DirProvider{Dir: pc.Path})
+		case secrets.TypeVault:
+			vault, err := secrets.NewVaultProvider(secrets.VaultConfig{
+				URL:       pc.URL,
+				Mount:     pc.Mount,
+				KVVersion: pc.KVVersion,
+				Token:     pc.Token,
+				TokenFile: pc.TokenFile,
+			})
+			if err != nil {
+				return secrets.NewResolver(providers...), fmt.Errorf("secrets.providers[%d]: %s", i, err.Error())
+			}
+			providers = append(providers, vault)
+		default:
+			return secrets.NewResolver(providers...), fmt.Errorf("secrets.providers[%d]: unknown type %s", i, pc.Type)
+		}
+	}
+	return secrets.NewResolver(providers...), nil
+}
+
+/*
+resolveSecrets replacing the ${secret:path} references in all settings, except the secrets section itself.
+Returns the errors of all unresolved references.
+*/
+func resolveSecrets(c *Config, resolver *secrets.Resolver) []string {
+	errs := make([]string, 0)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || key == "secrets" {
+			continue
+		}
+		resolveValue(v.Field(i), key, resolver, &errs)
+	}
+	c.resolved = resolver.Resolved()
+	return errs
+}
+
+func resolveValue(v reflect.Value, path string, resolver *secrets.Resolver, errs *[]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			resolveValue(v.Field(i), path+"."+key, resolver, errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			resolveValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), resolver, errs)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values are not addressable, so a copy is resolved and set again
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			resolveValue(value, fmt.Sprintf("%s.%v", path, iter.Key().Interface()), resolver, errs)
+			v.SetMapIndex(iter.Key(), value)
+		}
+	case reflect.String:
+		if !secrets.HasReference(v.String()) {
+			return
+		}
+		value, err := resolver.Resolve(v.String())
+		if err != nil {
+			*errs = append(*errs, fmt.Sprintf("%s: %s", path, err.Error()))
+			return
+		}
+		v.SetString(value)
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/willie68/AutoRestIoT/secrets"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	resolver := secrets.NewResolver(&secrets.MapProvider{Data: map[string]interface{}{
+		"consul": map[string]interface{}{"token": "consul-token"},
+		"gelf":   map[string]interface{}{"host": "graylog"},
+		"fields": map[string]interface{}{"device": "deviceId"},
+	}})
+	c := defaultConfig
+	c.Registry.Token = "${secret:consul/token}"
+	c.Logging.Gelfurl = "${secret:gelf/host}.local"
+	c.Registry.Tags = []string{"a", "${secret:gelf/host}"}
+	c.Retention.Policies = map[string]RetentionPolicy{"sensors": {MaxCount: 10, DeviceField: "${secret:fields/device}"}}
+	c.Secrets.Providers = []SecretProvider{{Type: "vault", Token: "${secret:consul/token}"}}
+	if errs := resolveSecrets(&c, resolver); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if c.Registry.Token != "consul-token" || c.Logging.Gelfurl != "graylog.local" || c.Registry.Tags[1] != "graylog" {
+		t.Errorf("references not resolved: %s %s %v", c.Registry.Token, c.Logging.Gelfurl, c.Registry.Tags)
+	}
+	if policy := c.Retention.Policies["sensors"]; policy.DeviceField != "deviceId" || policy.MaxCount != 10 {
+		t.Errorf("map value not resolved: %+v", policy)
+	}
+	if c.Secrets.Providers[0].Token != "${secret:consul/token}" {
+		t.Error("secrets section resolved")
+	}
+	if r := Redact(c); r.Logging.Gelfurl != Redacted {
+		t.Error("resolved value not redacted")
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	c := defaultConfig
+	c.Registry.Token = "${secret:consul/token}"
+	c.Retention.Policies = map[string]RetentionPolicy{"sensors": {Field: "${secret:fields/time}"}}
+	errs := resolveSecrets(&c, secrets.NewResolver())
+	if len(errs) != 2 || !hasError(errs, "registry.token") || !hasError(errs, "retention.policies.sensors.field") {
+		t.Errorf("wrong errors: %v", errs)
+	}
+}
@@ -8,4 +8,7 @@ type Secret struct {
 		Username string `yaml:"username"`
 		Password string `yaml:"password"`
 	} `yaml:"mongodb"`
+	Consul struct {
+		Token string `yaml:"token"`
+	} `yaml:"consul"`
 }
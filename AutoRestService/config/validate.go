@@ -0,0 +1,256 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+	"github.com/willie68/AutoRestIoT/storage"
+)
+
+/*
+ValidationError all errors found in the config
+*/
+type ValidationError struct {
+	Errors []string
+}
+
+func (v *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(v.Errors, "; ")
+}
+
+func (v *ValidationError) add(format string, args ...interface{}) {
+	v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+/*
+Validate checking the whole config, returns a ValidationError with all errors found, or nil
+*/
+func Validate(c Config) error {
+	v := &ValidationError{Errors: make([]string, 0)}
+
+	validatePort(v, "port", c.Port, true)
+	validatePort(v, "sslport", c.Sslport, false)
+	if c.Sslport > 0 && c.Sslport == c.Port {
+		v.add("port and sslport are the same: %d", c.Port)
+	}
+	if c.SystemID == "" {
+		v.add("systemID not set")
+	}
+	validateURL(v, "serviceURL", c.ServiceURL, true)
+	validateURL(v, "registryURL", c.RegistryURL, false)
+	if c.OpenAPI.SwaggerUI {
+		validateURL(v, "openapi.assets", c.OpenAPI.Assets, true)
+	}
+	if c.SecretFile != "" {
+		if _, err := os.Stat(c.SecretFile); err != nil {
+			v.add("secretfile: %s", err.Error())
+		}
+	}
+
+	switch c.Registry.Check {
+	case "", "http", "ttl":
+	default:
+		v.add("registry.check: unknown check type %s", c.Registry.Check)
+	}
+	if c.Registry.Interval < 0 {
+		v.add("registry.interval must not be negative")
+	}
+	if c.Registry.DeregisterAfter < 0 {
+		v.add("registry.deregisterafter must not be negative")
+	}
+	if c.ConsulKV.Enabled && c.RegistryURL == "" {
+		v.add("consulkv enabled, but registryURL not set")
+	}
+
+	validateLevel(v, "logging.level", c.Logging.Level)
+	validateLevel(v, "logging.gelf-level", c.Logging.GelfLevel)
+	if c.Logging.Gelfurl != "" {
+		validatePort(v, "logging.gelf-port", c.Logging.Gelfport, true)
+	}
+	switch c.Logging.Gelfprotocol {
+	case "", "udp", "tcp", "tls":
+	default:
+		v.add("logging.gelf-protocol: unknown protocol %s", c.Logging.Gelfprotocol)
+	}
+	if c.Logging.Gelfbuffer < 0 {
+		v.add("logging.gelf-buffer must not be negative")
+	}
+	for i, sink := range c.Logging.Sinks {
+		name := fmt.Sprintf("logging.sinks[%d]", i)
+		switch sink.Type {
+		case logging.SinkStdout:
+		case logging.SinkFile:
+			if sink.Filename == "" {
+				v.add("%s: filename not set", name)
+			}
+		case logging.SinkSyslog:
+		default:
+			v.add("%s: unknown type %s", name, sink.Type)
+		}
+		validateLevel(v, name+".level", sink.Level)
+		switch sink.Format {
+		case "", logging.FormatText, logging.FormatJSON:
+		default:
+			v.add("%s: unknown format %s", name, sink.Format)
+		}
+	}
+
+	if c.HealthCheck.Period <= 0 {
+		v.add("healthcheck.period must be greater than 0")
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		v.add("tracing.samplerate must be between 0 and 1")
+	}
+	if c.Shutdown.Drain < 0 {
+		v.add("shutdown.drain must not be negative")
+	}
+	if c.Shutdown.Timeout <= 0 {
+		v.add("shutdown.timeout must be greater than 0")
+	}
+
+	if c.Reload.Watch && c.Reload.Interval <= 0 {
+		v.add("reload.interval must be greater than 0")
+	}
+	if c.Idempotency.Window < 0 || c.Idempotency.MaxBody < 0 || c.Idempotency.MaxSize < 0 {
+		v.add("idempotency.window, idempotency.maxbody and idempotency.maxsize must not be negative")
+	}
+	if c.Batch.MaxOperations < 0 {
+		v.add("batch.maxoperations must not be negative")
+	}
+	if c.Jobs.Workers <= 0 {
+		v.add("jobs.workers must be greater than 0")
+	}
+	if c.Jobs.Queue <= 0 {
+		v.add("jobs.queue must be greater than 0")
+	}
+	if c.Jobs.Retention < 0 {
+		v.add("jobs.retention must not be negative")
+	}
+	if c.Trash.Retention < 0 {
+		v.add("trash.retention must not be negative")
+	}
+	if c.Retention.Interval < 0 {
+		v.add("retention.interval must not be negative")
+	}
+	if c.Retention.Batch <= 0 {
+		v.add("retention.batch must be greater than 0")
+	}
+	for model, p := range c.Retention.Policies {
+		validateRetentionPolicy(v, fmt.Sprintf("retention.policies.%s", model), p)
+	}
+	validateModels(v, c.Models)
+	if c.Backup.Dir == "" {
+		v.add("backup.dir not set")
+	}
+	if c.Backup.Interval < 0 || c.Backup.Keep < 0 || c.Backup.MaxAge < 0 {
+		v.add("backup.interval, backup.keep and backup.maxage must not be negative")
+	}
+
+	for i, version := range c.APIVersions {
+		name := fmt.Sprintf("apiversions[%d]", i)
+		if version.Version == "" {
+			v.add("%s: version not set", name)
+		}
+		if _, err := ParseDate(version.Deprecation); err != nil {
+			v.add("%s.deprecation: %s", name, err.Error())
+		}
+		if _, err := ParseDate(version.Sunset); err != nil {
+			v.add("%s.sunset: %s", name, err.Error())
+		}
+	}
+
+	if len(v.Errors) > 0 {
+		return v
+	}
+	return nil
+}
+
+func validateRetentionPolicy(v *ValidationError, name string, p RetentionPolicy) {
+	if p.MaxAge < 0 || p.MaxCount < 0 {
+		v.add("%s: maxage and maxcount must not be negative", name)
+	}
+	if p.MaxCount > 0 && p.DeviceField == "" {
+		v.add("%s: maxcount needs the devicefield", name)
+	}
+	if p.MaxAge == 0 && p.MaxCount == 0 && p.TTLField == "" {
+		v.add("%s: no rule set", name)
+	}
+}
+
+func validateModels(v *ValidationError, models []Model) {
+	names := make(map[string]bool)
+	for i, m := range models {
+		name := fmt.Sprintf("models[%d]", i)
+		if !storage.ValidModel(m.Name) {
+			v.add("%s: invalid name %q", name, m.Name)
+		}
+		if names[m.Name] {
+			v.add("%s: model %s defined twice", name, m.Name)
+		}
+		names[m.Name] = true
+		for j, f := range m.Fields {
+			if f.Name == "" {
+				v.add("%s.fields[%d]: name not set", name, j)
+			}
+			if !storage.ValidType(f.Type) {
+				v.add("%s.fields[%d]: unknown type %q", name, j, f.Type)
+			}
+		}
+		if m.Retention != (RetentionPolicy{}) {
+			validateRetentionPolicy(v, name+".retention", m.Retention)
+		}
+	}
+}
+
+func validatePort(v *ValidationError, name string, port int, required bool) {
+	if port == 0 && !required {
+		return
+	}
+	if port <= 0 || port > 65535 {
+		v.add("%s: invalid port %d", name, port)
+	}
+}
+
+func validateURL(v *ValidationError, name string, value string, required bool) {
+	if value == "" {
+		if required {
+			v.add("%s not set", name)
+		}
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		v.add("%s: %s", name, err.Error())
+		return
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add("%s: not a http(s) url: %s", name, value)
+	}
+}
+
+func validateLevel(v *ValidationError, name string, level string) {
+	if _, err := logging.ParseLevel(level); err != nil {
+		v.add("%s: %s", name, err.Error())
+	}
+}
+
+/*
+ParseDate parsing a RFC 3339 time or a date like 2006-01-02, an empty value is the zero time
+*/
+func ParseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, fmt.Errorf("wrong date %s, use RFC 3339 or 2006-01-02", value)
+	}
+	return t, nil
+}
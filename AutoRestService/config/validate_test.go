@@ -0,0 +1,74 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+/*
+validationErrors validating the config, returns all errors
+*/
+func validationErrors(t *testing.T, c Config) []string {
+	t.Helper()
+	err := Validate(c)
+	if err == nil {
+		return nil
+	}
+	v, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("wrong error type: %T", err)
+	}
+	return v.Errors
+}
+
+func hasError(errs []string, prefix string) bool {
+	for _, e := range errs {
+		if strings.HasPrefix(e, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestValidateDefaults(t *testing.T) {
+	if errs := validationErrors(t, defaultConfig); len(errs) > 0 {
+		t.Errorf("default config not valid: %v", errs)
+	}
+}
+
+func TestValidateShutdown(t *testing.T) {
+	for _, timeout := range []int{0, -1} {
+		c := defaultConfig
+		c.Shutdown.Timeout = timeout
+		if errs := validationErrors(t, c); !hasError(errs, "shutdown.timeout") {
+			t.Errorf("timeout %d accepted", timeout)
+		}
+	}
+	c := defaultConfig
+	c.Shutdown.Drain = 0
+	if errs := validationErrors(t, c); len(errs) > 0 {
+		t.Errorf("shutdown without drain not valid: %v", errs)
+	}
+}
+
+func TestValidateModels(t *testing.T) {
+	c := defaultConfig
+	c.Models = []Model{
+		{Name: "devices", Fields: []ModelField{{Name: "name", Type: "string"}}},
+		{Name: "devices"},
+		{Name: "bad/name"},
+		{Name: "readings", Fields: []ModelField{{Type: "string"}, {Name: "value", Type: "float"}}},
+		{Name: "telemetry", Retention: RetentionPolicy{MaxAge: 3600, MaxCount: 10}},
+		{Name: "events", Retention: RetentionPolicy{TTLField: "expires"}},
+	}
+	errs := validationErrors(t, c)
+	for _, prefix := range []string{"models[1]: model devices defined twice", "models[2]: invalid name", "models[3].fields[0]: name not set",
+		"models[3].fields[1]: unknown type", "models[4].retention: maxcount needs the devicefield"} {
+		if !hasError(errs, prefix) {
+			t.Errorf("missing error %s: %v", prefix, errs)
+		}
+	}
+	if len(errs) != 5 {
+		t.Errorf("wrong errors: %v", errs)
+	}
+}
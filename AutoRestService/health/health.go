@@ -1,31 +1,25 @@
 package health
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/willie68/AutoRestIoT/api"
 	"github.com/willie68/AutoRestIoT/logging"
 )
 
-var myhealthy bool
-var log logging.ServiceLogger
+var log = logging.ServiceLogger{Package: "health"}
 
 /*
 This is the healtchcheck you will have to provide.
 */
 func check() (bool, string) {
 	// TODO implement here your healthcheck.
-	myhealthy = !myhealthy
-	message := ""
-	if myhealthy {
-		log.Info("healthy")
-	} else {
-		log.Info("not healthy")
-		message = "ungesund"
-	}
-	return myhealthy, message
+	return true, ""
 }
 
 //##### template internal functions for processing the healthchecks #####
@@ -34,6 +28,45 @@ var healthy bool
 var lastChecked time.Time
 var period int
 
+// Check a additional health check, returns the health state and a message if not healthy
+type Check func() (bool, string)
+
+type registeredCheck struct {
+	name     string
+	critical bool
+	check    Check
+}
+
+// CheckResult result of a single registered health check
+type CheckResult struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+var ready int32
+var stop chan struct{}
+
+// guards healthy, healthmessage, lastChecked, period and stop, they are used by the checks and the endpoints
+var stateMutex sync.Mutex
+
+var checksMutex sync.Mutex
+var checks []registeredCheck
+var checkResults map[string]CheckResult
+
+/*
+RegisterCheck registers a additional health check. A failing critical check will mark the whole service unhealthy,
+non critical checks are only reported.
+*/
+func RegisterCheck(name string, critical bool, check Check) {
+	checksMutex.Lock()
+	defer checksMutex.Unlock()
+	checks = append(checks, registeredCheck{
+		name:     name,
+		critical: critical,
+		check:    check,
+	})
+}
+
 // CheckConfig configuration for the healthcheck system
 type CheckConfig struct {
 	Period int
@@ -41,31 +74,101 @@ type CheckConfig struct {
 
 // InitHealthSystem initialise the complete health system
 func InitHealthSystem(config CheckConfig) {
+	stateMutex.Lock()
 	period = config.Period
-	log.Infof("healthcheck starting with period: %d seconds", period)
 	healthmessage = "service starting"
 	healthy = false
+	stateMutex.Unlock()
+	log.Infof("healthcheck starting with period: %d seconds", config.Period)
 	doCheck()
-	go func() {
+	startChecks(config.Period)
+}
+
+// SetPeriod changing the period of the background health checks at runtime
+func SetPeriod(p int) {
+	stateMutex.Lock()
+	if p <= 0 || p == period {
+		stateMutex.Unlock()
+		return
+	}
+	period = p
+	stateMutex.Unlock()
+	Stop()
+	log.Infof("healthcheck period changed to %d seconds", p)
+	startChecks(p)
+}
+
+func startChecks(period int) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	stop = make(chan struct{})
+	go func(stop chan struct{}) {
 		background := time.NewTicker(time.Second * time.Duration(period))
-		for _ = range background.C {
-			doCheck()
+		defer background.Stop()
+		for {
+			select {
+			case <-background.C:
+				doCheck()
+			case <-stop:
+				return
+			}
 		}
-	}()
+	}(stop)
+}
+
+// Healthy returns the result of the last health check
+func Healthy() (bool, string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return healthy, healthmessage
+}
+
+// Stop stopping the background health checks
+func Stop() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+}
+
+// SetReady switching the readiness of this service, on shutdown the readiness is switched off first
+func SetReady(r bool) {
+	if r {
+		atomic.StoreInt32(&ready, 1)
+	} else {
+		atomic.StoreInt32(&ready, 0)
+	}
 }
 
 /*
 internal function to process the health check
 */
 func doCheck() {
-	var msg string
-	healthy, msg = check()
-	if !healthy {
-		healthmessage = msg
-	} else {
-		healthmessage = ""
+	// the checks are serialized, after a period change the old ticker may still be running
+	checksMutex.Lock()
+	ok, msg := check()
+	if ok {
+		msg = ""
+	}
+	results := make(map[string]CheckResult, len(checks))
+	for _, c := range checks {
+		cok, cmsg := c.check()
+		results[c.name] = CheckResult{Healthy: cok, Message: cmsg}
+		if !cok && c.critical && ok {
+			ok = false
+			msg = c.name + ": " + cmsg
+		}
 	}
+	checkResults = results
+	checksMutex.Unlock()
+
+	stateMutex.Lock()
+	healthy = ok
+	healthmessage = msg
 	lastChecked = time.Now()
+	stateMutex.Unlock()
 }
 
 /*
@@ -82,27 +185,48 @@ func Routes() *chi.Mux {
 GetHealthyEndpoint is this service healthy
 */
 func GetHealthyEndpoint(response http.ResponseWriter, req *http.Request) {
-	t := time.Now()
-	if t.Sub(lastChecked) > (time.Second * time.Duration(2*period)) {
-		healthy = false
-		healthmessage = "Healthcheck not running"
+	stateMutex.Lock()
+	ok, msg, checked := healthy, healthmessage, lastChecked
+	if time.Since(checked) > (time.Second * time.Duration(2*period)) {
+		ok = false
+		msg = "Healthcheck not running"
 	}
-	response.Header().Add("Content-Type", "application/json")
-	if healthy {
-		response.WriteHeader(http.StatusOK)
-		message := fmt.Sprintf(`{ "message": "service up and running", "lastCheck": "%s" }`, lastChecked.String())
-		response.Write([]byte(message))
-	} else {
-		response.WriteHeader(http.StatusServiceUnavailable)
-		message := fmt.Sprintf(`{ "message": "service is unavailable: %s", "lastCheck": "%s" }`, healthmessage, lastChecked.String())
-		response.Write([]byte(message))
+	stateMutex.Unlock()
+	checksMutex.Lock()
+	results := checkResults
+	checksMutex.Unlock()
+
+	if !ok {
+		api.Error(response, req, api.CodeUnavailable, "service is unavailable: "+msg)
+		return
 	}
+	message := struct {
+		Message   string                 `json:"message"`
+		LastCheck string                 `json:"lastCheck"`
+		Checks    map[string]CheckResult `json:"checks,omitempty"`
+	}{
+		Message:   "service up and running",
+		LastCheck: checked.String(),
+		Checks:    results,
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		api.Error(response, req, api.CodeInternal, err.Error())
+		return
+	}
+	response.Header().Add("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	response.Write(data)
 }
 
 /*
 GetReadinessEndpoint is this service ready for taking requests
 */
 func GetReadinessEndpoint(response http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&ready) == 0 {
+		api.Error(response, req, api.CodeUnavailable, "service not ready")
+		return
+	}
 	response.Header().Add("Content-Type", "application/json")
 	response.WriteHeader(http.StatusOK)
 	response.Write([]byte(`{ "message": "service started" }`))
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/api"
+)
+
+func registerTestCheck(t *testing.T, name string, critical bool, check Check) {
+	RegisterCheck(name, critical, check)
+	t.Cleanup(func() {
+		checksMutex.Lock()
+		checks = nil
+		checkResults = nil
+		checksMutex.Unlock()
+	})
+}
+
+func get(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func problem(t *testing.T, rec *httptest.ResponseRecorder) api.Problem {
+	t.Helper()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d, want 503", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != api.ProblemContentType {
+		t.Errorf("content type %s", ct)
+	}
+	var p api.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Code != api.CodeUnavailable || p.Status != http.StatusServiceUnavailable {
+		t.Errorf("wrong problem: %+v", p)
+	}
+	return p
+}
+
+func TestConcurrentChecks(t *testing.T) {
+	InitHealthSystem(CheckConfig{Period: 1})
+	defer Stop()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			doCheck()
+		}()
+		go func() {
+			defer wg.Done()
+			Healthy()
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				SetPeriod(1 + i%2)
+			}
+			GetHealthyEndpoint(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health/health", nil))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStableHealth(t *testing.T) {
+	// the registry ttl is following the health state, so it must not change without a reason
+	for i := 0; i < 3; i++ {
+		doCheck()
+		if ok, msg := Healthy(); !ok {
+			t.Fatalf("check %d not healthy: %s", i, msg)
+		}
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	InitHealthSystem(CheckConfig{Period: 60})
+	defer Stop()
+	var mu sync.Mutex
+	dbUp := true
+	registerTestCheck(t, "database", true, func() (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if dbUp {
+			return true, ""
+		}
+		return false, "no connection"
+	})
+	registerTestCheck(t, "graylog", false, func() (bool, string) { return false, "not reachable" })
+	doCheck()
+	rec := get(GetHealthyEndpoint, "/health/health")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d with a failing non critical check", rec.Code)
+	}
+	var body struct {
+		Checks map[string]CheckResult `json:"checks"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if len(body.Checks) != 2 || body.Checks["graylog"].Healthy || body.Checks["graylog"].Message != "not reachable" {
+		t.Errorf("checks not reported: %+v", body.Checks)
+	}
+
+	mu.Lock()
+	dbUp = false
+	mu.Unlock()
+	doCheck()
+	p := problem(t, get(GetHealthyEndpoint, "/health/health"))
+	if p.Detail != "service is unavailable: database: no connection" || p.Instance != "/health/health" {
+		t.Errorf("wrong problem: %+v", p)
+	}
+}
+
+func TestHealthNotRunning(t *testing.T) {
+	InitHealthSystem(CheckConfig{Period: 60})
+	Stop()
+	stateMutex.Lock()
+	lastChecked = lastChecked.Add(-121 * time.Second)
+	stateMutex.Unlock()
+	if p := problem(t, get(GetHealthyEndpoint, "/health/health")); p.Detail != "service is unavailable: Healthcheck not running" {
+		t.Errorf("wrong detail: %s", p.Detail)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	defer SetReady(false)
+	SetReady(false)
+	if p := problem(t, get(GetReadinessEndpoint, "/health/readiness")); p.Detail != "service not ready" {
+		t.Errorf("wrong detail: %s", p.Detail)
+	}
+	SetReady(true)
+	if rec := get(GetReadinessEndpoint, "/health/readiness"); rec.Code != http.StatusOK {
+		t.Errorf("status %d when ready", rec.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// EncryptedHeader first line of an encrypted file, followed by the base64 encoded nonce and cipher text
+const EncryptedHeader = "autorest:aes-256-gcm:v1"
+
+/*
+IsEncrypted checks if the data is encrypted with Encrypt
+*/
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(EncryptedHeader))
+}
+
+/*
+Encrypt encrypting data with AES-256-GCM, the result is text with a header line and the base64 encoded nonce and cipher text
+*/
+func Encrypt(data []byte, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("can't create nonce: %s", err.Error())
+	}
+	sealed := gcm.Seal(nonce, nonce, data, []byte(EncryptedHeader))
+	var b bytes.Buffer
+	b.WriteString(EncryptedHeader)
+	b.WriteString("\n")
+	encoded := base64.StdEncoding.EncodeToString(sealed)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76])
+		b.WriteString("\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded)
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+/*
+Decrypt decrypting data encrypted with Encrypt
+*/
+func Decrypt(data []byte, key []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	encoded := strings.Join(strings.Fields(string(data[len(EncryptedHeader):])), "")
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode encrypted data: %s", err.Error())
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted data too short")
+	}
+	nonce, cipherText := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, cipherText, []byte(EncryptedHeader))
+	if err != nil {
+		return nil, errors.New("can't decrypt data, wrong key or modified data")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("wrong key size %d, the key must have 32 bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+LoadKey loading the 32 byte key from the environment variable or, if not set, from the key file.
+The key can be given raw, hex or base64 encoded.
+*/
+func LoadKey(envName string, keyFile string) ([]byte, error) {
+	value, ok := os.LookupEnv(envName)
+	if !ok || value == "" {
+		if keyFile == "" {
+			return nil, fmt.Errorf("no key given, set %s or a key file", envName)
+		}
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read key file: %s", err.Error())
+		}
+		if len(data) == 32 {
+			return data, nil
+		}
+		value = string(data)
+	}
+	return ParseKey(value)
+}
+
+/*
+ParseKey parsing a hex or base64 encoded 32 byte key
+*/
+func ParseKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(value) == 32 {
+		return []byte(value), nil
+	}
+	return nil, errors.New("the key must be 32 bytes, raw, hex or base64 encoded")
+}
@@ -0,0 +1,84 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func testKey() []byte {
+	return bytes.Repeat([]byte{7}, 32)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	plain := bytes.Repeat([]byte("mongodb:\n    password: geheim\n"), 10)
+	data, err := Encrypt(plain, testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, []byte("geheim")) {
+		t.Fatal("data not encrypted")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) > 76 {
+			t.Errorf("line too long: %d", len(line))
+		}
+	}
+	decrypted, err := Decrypt(data, testKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Error("wrong plain text")
+	}
+	// the nonce is random
+	if other, _ := Encrypt(plain, testKey()); bytes.Equal(other, data) {
+		t.Error("same cipher text twice")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	data, _ := Encrypt([]byte("secret"), testKey())
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	if _, err := Decrypt(data, wrongKey); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	modified := append([]byte{}, data...)
+	modified[len(EncryptedHeader)+5] ^= 1
+	if _, err := Decrypt(modified, testKey()); err == nil {
+		t.Error("modified data decrypted")
+	}
+	if _, err := Decrypt([]byte("plain"), testKey()); err == nil {
+		t.Error("plain data decrypted")
+	}
+	if _, err := Encrypt([]byte("secret"), []byte("short")); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := testKey()
+	t.Setenv("TEST_KEY", hex.EncodeToString(key))
+	if k, err := LoadKey("TEST_KEY", ""); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("hex key from env: %v", err)
+	}
+	t.Setenv("TEST_KEY", "")
+	file := filepath.Join(t.TempDir(), "key")
+	ioutil.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+	if k, err := LoadKey("TEST_KEY", file); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("base64 key from file: %v", err)
+	}
+	ioutil.WriteFile(file, key, 0600)
+	if k, err := LoadKey("TEST_KEY", file); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("raw key from file: %v", err)
+	}
+	if _, err := LoadKey("TEST_KEY", ""); err == nil {
+		t.Error("missing key accepted")
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Error("short key accepted")
+	}
+}
@@ -0,0 +1,521 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+var log = logging.ServiceLogger{Package: "jobs"}
+
+/*
+Status the state of a job
+*/
+type Status string
+
+// all states of a job
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+/*
+Finished checks if the job has ended
+*/
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
+/*
+Config configuration of the job system
+*/
+type Config struct {
+	Workers   int
+	Queue     int
+	Storage   string
+	Retention int
+}
+
+/*
+Job a long running operation, executed in the background
+*/
+type Job struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Status   Status            `json:"status"`
+	Progress int               `json:"progress"`
+	Message  string            `json:"message,omitempty"`
+	Params   json.RawMessage   `json:"params,omitempty"`
+	Result   json.RawMessage   `json:"result,omitempty"`
+	Links    map[string]string `json:"links,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Created  time.Time         `json:"created"`
+	Started  *time.Time        `json:"started,omitempty"`
+	Finished *time.Time        `json:"finished,omitempty"`
+}
+
+/*
+Runner executing a job of a type, the result is stored as json. The runner has to stop, if the context of
+the task is done.
+*/
+type Runner func(task *Task, params json.RawMessage) (interface{}, error)
+
+/*
+Task the running job, for reporting the progress
+*/
+type Task struct {
+	context.Context
+	id           string
+	tenant       string
+	lastProgress time.Time
+}
+
+/*
+ID the id of the job
+*/
+func (t *Task) ID() string {
+	return t.id
+}
+
+/*
+Tenant the tenant of the job
+*/
+func (t *Task) Tenant() string {
+	return t.tenant
+}
+
+/*
+File the path of a file of the job, like the upload of SubmitWithFile or the result of an export
+*/
+func (t *Task) File(name string) string {
+	return FilePath(t.id, name)
+}
+
+/*
+CreateFile creating a file of the job, like the result of an export. The file is removed together with the job.
+*/
+func (t *Task) CreateFile(name string) (*os.File, error) {
+	return createFile(t.id, name)
+}
+
+/*
+SetProgress reporting the progress in percent with a message
+*/
+func (t *Task) SetProgress(progress int, message string) {
+	// stored at most once a second, the time is only taken from a stored progress
+	store := time.Since(t.lastProgress) > time.Second
+	update(t.id, func(job *Job) {
+		job.Progress = progress
+		job.Message = message
+	}, store)
+	if store {
+		t.lastProgress = time.Now()
+	}
+}
+
+/*
+AddLink adding a link to a result of the job, like the download of an export
+*/
+func (t *Task) AddLink(rel string, href string) {
+	update(t.id, func(job *Job) {
+		if job.Links == nil {
+			job.Links = make(map[string]string)
+		}
+		job.Links[rel] = href
+	}, true)
+}
+
+type entry struct {
+	job    Job
+	cancel context.CancelFunc
+}
+
+var (
+	mu      sync.Mutex
+	jobs    = make(map[string]*entry)
+	runners = make(map[string]Runner)
+	queue   chan string
+	cfg     Config
+	stop    chan struct{}
+	wg      sync.WaitGroup
+)
+
+/*
+Register registering the runner of a job type, must be called before Start
+*/
+func Register(jobType string, runner Runner) {
+	mu.Lock()
+	defer mu.Unlock()
+	runners[jobType] = runner
+}
+
+/*
+Start loading the stored jobs and starting the workers. Jobs interrupted by a restart are queued again.
+*/
+func Start(config Config) error {
+	cfg = config
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = 1000
+	}
+	loaded, err := load()
+	if err != nil {
+		return err
+	}
+	queue = make(chan string, cfg.Queue+len(loaded))
+	stop = make(chan struct{})
+
+	sort.Slice(loaded, func(i, j int) bool {
+		return loaded[i].Created.Before(loaded[j].Created)
+	})
+	mu.Lock()
+	jobs = make(map[string]*entry, len(loaded))
+	for _, job := range loaded {
+		jobs[job.ID] = &entry{job: job}
+		if !job.Status.Finished() {
+			log.Infof("job %s (%s) queued again after restart", job.ID, job.Type)
+			requeue(&jobs[job.ID].job)
+			queue <- job.ID
+		}
+	}
+	mu.Unlock()
+
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	wg.Add(1)
+	go sweep()
+	return nil
+}
+
+/*
+Stop cancelling the running jobs and waiting for the workers. The running jobs are stored as queued,
+so they are started again after a restart.
+*/
+func Stop(ctx context.Context) {
+	if stop == nil {
+		return
+	}
+	close(stop)
+	mu.Lock()
+	for _, e := range jobs {
+		if e.job.Status == StatusRunning && e.cancel != nil {
+			e.cancel()
+		}
+	}
+	mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Alert("jobs not stopped in time")
+	}
+}
+
+/*
+Submit queueing a new job of the type, the params are stored as json
+*/
+func Submit(jobType string, tenant string, params interface{}) (Job, error) {
+	return SubmitWithFile(jobType, tenant, params, "", nil)
+}
+
+/*
+SubmitWithFile queueing a new job with a file, like the upload of an import. The file is stored before the job
+is queued, the runner gets it with task.File(name). The files of a job are removed together with the job.
+*/
+func SubmitWithFile(jobType string, tenant string, params interface{}, name string, content io.Reader) (Job, error) {
+	mu.Lock()
+	_, ok := runners[jobType]
+	mu.Unlock()
+	if !ok {
+		return Job{}, fmt.Errorf("unknown job type: %s", jobType)
+	}
+	if queue == nil {
+		return Job{}, fmt.Errorf("job system not started")
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, fmt.Errorf("can't convert params: %s", err.Error())
+	}
+	job := Job{
+		ID:      newID(),
+		Type:    jobType,
+		Tenant:  tenant,
+		Status:  StatusQueued,
+		Params:  data,
+		Created: time.Now().UTC(),
+	}
+	if content != nil {
+		if err := writeFile(job.ID, name, content); err != nil {
+			return Job{}, err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case queue <- job.ID:
+	default:
+		removeFiles(job.ID)
+		return Job{}, ErrQueueFull
+	}
+	jobs[job.ID] = &entry{job: job}
+	persist(job)
+	return job, nil
+}
+
+// ErrQueueFull all workers are busy and the queue is full
+var ErrQueueFull = fmt.Errorf("job queue is full")
+
+// ErrNotFound no job with this id
+var ErrNotFound = fmt.Errorf("job not found")
+
+/*
+Get getting a job by its id
+*/
+func Get(id string) (Job, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+/*
+Wait waiting until the job is finished, polling its state. If the context is done first, the job is cancelled.
+*/
+func Wait(ctx context.Context, id string) (Job, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		job, ok := Get(id)
+		if !ok {
+			return Job{}, ErrNotFound
+		}
+		if job.Status.Finished() {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			Cancel(id)
+			return job, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+/*
+List all jobs of the tenant, newest first
+*/
+func List(tenant string) []Job {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]Job, 0)
+	for _, e := range jobs {
+		if e.job.Tenant == tenant {
+			list = append(list, e.job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list
+}
+
+/*
+Cancel cancelling a queued or running job, finished jobs are not changed
+*/
+func Cancel(id string) (Job, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	switch e.job.Status {
+	case StatusQueued:
+		finish(&e.job, StatusCancelled, nil, "cancelled")
+		persist(e.job)
+	case StatusRunning:
+		// the worker sets the state, when the runner returns
+		if e.cancel != nil {
+			e.cancel()
+		}
+	}
+	return e.job, nil
+}
+
+func worker() {
+	defer wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case id := <-queue:
+			run(id)
+		}
+	}
+}
+
+func run(id string) {
+	mu.Lock()
+	e, ok := jobs[id]
+	if !ok || e.job.Status != StatusQueued {
+		// cancelled while queued
+		mu.Unlock()
+		return
+	}
+	runner, ok := runners[e.job.Type]
+	if !ok {
+		finish(&e.job, StatusFailed, nil, fmt.Sprintf("unknown job type: %s", e.job.Type))
+		persist(e.job)
+		mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	now := time.Now().UTC()
+	e.job.Status = StatusRunning
+	e.job.Started = &now
+	params := e.job.Params
+	tenant := e.job.Tenant
+	persist(e.job)
+	mu.Unlock()
+
+	result, err := execute(&Task{Context: ctx, id: id, tenant: tenant}, runner, params)
+	cancelled := ctx.Err() != nil
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	e.cancel = nil
+	select {
+	case <-stop:
+		if cancelled {
+			// stopped by the shutdown, started again after the restart
+			requeue(&e.job)
+			persist(e.job)
+			return
+		}
+	default:
+	}
+	switch {
+	case cancelled:
+		finish(&e.job, StatusCancelled, nil, "cancelled")
+	case err != nil:
+		log.Alertf("job %s (%s) failed: %s", id, e.job.Type, err.Error())
+		finish(&e.job, StatusFailed, nil, err.Error())
+	default:
+		data, merr := json.Marshal(result)
+		if merr != nil {
+			finish(&e.job, StatusFailed, nil, fmt.Sprintf("can't convert result: %s", merr.Error()))
+			break
+		}
+		e.job.Progress = 100
+		finish(&e.job, StatusSucceeded, data, "")
+	}
+	persist(e.job)
+}
+
+/*
+execute running the runner, a panic fails the job
+*/
+func execute(task *Task, runner Runner, params json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			err = fmt.Errorf("panic: %v", rvr)
+		}
+	}()
+	return runner(task, params)
+}
+
+func requeue(job *Job) {
+	job.Status = StatusQueued
+	job.Started = nil
+	job.Progress = 0
+	job.Message = ""
+}
+
+func finish(job *Job, status Status, result json.RawMessage, message string) {
+	now := time.Now().UTC()
+	job.Status = status
+	job.Finished = &now
+	job.Result = result
+	if status == StatusSucceeded {
+		job.Message = message
+	} else {
+		job.Error = message
+	}
+}
+
+/*
+update changing a job, the change is only stored if store is true
+*/
+func update(id string, change func(job *Job), store bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	e, ok := jobs[id]
+	if !ok {
+		return
+	}
+	change(&e.job)
+	if store {
+		persist(e.job)
+	}
+}
+
+/*
+sweep removing finished jobs after the retention time
+*/
+func sweep() {
+	defer wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if cfg.Retention <= 0 {
+			continue
+		}
+		limit := time.Now().Add(-time.Duration(cfg.Retention) * time.Second)
+		mu.Lock()
+		for id, e := range jobs {
+			if e.job.Status.Finished() && e.job.Finished != nil && e.job.Finished.Before(limit) {
+				delete(jobs, id)
+				remove(id)
+				removeFiles(id)
+			}
+		}
+		mu.Unlock()
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
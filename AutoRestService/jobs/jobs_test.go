@@ -0,0 +1,196 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startTest(t *testing.T, dir string) {
+	t.Helper()
+	if err := Start(Config{Workers: 1, Storage: dir}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stopTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Stop(ctx)
+}
+
+/*
+waitFor waiting until the job has the status
+*/
+func waitFor(t *testing.T, id string, status Status) Job {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if job, ok := Get(id); ok && job.Status == status {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	job, _ := Get(id)
+	t.Fatalf("job %s is %s, not %s", id, job.Status, status)
+	return job
+}
+
+/*
+storedJob reading the stored state of a job
+*/
+func storedJob(t *testing.T, dir string, id string) Job {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+/*
+blocking a runner waiting until the task is cancelled
+*/
+func blocking(task *Task, params json.RawMessage) (interface{}, error) {
+	<-task.Done()
+	return nil, task.Err()
+}
+
+func TestRestartRecovery(t *testing.T) {
+	var runs int32
+	Register("restart", func(task *Task, params json.RawMessage) (interface{}, error) {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			return blocking(task, params)
+		}
+		return "done", nil
+	})
+	dir := t.TempDir()
+	startTest(t, dir)
+	job, err := Submit("restart", "tenant", map[string]int{"value": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, job.ID, StatusRunning)
+	stopTest()
+	// the job interrupted by the shutdown is stored as queued
+	if stored := storedJob(t, dir, job.ID); stored.Status != StatusQueued || stored.Started != nil {
+		t.Fatalf("wrong stored job: %+v", stored)
+	}
+
+	startTest(t, dir)
+	defer stopTest()
+	restarted := waitFor(t, job.ID, StatusSucceeded)
+	if string(restarted.Result) != `"done"` || string(restarted.Params) != `{"value":1}` || restarted.Progress != 100 {
+		t.Errorf("wrong job after restart: %+v", restarted)
+	}
+	if runs != 2 {
+		t.Errorf("runner called %d times", runs)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	Register("blocking", blocking)
+	dir := t.TempDir()
+	startTest(t, dir)
+	defer stopTest()
+	running, _ := Submit("blocking", "tenant", nil)
+	queued, _ := Submit("blocking", "tenant", nil)
+	waitFor(t, running.ID, StatusRunning)
+
+	// a queued job is cancelled at once and never started
+	job, err := Cancel(queued.ID)
+	if err != nil || job.Status != StatusCancelled || job.Finished == nil {
+		t.Errorf("queued job not cancelled: %+v", job)
+	}
+	// a running job is cancelled by its context
+	if _, err := Cancel(running.ID); err != nil {
+		t.Fatal(err)
+	}
+	job = waitFor(t, running.ID, StatusCancelled)
+	if job.Error != "cancelled" || storedJob(t, dir, running.ID).Status != StatusCancelled {
+		t.Errorf("wrong cancelled job: %+v", job)
+	}
+	if job, _ := Get(queued.ID); job.Started != nil {
+		t.Errorf("cancelled job started: %+v", job)
+	}
+	if _, err := Cancel("unknown"); err != ErrNotFound {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestFailingJobs(t *testing.T) {
+	Register("panic", func(task *Task, params json.RawMessage) (interface{}, error) {
+		panic("broken")
+	})
+	startTest(t, "")
+	defer stopTest()
+	job, _ := Submit("panic", "tenant", nil)
+	if job := waitFor(t, job.ID, StatusFailed); !strings.Contains(job.Error, "broken") {
+		t.Errorf("wrong error: %s", job.Error)
+	}
+	if _, err := Submit("unknown", "tenant", nil); err == nil {
+		t.Error("unknown type submitted")
+	}
+}
+
+func TestSubmitWithFile(t *testing.T) {
+	Register("file", func(task *Task, params json.RawMessage) (interface{}, error) {
+		data, err := ioutil.ReadFile(task.File("upload"))
+		if err != nil {
+			return nil, err
+		}
+		task.AddLink("download", "/download")
+		return string(data), nil
+	})
+	startTest(t, t.TempDir())
+	defer stopTest()
+	job, err := SubmitWithFile("file", "tenant", nil, "upload", strings.NewReader("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitFor(t, job.ID, StatusSucceeded)
+	if string(job.Result) != `"content"` || job.Links["download"] != "/download" {
+		t.Errorf("wrong job: %+v", job)
+	}
+	removeFiles(job.ID)
+	if _, err := ioutil.ReadFile(FilePath(job.ID, "upload")); err == nil {
+		t.Error("file not removed")
+	}
+}
+
+func TestSetProgress(t *testing.T) {
+	dir := t.TempDir()
+	cfg = Config{Storage: dir}
+	mu.Lock()
+	jobs = map[string]*entry{"progress": {job: Job{ID: "progress", Status: StatusRunning}}}
+	mu.Unlock()
+	task := &Task{id: "progress"}
+
+	task.SetProgress(10, "first")
+	stored := task.lastProgress
+	// too early for storing, the time of the stored progress is kept
+	task.SetProgress(20, "second")
+	if task.lastProgress != stored {
+		t.Error("time changed without storing")
+	}
+	if job := storedJob(t, dir, "progress"); job.Progress != 10 {
+		t.Errorf("stored progress %d", job.Progress)
+	}
+	if job, _ := Get("progress"); job.Progress != 20 || job.Message != "second" {
+		t.Errorf("progress %d: %s", job.Progress, job.Message)
+	}
+
+	task.lastProgress = time.Now().Add(-2 * time.Second)
+	task.SetProgress(30, "third")
+	if job := storedJob(t, dir, "progress"); job.Progress != 30 {
+		t.Errorf("stored progress %d", job.Progress)
+	}
+}
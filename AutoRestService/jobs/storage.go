@@ -0,0 +1,135 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+load reading all stored jobs, without storage directory the jobs are only kept in memory
+*/
+func load() ([]Job, error) {
+	if cfg.Storage == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Storage, 0700); err != nil {
+		return nil, fmt.Errorf("can't create job storage: %s", err.Error())
+	}
+	files, err := ioutil.ReadDir(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("can't read job storage: %s", err.Error())
+	}
+	list := make([]Job, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cfg.Storage, file.Name()))
+		if err != nil {
+			log.Alertf("can't read job %s: %s", file.Name(), err.Error())
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Alertf("can't parse job %s: %s", file.Name(), err.Error())
+			continue
+		}
+		list = append(list, job)
+	}
+	return list, nil
+}
+
+/*
+persist writing the job to the storage, the file is replaced atomically
+*/
+func persist(job Job) {
+	if cfg.Storage == "" {
+		return
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		log.Alertf("can't convert job %s: %s", job.ID, err.Error())
+		return
+	}
+	file := filepath.Join(cfg.Storage, job.ID+".json")
+	if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		log.Alertf("can't store job %s: %s", job.ID, err.Error())
+		return
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		log.Alertf("can't store job %s: %s", job.ID, err.Error())
+	}
+}
+
+func remove(id string) {
+	if cfg.Storage == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(cfg.Storage, id+".json")); err != nil && !os.IsNotExist(err) {
+		log.Alertf("can't remove job %s: %s", id, err.Error())
+	}
+}
+
+/*
+filesDir the directory of the job files, beside the stored jobs or in the temp directory
+*/
+func filesDir() string {
+	if cfg.Storage == "" {
+		return filepath.Join(os.TempDir(), "autorest-jobs")
+	}
+	return filepath.Join(cfg.Storage, "files")
+}
+
+/*
+FilePath the path of a file of a job
+*/
+func FilePath(id string, name string) string {
+	return filepath.Join(filesDir(), id+"-"+filepath.Base(name))
+}
+
+/*
+createFile creating a file of the job, an existing file is truncated
+*/
+func createFile(id string, name string) (*os.File, error) {
+	if err := os.MkdirAll(filesDir(), 0700); err != nil {
+		return nil, fmt.Errorf("can't create job files: %s", err.Error())
+	}
+	file, err := os.OpenFile(FilePath(id, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("can't create job file: %s", err.Error())
+	}
+	return file, nil
+}
+
+func writeFile(id string, name string, data io.Reader) error {
+	file, err := createFile(id, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(FilePath(id, name))
+		return fmt.Errorf("can't write job file: %s", err.Error())
+	}
+	return nil
+}
+
+/*
+removeFiles removing all files of the job
+*/
+func removeFiles(id string) {
+	files, _ := filepath.Glob(filepath.Join(filesDir(), id+"-*"))
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Alertf("can't remove file of job %s: %s", id, err.Error())
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scopes for level overrides, matching the attributes of a message
+const (
+	ScopeTenant  = "tenant"
+	ScopeDevice  = "device"
+	ScopePackage = "package"
+)
+
+/*
+LevelOverride a log level for all messages of a single tenant, device or package
+*/
+type LevelOverride struct {
+	Scope   string     `json:"scope"`
+	Value   string     `json:"value"`
+	Level   string     `json:"level"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+/*
+LevelState the actual state of the runtime log level control
+*/
+type LevelState struct {
+	Level      string          `json:"level"`
+	Configured string          `json:"configured"`
+	Expires    *time.Time      `json:"expires,omitempty"`
+	Overrides  []LevelOverride `json:"overrides"`
+}
+
+type override struct {
+	level   Level
+	expires time.Time
+	timer   *time.Timer
+}
+
+var levelMutex sync.RWMutex
+var globalLevel = DebugLevel
+var configuredLevel = DebugLevel
+var globalExpires time.Time
+var globalTimer *time.Timer
+var overrides = make(map[string]*override)
+
+// the global level before ToggleDebug switched to debug
+var toggledFrom *Level
+
+/*
+ConfigureLevel sets the configured global log level, which is the level to revert to after a temporary change
+*/
+func ConfigureLevel(level Level) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	configuredLevel = level
+	if globalTimer == nil {
+		globalLevel = level
+	}
+}
+
+/*
+SetLevel changes the global log level. With a timeout > 0 the level will be reverted to the configured level after the timeout.
+*/
+func SetLevel(level Level, timeout time.Duration) {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	setLevel(level, timeout)
+	toggledFrom = nil
+}
+
+/*
+setLevel changes the global log level, the caller must hold the level mutex
+*/
+func setLevel(level Level, timeout time.Duration) {
+	if globalTimer != nil {
+		globalTimer.Stop()
+		globalTimer = nil
+	}
+	globalLevel = level
+	globalExpires = time.Time{}
+	if timeout > 0 {
+		globalExpires = time.Now().Add(timeout)
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() {
+			levelMutex.Lock()
+			defer levelMutex.Unlock()
+			if globalTimer == timer {
+				globalLevel = configuredLevel
+				globalExpires = time.Time{}
+				globalTimer = nil
+			}
+		})
+		globalTimer = timer
+	}
+}
+
+/*
+SetScopeLevel sets the log level for messages of a single tenant, device or package. With a timeout > 0 the override will be removed after the timeout.
+*/
+func SetScopeLevel(scope string, value string, level Level, timeout time.Duration) error {
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	key := scope + ":" + value
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	if o, ok := overrides[key]; ok && o.timer != nil {
+		o.timer.Stop()
+	}
+	o := &override{level: level}
+	if timeout > 0 {
+		o.expires = time.Now().Add(timeout)
+		o.timer = time.AfterFunc(timeout, func() {
+			levelMutex.Lock()
+			defer levelMutex.Unlock()
+			if overrides[key] == o {
+				delete(overrides, key)
+			}
+		})
+	}
+	overrides[key] = o
+	return nil
+}
+
+/*
+RemoveScopeLevel removes the log level override of a single tenant, device or package
+*/
+func RemoveScopeLevel(scope string, value string) error {
+	if err := checkScope(scope); err != nil {
+		return err
+	}
+	key := scope + ":" + value
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	if o, ok := overrides[key]; ok {
+		if o.timer != nil {
+			o.timer.Stop()
+		}
+		delete(overrides, key)
+	}
+	return nil
+}
+
+/*
+ResetLevels reverts the global level to the configured one and removes all overrides
+*/
+func ResetLevels() {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	if globalTimer != nil {
+		globalTimer.Stop()
+		globalTimer = nil
+	}
+	globalLevel = configuredLevel
+	globalExpires = time.Time{}
+	toggledFrom = nil
+	for key, o := range overrides {
+		if o.timer != nil {
+			o.timer.Stop()
+		}
+		delete(overrides, key)
+	}
+}
+
+/*
+ToggleDebug switches the global level to debug and back to the level before, the overrides are not changed
+*/
+func ToggleDebug() Level {
+	levelMutex.Lock()
+	defer levelMutex.Unlock()
+	if globalLevel != DebugLevel {
+		previous := globalLevel
+		setLevel(DebugLevel, 0)
+		toggledFrom = &previous
+		return DebugLevel
+	}
+	level := configuredLevel
+	if toggledFrom != nil {
+		level = *toggledFrom
+	}
+	setLevel(level, 0)
+	toggledFrom = nil
+	return level
+}
+
+/*
+Levels returns the actual state of the log levels
+*/
+func Levels() LevelState {
+	levelMutex.RLock()
+	defer levelMutex.RUnlock()
+	state := LevelState{
+		Level:      globalLevel.String(),
+		Configured: configuredLevel.String(),
+		Overrides:  make([]LevelOverride, 0, len(overrides)),
+	}
+	if !globalExpires.IsZero() {
+		expires := globalExpires
+		state.Expires = &expires
+	}
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		o := overrides[key]
+		scope, value := splitKey(key)
+		lo := LevelOverride{
+			Scope: scope,
+			Value: value,
+			Level: o.level.String(),
+		}
+		if !o.expires.IsZero() {
+			expires := o.expires
+			lo.Expires = &expires
+		}
+		state.Overrides = append(state.Overrides, lo)
+	}
+	return state
+}
+
+/*
+enabled checks if a message with the level and attributes should be logged.
+If overrides are matching the attributes, the most verbose of them is used, otherwise the global level.
+*/
+func enabled(level Level, attrs map[string]interface{}) bool {
+	levelMutex.RLock()
+	defer levelMutex.RUnlock()
+	threshold := globalLevel
+	if len(overrides) > 0 {
+		matched := false
+		for _, scope := range []string{ScopeTenant, ScopeDevice, ScopePackage} {
+			value, ok := attrs[scope]
+			if !ok {
+				continue
+			}
+			if o, ok := overrides[scope+":"+fmt.Sprintf("%v", value)]; ok {
+				if !matched || o.level < threshold {
+					threshold = o.level
+				}
+				matched = true
+			}
+		}
+	}
+	return level >= threshold
+}
+
+func checkScope(scope string) error {
+	switch scope {
+	case ScopeTenant, ScopeDevice, ScopePackage:
+		return nil
+	}
+	return fmt.Errorf("unknown log scope: %s", scope)
+}
+
+func splitKey(key string) (string, string) {
+	parts := strings.SplitN(key, ":", 2)
+	if len(parts) < 2 {
+		return key, ""
+	}
+	return parts[0], parts[1]
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestToggleDebugKeepsOverrides(t *testing.T) {
+	defer ResetLevels()
+	ConfigureLevel(AlertLevel)
+	SetLevel(InfoLevel, 0)
+	if err := SetScopeLevel(ScopeTenant, "t1", DebugLevel, 0); err != nil {
+		t.Fatal(err)
+	}
+	if level := ToggleDebug(); level != DebugLevel {
+		t.Fatalf("toggled to %s", level)
+	}
+	if level := ToggleDebug(); level != InfoLevel {
+		t.Errorf("toggled back to %s, want the previous level info", level)
+	}
+	state := Levels()
+	if len(state.Overrides) != 1 || state.Overrides[0].Value != "t1" {
+		t.Errorf("overrides changed: %+v", state.Overrides)
+	}
+	// without a previous toggle the configured level is used
+	SetLevel(DebugLevel, 0)
+	if level := ToggleDebug(); level != AlertLevel {
+		t.Errorf("toggled to %s, want the configured level alert", level)
+	}
+}
+
+func TestToggleDebugConcurrent(t *testing.T) {
+	defer ResetLevels()
+	ConfigureLevel(InfoLevel)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ToggleDebug()
+		}()
+	}
+	wg.Wait()
+	// an even number of toggles is ending on the start level
+	if state := Levels(); state.Level != InfoLevel.String() {
+		t.Errorf("level %s after an even number of toggles", state.Level)
+	}
+}
+
+func TestScopeLevels(t *testing.T) {
+	defer ResetLevels()
+	ConfigureLevel(AlertLevel)
+	SetScopeLevel(ScopeDevice, "d1", DebugLevel, 0)
+	if enabled(InfoLevel, map[string]interface{}{ScopeDevice: "d2"}) {
+		t.Error("info enabled for another device")
+	}
+	if !enabled(DebugLevel, map[string]interface{}{ScopeDevice: "d1"}) {
+		t.Error("debug not enabled for the device")
+	}
+	if err := SetScopeLevel("customer", "c1", DebugLevel, 0); err == nil {
+		t.Error("unknown scope accepted")
+	}
+	RemoveScopeLevel(ScopeDevice, "d1")
+	if enabled(DebugLevel, map[string]interface{}{ScopeDevice: "d1"}) {
+		t.Error("override not removed")
+	}
+}
+
+func TestLevelTimeout(t *testing.T) {
+	defer ResetLevels()
+	ConfigureLevel(AlertLevel)
+	SetLevel(DebugLevel, 20*time.Millisecond)
+	SetScopeLevel(ScopeTenant, "t1", DebugLevel, 20*time.Millisecond)
+	if state := Levels(); state.Expires == nil || state.Overrides[0].Expires == nil {
+		t.Error("expiry not reported")
+	}
+	time.Sleep(100 * time.Millisecond)
+	state := Levels()
+	if state.Level != AlertLevel.String() || len(state.Overrides) != 0 {
+		t.Errorf("changes not reverted: %+v", state)
+	}
+}
@@ -0,0 +1,414 @@
+package logging
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GELF transport protocols
+const (
+	GelfUDP = "udp"
+	GelfTCP = "tcp"
+	GelfTLS = "tls"
+)
+
+const (
+	defaultGelfPort       = 12201
+	defaultGelfBufferSize = 1000
+	gelfDialTimeout       = 5 * time.Second
+	gelfWriteTimeout      = 5 * time.Second
+	gelfFlushTimeout      = 5 * time.Second
+	gelfMinBackoff        = 1 * time.Second
+	gelfMaxBackoff        = 1 * time.Minute
+
+	// udp chunking as described in the GELF specification
+	gelfChunkSize   = 8192
+	gelfChunkHeader = 12
+	gelfMaxChunks   = 128
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+/*
+GelfStats some counters of the gelf client
+*/
+type GelfStats struct {
+	Sent      uint64 `json:"sent"`
+	Dropped   uint64 `json:"dropped"`
+	Failed    uint64 `json:"failed"`
+	Connected bool   `json:"connected"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+/*
+gelfClient sending GELF messages asynchronously to a graylog server.
+Messages are queued in a bounded buffer, so logging will never block. If the buffer is full, the message will be dropped.
+On connection errors the client reconnects with an exponential backoff.
+*/
+type gelfClient struct {
+	level     Level
+	protocol  string
+	address   string
+	tlsConfig *tls.Config
+	host      string
+
+	queue   chan []byte
+	closing chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	conn    net.Conn
+	backoff time.Duration
+
+	sent      uint64
+	dropped   uint64
+	failed    uint64
+	connected int32
+
+	mu        sync.Mutex
+	lastError string
+}
+
+/*
+newGelfClient creates a new gelf client and starts the background sender.
+*/
+func newGelfClient(level Level, protocol string, host string, port int, bufferSize int, tlsConfig *tls.Config) (*gelfClient, error) {
+	if protocol == "" {
+		protocol = GelfUDP
+	}
+	switch protocol {
+	case GelfUDP, GelfTCP, GelfTLS:
+	default:
+		return nil, fmt.Errorf("unsupported gelf protocol: %s", protocol)
+	}
+	if host == "" {
+		return nil, errors.New("gelf host not set")
+	}
+	if port <= 0 {
+		port = defaultGelfPort
+	}
+	if bufferSize <= 0 {
+		bufferSize = defaultGelfBufferSize
+	}
+	if protocol == GelfTLS && tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	g := &gelfClient{
+		level:     level,
+		protocol:  protocol,
+		address:   net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+		tlsConfig: tlsConfig,
+		host:      hostname,
+		queue:     make(chan []byte, bufferSize),
+		closing:   make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	// first connection attempt is done synchronously to report configuration errors early,
+	// a failing connection is no error, the sender will retry
+	if err := g.connect(); err != nil {
+		g.setError(err)
+	}
+	go g.run()
+	return g, nil
+}
+
+/*
+loadGelfTLSConfig creating the tls config for the gelf tls transport
+*/
+func loadGelfTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read gelf ca file: %s", err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in gelf ca file: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+func (g *gelfClient) minLevel() Level {
+	return g.level
+}
+
+/*
+write queues a message, never blocks
+*/
+func (g *gelfClient) write(e *entry) {
+	data, err := g.encode(e)
+	if err != nil {
+		atomic.AddUint64(&g.dropped, 1)
+		return
+	}
+	select {
+	case <-g.closing:
+		atomic.AddUint64(&g.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case g.queue <- data:
+	default:
+		atomic.AddUint64(&g.dropped, 1)
+	}
+}
+
+func (g *gelfClient) encode(e *entry) ([]byte, error) {
+	m := make(map[string]interface{}, len(e.attrs)+5)
+	for key, value := range e.attrs {
+		if key == "id" {
+			// _id is reserved in GELF
+			key = "attr_id"
+		}
+		m["_"+key] = value
+	}
+	m["version"] = "1.1"
+	m["host"] = g.host
+	m["short_message"] = e.msg
+	m["timestamp"] = float64(e.time.UnixNano()) / float64(time.Second)
+	m["level"] = e.level.severity()
+	return json.Marshal(m)
+}
+
+func (g *gelfClient) run() {
+	defer close(g.stopped)
+	for {
+		select {
+		case data := <-g.queue:
+			g.deliver(data)
+		case <-g.closing:
+			g.flush()
+			g.disconnect()
+			return
+		}
+	}
+}
+
+/*
+flush sends all pending messages, used on close.
+*/
+func (g *gelfClient) flush() {
+	deadline := time.Now().Add(gelfFlushTimeout)
+	reachable := true
+	for {
+		select {
+		case data := <-g.queue:
+			if !reachable || time.Now().After(deadline) {
+				atomic.AddUint64(&g.dropped, 1)
+				continue
+			}
+			g.deliver(data)
+			// don't try to reconnect for every pending message
+			reachable = g.conn != nil
+		default:
+			return
+		}
+	}
+}
+
+/*
+deliver sends a single message, reconnecting on errors until it's written or the client is closing
+*/
+func (g *gelfClient) deliver(data []byte) {
+	for {
+		if g.conn == nil {
+			if err := g.connect(); err != nil {
+				g.setError(err)
+				if !g.wait() {
+					atomic.AddUint64(&g.dropped, 1)
+					return
+				}
+				continue
+			}
+		}
+		if err := g.writeConn(data); err != nil {
+			atomic.AddUint64(&g.failed, 1)
+			g.setError(err)
+			g.disconnect()
+			if !g.wait() {
+				atomic.AddUint64(&g.dropped, 1)
+				return
+			}
+			continue
+		}
+		g.backoff = 0
+		atomic.AddUint64(&g.sent, 1)
+		if atomic.SwapInt32(&g.connected, 1) == 0 {
+			g.setError(nil)
+		}
+		return
+	}
+}
+
+/*
+wait waits for the next backoff period, returns false if the client is closing
+*/
+func (g *gelfClient) wait() bool {
+	select {
+	case <-g.closing:
+		return false
+	default:
+	}
+	if g.backoff == 0 {
+		g.backoff = gelfMinBackoff
+	} else {
+		g.backoff *= 2
+		if g.backoff > gelfMaxBackoff {
+			g.backoff = gelfMaxBackoff
+		}
+	}
+	timer := time.NewTimer(g.backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-g.closing:
+		return false
+	}
+}
+
+func (g *gelfClient) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: gelfDialTimeout}
+	switch g.protocol {
+	case GelfTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", g.address, g.tlsConfig)
+	default:
+		conn, err = dialer.Dial(g.protocol, g.address)
+	}
+	if err != nil {
+		return err
+	}
+	g.conn = conn
+	// a udp connect doesn't check the reachability, udp is connected after the first successful write
+	if g.protocol != GelfUDP {
+		atomic.StoreInt32(&g.connected, 1)
+		g.setError(nil)
+	}
+	return nil
+}
+
+func (g *gelfClient) disconnect() {
+	if g.conn != nil {
+		g.conn.Close()
+		g.conn = nil
+	}
+	atomic.StoreInt32(&g.connected, 0)
+}
+
+func (g *gelfClient) writeConn(data []byte) error {
+	g.conn.SetWriteDeadline(time.Now().Add(gelfWriteTimeout))
+	if g.protocol == GelfUDP {
+		return g.writeUDP(data)
+	}
+	// stream transports are using a null byte as frame delimiter, without compression
+	_, err := g.conn.Write(append(data, 0))
+	return err
+}
+
+func (g *gelfClient) writeUDP(data []byte) error {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+	if len(payload) <= gelfChunkSize {
+		_, err := g.conn.Write(payload)
+		return err
+	}
+
+	chunkData := gelfChunkSize - gelfChunkHeader
+	count := (len(payload) + chunkData - 1) / chunkData
+	if count > gelfMaxChunks {
+		return fmt.Errorf("gelf message too large: %d chunks", count)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, gelfChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkData
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk = chunk[:0]
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*chunkData:end]...)
+		if _, err := g.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *gelfClient) setError(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err == nil {
+		g.lastError = ""
+		return
+	}
+	g.lastError = err.Error()
+}
+
+/*
+stats returns the actual counters of this client
+*/
+func (g *gelfClient) stats() GelfStats {
+	g.mu.Lock()
+	lastError := g.lastError
+	g.mu.Unlock()
+	return GelfStats{
+		Sent:      atomic.LoadUint64(&g.sent),
+		Dropped:   atomic.LoadUint64(&g.dropped),
+		Failed:    atomic.LoadUint64(&g.failed),
+		Connected: atomic.LoadInt32(&g.connected) == 1,
+		LastError: lastError,
+	}
+}
+
+/*
+close flushes the pending messages and closes the connection
+*/
+func (g *gelfClient) close() {
+	g.once.Do(func() {
+		close(g.closing)
+	})
+	select {
+	case <-g.stopped:
+	case <-time.After(gelfFlushTimeout + gelfDialTimeout):
+	}
+}
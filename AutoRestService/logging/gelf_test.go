@@ -0,0 +1,224 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testEntry(msg string) *entry {
+	return &entry{
+		time:  time.Now(),
+		level: InfoLevel,
+		msg:   msg,
+		attrs: map[string]interface{}{"tenant": "t1", "id": "42"},
+	}
+}
+
+func listenUDP(t *testing.T) (*net.UDPConn, int) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("can't listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+/*
+readGelfUDP reading one message from the listener, reassembling the chunks and decompressing it
+*/
+func readGelfUDP(t *testing.T, conn *net.UDPConn) (map[string]interface{}, int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	var chunks [][]byte
+	var payload []byte
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("can't read datagram: %v", err)
+		}
+		data := append([]byte{}, buf[:n]...)
+		if !bytes.HasPrefix(data, gelfChunkMagic) {
+			payload = data
+			break
+		}
+		if n > gelfChunkSize {
+			t.Fatalf("chunk too large: %d", n)
+		}
+		seq, count := int(data[10]), int(data[11])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		chunks[seq] = data[gelfChunkHeader:]
+		complete := true
+		for _, c := range chunks {
+			complete = complete && c != nil
+		}
+		if complete {
+			payload = bytes.Join(chunks, nil)
+			break
+		}
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("payload not zlib compressed: %v", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("can't decompress: %v", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("wrong gelf message: %v", err)
+	}
+	return m, len(chunks)
+}
+
+func TestGelfUDP(t *testing.T) {
+	conn, port := listenUDP(t)
+	c, err := newGelfClient(DebugLevel, GelfUDP, "127.0.0.1", port, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	if c.stats().Connected {
+		t.Error("udp must not be connected before the first message")
+	}
+	c.write(testEntry("hello"))
+	m, chunks := readGelfUDP(t, conn)
+	if chunks != 0 {
+		t.Errorf("small message chunked into %d chunks", chunks)
+	}
+	if m["short_message"] != "hello" || m["version"] != "1.1" || m["_tenant"] != "t1" || m["_attr_id"] != "42" {
+		t.Errorf("wrong message: %v", m)
+	}
+	if m["level"] != float64(6) {
+		t.Errorf("wrong level: %v", m["level"])
+	}
+}
+
+func TestGelfUDPChunking(t *testing.T) {
+	conn, port := listenUDP(t)
+	c, err := newGelfClient(DebugLevel, GelfUDP, "127.0.0.1", port, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	// random data can't be compressed, so the message needs several chunks
+	random := make([]byte, 20000)
+	rand.Read(random)
+	msg := hex.EncodeToString(random)
+	c.write(testEntry(msg))
+	m, chunks := readGelfUDP(t, conn)
+	if chunks < 2 {
+		t.Errorf("large message not chunked: %d", chunks)
+	}
+	if m["short_message"] != msg {
+		t.Error("message not reassembled")
+	}
+}
+
+func TestGelfUDPTooLarge(t *testing.T) {
+	c := &gelfClient{protocol: GelfUDP}
+	random := make([]byte, gelfMaxChunks*gelfChunkSize+1)
+	rand.Read(random)
+	_, port := listenUDP(t)
+	udp, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	c.conn = udp
+	if err := c.writeUDP(random); err == nil {
+		t.Error("message with too many chunks must fail")
+	}
+}
+
+func TestGelfTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	frames := make(chan []byte, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := r.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			frames <- frame
+		}
+	}()
+	c, err := newGelfClient(DebugLevel, GelfTCP, "127.0.0.1", l.Addr().(*net.TCPAddr).Port, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.stats().Connected {
+		t.Error("tcp not connected")
+	}
+	c.write(testEntry("first"))
+	c.write(testEntry("second"))
+	for _, want := range []string{"first", "second"} {
+		select {
+		case frame := <-frames:
+			var m map[string]interface{}
+			if err := json.Unmarshal(bytes.TrimSuffix(frame, []byte{0}), &m); err != nil {
+				t.Fatalf("frame not json: %v", err)
+			}
+			if m["short_message"] != want {
+				t.Errorf("got %v, want %s", m["short_message"], want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+	c.close()
+	if s := c.stats(); s.Sent != 2 || s.Dropped != 0 {
+		t.Errorf("wrong stats: %+v", s)
+	}
+}
+
+func TestGelfBufferFull(t *testing.T) {
+	// no sender running, so the buffer fills up
+	c := &gelfClient{queue: make(chan []byte, 2), closing: make(chan struct{})}
+	for i := 0; i < 5; i++ {
+		c.write(testEntry("msg"))
+	}
+	if s := c.stats(); s.Dropped != 3 {
+		t.Errorf("dropped %d, want 3", s.Dropped)
+	}
+}
+
+func TestGelfUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	c, err := newGelfClient(DebugLevel, GelfTCP, "127.0.0.1", port, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	if s := c.stats(); s.Connected || s.LastError == "" {
+		t.Errorf("unreachable server reported as connected: %+v", s)
+	}
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Level log level of a message, ordered by severity
+*/
+type Level int
+
+// all supported log levels
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	AlertLevel
+	FatalLevel
+)
+
+/*
+ParseLevel parsing a level name, an empty name is the debug level
+*/
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "debug":
+		return DebugLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "alert", "warn", "warning", "error":
+		return AlertLevel, nil
+	case "fatal", "crit", "critical":
+		return FatalLevel, nil
+	}
+	return DebugLevel, fmt.Errorf("unknown log level: %s", name)
+}
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case AlertLevel:
+		return "alert"
+	case FatalLevel:
+		return "fatal"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+/*
+severity the syslog severity of this level, used by gelf and syslog
+*/
+func (l Level) severity() int {
+	switch l {
+	case DebugLevel:
+		return 7
+	case InfoLevel:
+		return 6
+	case AlertLevel:
+		return 1
+	}
+	return 2
+}
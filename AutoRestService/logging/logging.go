@@ -1,125 +1,301 @@
 package logging
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"os"
+	"sync"
+	"time"
 
-	golf "github.com/aphistic/golf"
+	"go.opentelemetry.io/otel/trace"
 )
 
 /*
-ServiceLogger main type for logging
+ServiceLogger main type for logging.
+The configured sinks are shared by all loggers of this process. If no sinks are configured, all messages are written to the standard logger.
 */
 type ServiceLogger struct {
-	GelfURL    string
-	GelfPort   int
-	SystemID   string
-	Attrs      map[string]interface{}
-	gelfActive bool
-	c          *golf.Client
+	GelfURL      string
+	GelfPort     int
+	GelfProtocol string
+	// GelfLevel minimum level of messages send to graylog
+	GelfLevel string
+	// GelfBufferSize size of the message buffer, if the buffer is full, messages will be dropped
+	GelfBufferSize int
+	// GelfCAFile ca certificates for verifying the graylog server on tls transport
+	GelfCAFile string
+	// GelfInsecure skip verification of the server certificate on tls transport
+	GelfInsecure bool
+	SystemID     string
+	// Package name of the package using this logger, used for package based log level overrides
+	Package string
+	Attrs   map[string]interface{}
 }
 
+var sinksMutex sync.RWMutex
+var outputs []sink
+var gelfSink *gelfClient
+var baseAttrs map[string]interface{}
+
 /*
 InitGelf initialise gelf logging
 */
-func (s *ServiceLogger) InitGelf() {
-	s.gelfActive = false
-	if s.GelfURL != "" {
-		s.c, _ = golf.NewClient()
-		s.c.Dial(fmt.Sprintf("udp://%s:%d", s.GelfURL, s.GelfPort))
+func (s *ServiceLogger) InitGelf() error {
+	if s.GelfURL == "" {
+		// on a reload graylog may be switched off
+		sinksMutex.Lock()
+		old := gelfSink
+		gelfSink = nil
+		sinksMutex.Unlock()
+		if old != nil {
+			old.close()
+		}
+		return nil
+	}
+	level, err := ParseLevel(s.GelfLevel)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if s.GelfProtocol == GelfTLS {
+		tlsConfig, err = loadGelfTLSConfig(s.GelfCAFile, s.GelfInsecure)
+		if err != nil {
+			return err
+		}
+	}
+	c, err := newGelfClient(level, s.GelfProtocol, s.GelfURL, s.GelfPort, s.GelfBufferSize, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("can't create gelf client: %s", err.Error())
+	}
+	sinksMutex.Lock()
+	old := gelfSink
+	gelfSink = c
+	s.setBaseAttrs()
+	sinksMutex.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return nil
+}
+
+/*
+InitSinks initialise the additional log sinks, already configured sinks will be closed
+*/
+func (s *ServiceLogger) InitSinks(configs []SinkConfig) error {
+	list := make([]sink, 0, len(configs))
+	for _, cfg := range configs {
+		o, err := newSink(cfg)
+		if err != nil {
+			for _, o := range list {
+				o.close()
+			}
+			return fmt.Errorf("can't create %s log sink: %s", cfg.Type, err.Error())
+		}
+		list = append(list, o)
+	}
+	sinksMutex.Lock()
+	old := outputs
+	outputs = list
+	s.setBaseAttrs()
+	sinksMutex.Unlock()
+	for _, o := range old {
+		o.close()
+	}
+	return nil
+}
+
+/*
+setBaseAttrs attributes of the initialising logger, which are added to all messages
+*/
+func (s *ServiceLogger) setBaseAttrs() {
+	attrs := make(map[string]interface{}, len(s.Attrs)+1)
+	for key, value := range s.Attrs {
+		attrs[key] = value
+	}
+	if s.SystemID != "" {
+		attrs["system_id"] = s.SystemID
+	}
+	baseAttrs = attrs
+}
+
+/*
+GelfStats getting the statistics of the gelf client
+*/
+func (s *ServiceLogger) GelfStats() GelfStats {
+	sinksMutex.RLock()
+	c := gelfSink
+	sinksMutex.RUnlock()
+	if c == nil {
+		return GelfStats{}
+	}
+	return c.stats()
+}
+
+/*
+CheckGelf health check for the gelf log sink, reports if the graylog server is reachable
+*/
+func (s *ServiceLogger) CheckGelf() (bool, string) {
+	sinksMutex.RLock()
+	c := gelfSink
+	sinksMutex.RUnlock()
+	if c == nil {
+		return true, ""
+	}
+	stats := c.stats()
+	// udp is not connected until the first message is sent, without an error it's unknown
+	if !stats.Connected && stats.LastError != "" {
+		return false, fmt.Sprintf("graylog not reachable: %s, dropped messages: %d", stats.LastError, stats.Dropped)
+	}
+	return true, ""
+}
+
+/*
+With returns a copy of this logger with an additional attribute, e.g. the tenant or device of a request
+*/
+func (s *ServiceLogger) With(key string, value interface{}) *ServiceLogger {
+	l := *s
+	l.Attrs = make(map[string]interface{}, len(s.Attrs)+1)
+	for k, v := range s.Attrs {
+		l.Attrs[k] = v
+	}
+	l.Attrs[key] = value
+	return &l
+}
 
-		l, _ := s.c.NewLogger()
+/*
+WithContext returns a copy of this logger with the trace and span id of the actual span in the context
+*/
+func (s *ServiceLogger) WithContext(ctx context.Context) *ServiceLogger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return s
+	}
+	return s.With("trace_id", sc.TraceID().String()).With("span_id", sc.SpanID().String())
+}
 
-		golf.DefaultLogger(l)
-		for key, value := range s.Attrs {
-			l.SetAttr(key, value)
+/*
+log writing the message to all sinks, if no output sink is configured, the message is written to the standard logger
+*/
+func (s *ServiceLogger) log(level Level, msg string) {
+	attrs := s.attrs()
+	if level < FatalLevel && !enabled(level, attrs) {
+		return
+	}
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+	e := &entry{
+		time:  time.Now(),
+		level: level,
+		msg:   msg,
+		attrs: attrs,
+	}
+	if gelfSink != nil && level >= gelfSink.minLevel() {
+		gelfSink.write(e)
+	}
+	for _, o := range outputs {
+		if level >= o.minLevel() {
+			o.write(e)
+		}
+	}
+	if len(outputs) == 0 {
+		switch level {
+		case AlertLevel:
+			log.Printf("Alert: %s\n", msg)
+		case FatalLevel:
+			log.Printf("Fatal: %s\n", msg)
+		default:
+			log.Println(msg)
 		}
-		l.SetAttr("system_id", s.SystemID)
-		s.gelfActive = true
 	}
 }
 
+func (s *ServiceLogger) attrs() map[string]interface{} {
+	if len(s.Attrs) == 0 && s.Package == "" {
+		return baseAttrs
+	}
+	attrs := make(map[string]interface{}, len(baseAttrs)+len(s.Attrs)+1)
+	for key, value := range baseAttrs {
+		attrs[key] = value
+	}
+	for key, value := range s.Attrs {
+		attrs[key] = value
+	}
+	if s.Package != "" {
+		attrs[ScopePackage] = s.Package
+	}
+	return attrs
+}
+
 /*
 Debug log this maeesage at debug level
 */
 func (s *ServiceLogger) Debug(msg string) {
-	if s.gelfActive {
-		golf.Info(msg)
-	}
-	log.Println(msg)
+	s.log(DebugLevel, msg)
 }
 
 /*
 Debugf log this maeesage at debug level with formatting
 */
 func (s *ServiceLogger) Debugf(format string, va ...interface{}) {
-	if s.gelfActive {
-		golf.Infof(format, va...)
-	}
-	log.Printf(format+"\n", va...)
+	s.Debug(fmt.Sprintf(format, va...))
 }
 
 /*
 Info log this maeesage at info level
 */
 func (s *ServiceLogger) Info(msg string) {
-	if s.gelfActive {
-		golf.Info(msg)
-	}
-	log.Println(msg)
+	s.log(InfoLevel, msg)
 }
 
 /*
 Infof log this maeesage at info level with formatting
 */
 func (s *ServiceLogger) Infof(format string, va ...interface{}) {
-	if s.gelfActive {
-		golf.Infof(format, va...)
-	}
-	log.Printf(format+"\n", va...)
+	s.Info(fmt.Sprintf(format, va...))
 }
 
 /*
 Alert log this maeesage at alert level
 */
 func (s *ServiceLogger) Alert(msg string) {
-	if s.gelfActive {
-		golf.Alert(msg)
-	}
-	log.Printf("Alert: %s\n", msg)
+	s.log(AlertLevel, msg)
 }
 
 /*
 Alertf log this maeesage at alert level with formatting
 */
 func (s *ServiceLogger) Alertf(format string, va ...interface{}) {
-	if s.gelfActive {
-		golf.Alertf(format, va...)
-	}
-	log.Printf("Alert: %s\n", fmt.Sprintf(format, va...))
+	s.Alert(fmt.Sprintf(format, va...))
 }
 
 // Fatal logs a message at level Fatal on the standard logger.
 func (s *ServiceLogger) Fatal(msg string) {
-	if s.gelfActive {
-		golf.Crit(msg)
-	}
-	log.Fatalf("Fatal: %s\n", msg)
+	s.log(FatalLevel, msg)
+	s.Close()
+	os.Exit(1)
 }
 
 // Fatalf logs a message at level Fatal on the standard logger.
 func (s *ServiceLogger) Fatalf(format string, va ...interface{}) {
-	if s.gelfActive {
-		golf.Critf(format, va...)
-	}
-	log.Fatalf("Fatal: %s\n", fmt.Sprintf(format, va...))
+	s.Fatal(fmt.Sprintf(format, va...))
 }
 
 /*
-Close this logging client
+Close closing all log sinks, pending messages will be flushed
 */
 func (s *ServiceLogger) Close() {
-	if s.gelfActive {
-		s.c.Close()
+	sinksMutex.Lock()
+	c := gelfSink
+	list := outputs
+	gelfSink = nil
+	outputs = nil
+	sinksMutex.Unlock()
+	if c != nil {
+		c.close()
+	}
+	for _, o := range list {
+		o.close()
 	}
 }
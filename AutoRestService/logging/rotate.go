@@ -0,0 +1,254 @@
+package logging
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rotateHourly     = "hourly"
+	rotateDaily      = "daily"
+	backupTimeFormat = "20060102T150405.000"
+	compressSuffix   = ".gz"
+)
+
+/*
+rotatingFile is a writer for a log file, which will be rotated by size and/or time.
+Rotated files can be compressed and will be removed after the retention time or if there are too many of them.
+*/
+type rotatingFile struct {
+	filename   string
+	maxSize    int64
+	interval   string
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	period time.Time
+
+	// serializing the compression and cleanup of rotated files
+	millMu sync.Mutex
+}
+
+func newRotatingFile(cfg SinkConfig) (*rotatingFile, error) {
+	if cfg.Filename == "" {
+		return nil, errors.New("log file sink without filename")
+	}
+	switch cfg.Rotate {
+	case "", rotateHourly, rotateDaily:
+	default:
+		return nil, fmt.Errorf("unknown log file rotation: %s", cfg.Rotate)
+	}
+	r := &rotatingFile{
+		filename:   cfg.Filename,
+		maxSize:    int64(cfg.MaxSize) * 1024 * 1024,
+		interval:   cfg.Rotate,
+		maxAge:     time.Duration(cfg.MaxAge) * 24 * time.Hour,
+		maxBackups: cfg.MaxBackups,
+		compress:   cfg.Compress,
+	}
+	if err := os.MkdirAll(filepath.Dir(r.filename), 0755); err != nil {
+		return nil, fmt.Errorf("can't create log directory: %s", err.Error())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.mill()
+	return r, nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now()
+	sizeExceeded := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	if sizeExceeded || !r.periodStart(now).Equal(r.period) {
+		if err := r.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+/*
+Close closes the actual log file
+*/
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+/*
+open opens or creates the log file, appending to an existing one
+*/
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("can't open log file: %s", err.Error())
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("can't open log file: %s", err.Error())
+	}
+	r.file = file
+	r.size = info.Size()
+	if r.size > 0 {
+		r.period = r.periodStart(info.ModTime())
+	} else {
+		r.period = r.periodStart(time.Now())
+	}
+	return nil
+}
+
+/*
+periodStart the start of the rotation period of the given time
+*/
+func (r *rotatingFile) periodStart(t time.Time) time.Time {
+	switch r.interval {
+	case rotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case rotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+func (r *rotatingFile) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	if err := os.Rename(r.filename, r.backupName(now)); err != nil {
+		return fmt.Errorf("can't rotate log file: %s", err.Error())
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.period = r.periodStart(now)
+	go r.mill()
+	return nil
+}
+
+func (r *rotatingFile) prefixAndExt() (string, string) {
+	base := filepath.Base(r.filename)
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-", ext
+}
+
+func (r *rotatingFile) backupName(t time.Time) string {
+	prefix, ext := r.prefixAndExt()
+	return filepath.Join(filepath.Dir(r.filename), prefix+t.Format(backupTimeFormat)+ext)
+}
+
+type backupFile struct {
+	name string
+	time time.Time
+}
+
+/*
+backups list all rotated files of this log file, newest first
+*/
+func (r *rotatingFile) backups() ([]backupFile, error) {
+	dir := filepath.Dir(r.filename)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	prefix, ext := r.prefixAndExt()
+	list := make([]backupFile, 0)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts := strings.TrimPrefix(strings.TrimSuffix(name, compressSuffix), prefix)
+		ts = strings.TrimSuffix(ts, ext)
+		t, err := time.ParseInLocation(backupTimeFormat, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		list = append(list, backupFile{name: filepath.Join(dir, name), time: t})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].time.After(list[j].time)
+	})
+	return list, nil
+}
+
+/*
+mill compresses the rotated files and removes the expired ones
+*/
+func (r *rotatingFile) mill() {
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+	list, err := r.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-r.maxAge)
+	for i, backup := range list {
+		if (r.maxBackups > 0 && i >= r.maxBackups) || (r.maxAge > 0 && backup.time.Before(cutoff)) {
+			os.Remove(backup.name)
+			continue
+		}
+		if r.compress && !strings.HasSuffix(backup.name, compressSuffix) {
+			compressFile(backup.name)
+		}
+	}
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+compressSuffix, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + compressSuffix)
+		return err
+	}
+	src.Close()
+	return os.Remove(name)
+}
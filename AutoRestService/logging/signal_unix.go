@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package logging
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+HandleLevelSignal toggles the global log level between debug and the configured level on SIGUSR1
+*/
+func HandleLevelSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		var l ServiceLogger
+		for range c {
+			level := ToggleDebug()
+			l.Alertf("SIGUSR1 received, log level is now %s", level)
+		}
+	}()
+}
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package logging
+
+/*
+HandleLevelSignal there is no SIGUSR1 on windows, use the admin api instead
+*/
+func HandleLevelSignal() {
+}
@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// sink types
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkSyslog = "syslog"
+)
+
+// output formats of a sink
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+/*
+SinkConfig configuration of a single log sink
+*/
+type SinkConfig struct {
+	// Type of the sink: stdout, file or syslog
+	Type string
+	// Level minimum level of messages written to this sink
+	Level string
+	// Format of the messages, text or json
+	Format string
+
+	// Filename of the log file
+	Filename string
+	// MaxSize maximum size of a log file in megabytes before it gets rotated
+	MaxSize int
+	// Rotate time based rotation: hourly or daily
+	Rotate string
+	// MaxAge maximum age of rotated files in days
+	MaxAge int
+	// MaxBackups maximum number of rotated files to keep
+	MaxBackups int
+	// Compress rotated files with gzip
+	Compress bool
+
+	// Network of the syslog server: udp, tcp or unix
+	Network string
+	// Address of the syslog server, host:port or the path of the unix socket
+	Address string
+	// Facility syslog facility name, like local0
+	Facility string
+	// AppName the app name used in syslog messages
+	AppName string
+}
+
+/*
+entry a single log message
+*/
+type entry struct {
+	time  time.Time
+	level Level
+	msg   string
+	attrs map[string]interface{}
+}
+
+/*
+sink is a destination for log messages
+*/
+type sink interface {
+	minLevel() Level
+	write(e *entry)
+	close()
+}
+
+/*
+newSink creates a sink from the configuration
+*/
+func newSink(cfg SinkConfig) (sink, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	format := cfg.Format
+	switch format {
+	case "":
+		format = FormatText
+	case FormatText, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", cfg.Format)
+	}
+	switch cfg.Type {
+	case SinkStdout:
+		return &writerSink{level: level, format: format, w: os.Stdout}, nil
+	case SinkFile:
+		w, err := newRotatingFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{level: level, format: format, w: w, c: w}, nil
+	case SinkSyslog:
+		return newSyslogSink(cfg, level, format)
+	}
+	return nil, fmt.Errorf("unknown log sink type: %s", cfg.Type)
+}
+
+/*
+writerSink writing formatted messages line by line to a writer
+*/
+type writerSink struct {
+	level  Level
+	format string
+	mu     sync.Mutex
+	w      io.Writer
+	c      io.Closer
+}
+
+func (w *writerSink) minLevel() Level {
+	return w.level
+}
+
+func (w *writerSink) write(e *entry) {
+	var line []byte
+	if w.format == FormatJSON {
+		line = formatJSON(e)
+	} else {
+		line = formatText(e)
+	}
+	line = append(line, '\n')
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.w.Write(line)
+}
+
+func (w *writerSink) close() {
+	if w.c != nil {
+		w.c.Close()
+	}
+}
+
+func formatText(e *entry) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(e.time.Format("2006-01-02T15:04:05.000Z07:00"))
+	buf.WriteString(fmt.Sprintf(" %-5s ", e.level))
+	buf.WriteString(e.msg)
+	for _, key := range sortedKeys(e.attrs) {
+		buf.WriteString(fmt.Sprintf(" %s=%v", key, e.attrs[key]))
+	}
+	return buf.Bytes()
+}
+
+func formatJSON(e *entry) []byte {
+	m := make(map[string]interface{}, len(e.attrs)+3)
+	for key, value := range e.attrs {
+		m[key] = value
+	}
+	m["time"] = e.time.Format(time.RFC3339Nano)
+	m["level"] = e.level.String()
+	m["message"] = e.msg
+	data, err := json.Marshal(m)
+	if err != nil {
+		return formatText(e)
+	}
+	return data
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
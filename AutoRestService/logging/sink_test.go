@@ -0,0 +1,264 @@
+package logging
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFile(t *testing.T, maxSize int64, maxBackups int, compress bool) *rotatingFile {
+	t.Helper()
+	r := &rotatingFile{
+		filename:   filepath.Join(t.TempDir(), "service.log"),
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		compress:   compress,
+	}
+	if err := r.open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRotateBySize(t *testing.T) {
+	r := newTestFile(t, 100, 0, false)
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 3; i++ {
+		if _, err := r.Write(line); err != nil {
+			t.Fatal(err)
+		}
+		// the backup names have a resolution of milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	list, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d rotated files, want 2", len(list))
+	}
+	data, _ := ioutil.ReadFile(r.filename)
+	if len(data) != len(line) {
+		t.Errorf("actual file has %d bytes, want %d", len(data), len(line))
+	}
+}
+
+func TestRotateBackupsAndCompression(t *testing.T) {
+	r := newTestFile(t, 10, 2, true)
+	for i := 0; i < 5; i++ {
+		r.Write([]byte("line " + strconv.Itoa(i) + "\n"))
+		time.Sleep(2 * time.Millisecond)
+	}
+	r.mill()
+	list, err := r.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("got %d rotated files, want 2", len(list))
+	}
+	for _, b := range list {
+		if !strings.HasSuffix(b.name, compressSuffix) {
+			t.Errorf("%s not compressed", b.name)
+			continue
+		}
+		f, _ := os.Open(b.name)
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Errorf("%s: %v", b.name, err)
+		} else if data, _ := ioutil.ReadAll(zr); !strings.HasPrefix(string(data), "line ") {
+			t.Errorf("%s: wrong content %q", b.name, data)
+		}
+		f.Close()
+	}
+	// the newest rotated file contains the last but one line
+	f, _ := os.Open(list[0].name)
+	zr, _ := gzip.NewReader(f)
+	data, _ := ioutil.ReadAll(zr)
+	f.Close()
+	if string(data) != "line 3\n" {
+		t.Errorf("newest rotated file contains %q", data)
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	r := newTestFile(t, 0, 0, false)
+	r.interval = rotateHourly
+	r.period = r.periodStart(time.Now())
+	r.Write([]byte("old\n"))
+	// simulate a file of the last period
+	r.period = r.period.Add(-time.Hour)
+	r.Write([]byte("new\n"))
+	list, _ := r.backups()
+	if len(list) != 1 {
+		t.Fatalf("got %d rotated files, want 1", len(list))
+	}
+	data, _ := ioutil.ReadFile(r.filename)
+	if string(data) != "new\n" {
+		t.Errorf("actual file contains %q", data)
+	}
+}
+
+func TestNewSinkErrors(t *testing.T) {
+	tests := []SinkConfig{
+		{Type: "unknown"},
+		{Type: SinkStdout, Format: "xml"},
+		{Type: SinkStdout, Level: "verbose"},
+		{Type: SinkFile},
+		{Type: SinkFile, Filename: filepath.Join(t.TempDir(), "x.log"), Rotate: "weekly"},
+		{Type: SinkSyslog, Network: "udp"},
+		{Type: SinkSyslog, Network: "sctp", Address: "localhost:514"},
+		{Type: SinkSyslog, Network: "udp", Address: "localhost:514", Facility: "none"},
+	}
+	for _, cfg := range tests {
+		if s, err := newSink(cfg); err == nil {
+			s.close()
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+}
+
+func TestWriterSinkJSON(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "json.log")
+	s, err := newSink(SinkConfig{Type: SinkFile, Format: FormatJSON, Filename: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.write(&entry{time: time.Now(), level: AlertLevel, msg: "alert", attrs: map[string]interface{}{"tenant": "t1"}})
+	s.close()
+	data, _ := ioutil.ReadFile(name)
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("not json: %q", data)
+	}
+	if m["message"] != "alert" || m["tenant"] != "t1" || m["level"] != AlertLevel.String() {
+		t.Errorf("wrong message: %v", m)
+	}
+}
+
+func testSyslogEntry() *entry {
+	ts, _ := time.Parse(time.RFC3339, "2020-01-02T03:04:05Z")
+	return &entry{time: ts, level: AlertLevel, msg: "disk full", attrs: map[string]interface{}{"tenant": `a"b]`, "bad name": 1}}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	s := &syslogSink{facility: syslogFacilities["local0"], hostname: "host", appName: "app", pid: 42}
+	msg := string(s.format5424(testSyslogEntry()))
+	want := `<` + strconv.Itoa(16*8+AlertLevel.severity()) + `>1 2020-01-02T03:04:05.000000Z host app 42 - [attrs@32473 bad_name="1" tenant="a\"b\]"] disk full`
+	if msg != want {
+		t.Errorf("got  %s\nwant %s", msg, want)
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, port := listenUDP(t)
+	s, err := newSink(SinkConfig{Type: SinkSyslog, Network: "udp", Address: "127.0.0.1:" + strconv.Itoa(port), AppName: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	s.write(testSyslogEntry())
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<") || !strings.HasSuffix(msg, " disk full") {
+		t.Errorf("wrong message: %s", msg)
+	}
+}
+
+func TestSyslogTCPFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			// octet counting: the length, a space and the message
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSpace(length))
+			if err != nil {
+				received <- "wrong frame: " + length
+				return
+			}
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+	s, err := newSink(SinkConfig{Type: SinkSyslog, Network: "tcp", Address: l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	s.write(testSyslogEntry())
+	s.write(testSyslogEntry())
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			if !strings.HasSuffix(msg, " disk full") {
+				t.Errorf("wrong message: %s", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message received")
+		}
+	}
+}
+
+func TestSyslogNeverBlocks(t *testing.T) {
+	// a sink without sender, like a sender hanging on an unreachable server
+	s := &syslogSink{queue: make(chan []byte, 1), closing: make(chan struct{})}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		s.write(testSyslogEntry())
+	}
+	if time.Since(start) > time.Second || s.dropped != 2 {
+		t.Errorf("%d dropped in %s", s.dropped, time.Since(start))
+	}
+}
+
+func TestSyslogUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+	s, err := newSyslogSink(SinkConfig{Type: SinkSyslog, Network: "tcp", Address: address}, AlertLevel, FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		s.write(testSyslogEntry())
+	}
+	s.close()
+	// the messages after the failed connect are dropped without dialing again
+	if s.dropped != 3 || s.retryAt.IsZero() {
+		t.Errorf("%d dropped, retry at %s", s.dropped, s.retryAt)
+	}
+}
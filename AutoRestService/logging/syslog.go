@@ -0,0 +1,289 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogFlushTimeout = 5 * time.Second
+	syslogBufferSize   = 1000
+	// messages are dropped for this time after a failed connect, instead of dialing for every message
+	syslogRetryDelay = 5 * time.Second
+	// structured data id for the message attributes, 32473 is the enterprise number reserved for documentation
+	syslogSDID = "attrs@32473"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+/*
+syslogSink sending RFC 5424 messages to a syslog server via udp, tcp or a unix socket.
+On tcp the messages are framed with octet counting (RFC 6587).
+Like the gelf client the messages are queued in a bounded buffer and sent in the background,
+so an unreachable server never blocks the logging. If the buffer is full, the message will be dropped.
+*/
+type syslogSink struct {
+	level    Level
+	format   string
+	network  string
+	address  string
+	facility int
+	hostname string
+	appName  string
+	pid      int
+
+	queue   chan []byte
+	closing chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped uint64
+
+	// only used by the sender
+	conn    net.Conn
+	retryAt time.Time
+}
+
+func newSyslogSink(cfg SinkConfig, level Level, format string) (*syslogSink, error) {
+	network := cfg.Network
+	address := cfg.Address
+	switch network {
+	case "udp", "tcp":
+		if address == "" {
+			return nil, errors.New("syslog sink without address")
+		}
+	case "", "unix":
+		network = "unix"
+		if address == "" {
+			address = "/dev/log"
+		}
+	default:
+		return nil, fmt.Errorf("unknown syslog network: %s", cfg.Network)
+	}
+	facility := syslogFacilities["local0"]
+	if cfg.Facility != "" {
+		f, ok := syslogFacilities[strings.ToLower(cfg.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility: %s", cfg.Facility)
+		}
+		facility = f
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	appName := cfg.AppName
+	if appName == "" {
+		appName = filepath.Base(os.Args[0])
+	}
+	s := &syslogSink{
+		level:    level,
+		format:   format,
+		network:  network,
+		address:  address,
+		facility: facility,
+		hostname: hostname,
+		appName:  appName,
+		pid:      os.Getpid(),
+		queue:    make(chan []byte, syslogBufferSize),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	// a not reachable syslog server is no error, the sender will try to reconnect on the next message
+	s.connect()
+	go s.run()
+	return s, nil
+}
+
+func (s *syslogSink) minLevel() Level {
+	return s.level
+}
+
+/*
+write queues a message, never blocks
+*/
+func (s *syslogSink) write(e *entry) {
+	select {
+	case <-s.closing:
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	default:
+	}
+	select {
+	case s.queue <- s.format5424(e):
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *syslogSink) run() {
+	defer close(s.stopped)
+	for {
+		select {
+		case msg := <-s.queue:
+			s.deliver(msg)
+		case <-s.closing:
+			s.flush()
+			if s.conn != nil {
+				s.conn.Close()
+				s.conn = nil
+			}
+			return
+		}
+	}
+}
+
+/*
+flush sends the pending messages on close, until the flush timeout is reached
+*/
+func (s *syslogSink) flush() {
+	deadline := time.Now().Add(syslogFlushTimeout)
+	for {
+		select {
+		case msg := <-s.queue:
+			if time.Now().After(deadline) {
+				atomic.AddUint64(&s.dropped, 1)
+				continue
+			}
+			s.deliver(msg)
+		default:
+			return
+		}
+	}
+}
+
+/*
+deliver sends a single message with one retry on a fresh connection, if the server was restarted
+*/
+func (s *syslogSink) deliver(msg []byte) {
+	for i := 0; i < 2; i++ {
+		if s.conn == nil {
+			if time.Now().Before(s.retryAt) {
+				break
+			}
+			if err := s.connect(); err != nil {
+				s.retryAt = time.Now().Add(syslogRetryDelay)
+				break
+			}
+		}
+		if err := s.send(msg); err == nil {
+			return
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	atomic.AddUint64(&s.dropped, 1)
+}
+
+func (s *syslogSink) close() {
+	s.once.Do(func() {
+		close(s.closing)
+	})
+	select {
+	case <-s.stopped:
+	case <-time.After(syslogFlushTimeout + syslogDialTimeout):
+	}
+}
+
+func (s *syslogSink) connect() error {
+	var conn net.Conn
+	var err error
+	if s.network == "unix" {
+		// syslog daemons are mostly listening on datagram sockets
+		conn, err = net.DialTimeout("unixgram", s.address, syslogDialTimeout)
+		if err != nil {
+			conn, err = net.DialTimeout("unix", s.address, syslogDialTimeout)
+		}
+	} else {
+		conn, err = net.DialTimeout(s.network, s.address, syslogDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *syslogSink) send(msg []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if s.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+/*
+format5424 formatting the entry as RFC 5424 message, attributes are written as structured data
+*/
+func (s *syslogSink) format5424(e *entry) []byte {
+	var buf bytes.Buffer
+	pri := s.facility*8 + e.level.severity()
+	buf.WriteString(fmt.Sprintf("<%d>1 %s %s %s %d - ", pri, e.time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.appName, s.pid))
+	if len(e.attrs) == 0 {
+		buf.WriteString("-")
+	} else {
+		buf.WriteString("[" + syslogSDID)
+		for _, key := range sortedKeys(e.attrs) {
+			buf.WriteString(fmt.Sprintf(" %s=\"%s\"", sdName(key), sdEscape(fmt.Sprintf("%v", e.attrs[key]))))
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString(" ")
+	if s.format == FormatJSON {
+		buf.Write(formatJSON(e))
+	} else {
+		buf.WriteString(e.msg)
+	}
+	return buf.Bytes()
+}
+
+/*
+sdName param names are restricted to printable ascii without '=', ' ', ']' and '"', max 32 chars
+*/
+func sdName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+func sdEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
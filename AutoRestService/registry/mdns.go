@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/mdns"
+)
+
+const (
+	defaultMDNSService = "_autorest._tcp"
+	defaultMDNSDomain  = "local."
+)
+
+/*
+MDNSConfig configuration of the mDNS/DNS-SD service advertisement
+*/
+type MDNSConfig struct {
+	// Instance name of this instance, default is the hostname
+	Instance string
+	// Service the DNS-SD service type, default is _autorest._tcp
+	Service string
+	// Domain default is local.
+	Domain string
+	// Interface name of the network interface to advertise on, default is the system default
+	Interface string
+	// Port the port of the service
+	Port int
+	// TXT additional txt records, as key=value
+	TXT []string
+}
+
+/*
+Advertiser mDNS responder advertising this service in the local network, so devices can discover it without a registry
+*/
+type Advertiser struct {
+	server *mdns.Server
+}
+
+/*
+NewAdvertiser creates and starts the mDNS responder
+*/
+func NewAdvertiser(cfg MDNSConfig) (*Advertiser, error) {
+	service, iface, err := newMDNSService(cfg)
+	if err != nil {
+		return nil, err
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service, Iface: iface})
+	if err != nil {
+		return nil, fmt.Errorf("can't start mdns responder: %s", err.Error())
+	}
+	log.Infof("advertising %s.%s.%s on port %d via mdns", service.Instance, service.Service, service.Domain, service.Port)
+	return &Advertiser{
+		server: server,
+	}, nil
+}
+
+/*
+newMDNSService building the zone with the defaults applied, and the interface to advertise on
+*/
+func newMDNSService(cfg MDNSConfig) (*mdns.MDNSService, *net.Interface, error) {
+	if cfg.Port <= 0 {
+		return nil, nil, errors.New("mdns port not set")
+	}
+	if cfg.Service == "" {
+		cfg.Service = defaultMDNSService
+	}
+	if cfg.Domain == "" {
+		cfg.Domain = defaultMDNSDomain
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get hostname: %s", err.Error())
+	}
+	if cfg.Instance == "" {
+		cfg.Instance = hostname
+	}
+	host := strings.TrimSuffix(hostname, ".") + "." + strings.TrimSuffix(cfg.Domain, ".") + "."
+	var iface *net.Interface
+	var ips []net.IP
+	if cfg.Interface != "" {
+		iface, err = net.InterfaceByName(cfg.Interface)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unknown network interface %s: %s", cfg.Interface, err.Error())
+		}
+		ips, err = interfaceIPs(iface)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if _, err := net.LookupIP(host); err != nil {
+		// the hostname is often not resolvable in containers, so the addresses of the interfaces are used
+		ips, err = localIPs()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	service, err := mdns.NewMDNSService(cfg.Instance, cfg.Service, cfg.Domain, host, cfg.Port, ips, cfg.TXT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't create mdns service: %s", err.Error())
+	}
+	return service, iface, nil
+}
+
+func interfaceIPs(iface *net.Interface) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("can't get addresses of %s: %s", iface.Name, err.Error())
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
+			ips = append(ips, ipnet.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses on interface %s", iface.Name)
+	}
+	return ips, nil
+}
+
+func localIPs() ([]net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("can't get network interfaces: %s", err.Error())
+	}
+	ips := make([]net.IP, 0)
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagUp == 0 || ifaces[i].Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if ifaceIPs, err := interfaceIPs(&ifaces[i]); err == nil {
+			ips = append(ips, ifaceIPs...)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no network addresses found")
+	}
+	return ips, nil
+}
+
+/*
+Stop stopping the mDNS responder
+*/
+func (a *Advertiser) Stop() error {
+	return a.server.Shutdown()
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMDNSService(t *testing.T) {
+	service, iface, err := newMDNSService(MDNSConfig{
+		Instance: "autorest-1",
+		Port:     9443,
+		TXT:      []string{"apiversion=v2", "tls=true"},
+	})
+	if err != nil {
+		t.Skipf("no network address available: %v", err)
+	}
+	if iface != nil {
+		t.Error("interface set without config")
+	}
+	if service.Service != defaultMDNSService || service.Domain != defaultMDNSDomain {
+		t.Errorf("defaults not applied: %s %s", service.Service, service.Domain)
+	}
+
+	// browsing for the service type is answered with the instance
+	records := service.Records(dns.Question{Name: "_autorest._tcp.local.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+	if len(records) == 0 {
+		t.Fatal("no answer for the service type")
+	}
+	if ptr, ok := records[0].(*dns.PTR); !ok || ptr.Ptr != "autorest-1._autorest._tcp.local." {
+		t.Errorf("wrong ptr record: %v", records[0])
+	}
+
+	records = service.Records(dns.Question{Name: "autorest-1._autorest._tcp.local.", Qtype: dns.TypeANY, Qclass: dns.ClassINET})
+	var srv *dns.SRV
+	var txt *dns.TXT
+	for _, rr := range records {
+		switch r := rr.(type) {
+		case *dns.SRV:
+			srv = r
+		case *dns.TXT:
+			txt = r
+		}
+	}
+	if srv == nil || srv.Port != 9443 {
+		t.Errorf("wrong srv record: %v", srv)
+	}
+	if txt == nil || len(txt.Txt) != 2 || txt.Txt[0] != "apiversion=v2" || txt.Txt[1] != "tls=true" {
+		t.Errorf("wrong txt record: %v", txt)
+	}
+}
+
+func TestMDNSServiceErrors(t *testing.T) {
+	if _, _, err := newMDNSService(MDNSConfig{}); err == nil {
+		t.Error("missing port accepted")
+	}
+	if _, _, err := newMDNSService(MDNSConfig{Port: 80, Interface: "doesnotexist0"}); err == nil {
+		t.Error("unknown interface accepted")
+	}
+}
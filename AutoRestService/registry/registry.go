@@ -0,0 +1,324 @@
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+// check types for the consul registration
+const (
+	CheckHTTP = "http"
+	CheckTTL  = "ttl"
+)
+
+const (
+	defaultInterval   = 30 * time.Second
+	defaultHealthPath = "/health/health"
+)
+
+var log = logging.ServiceLogger{Package: "registry"}
+
+/*
+Config configuration of the consul registration
+*/
+type Config struct {
+	// URL of the consul agent
+	URL string
+	// Token consul acl token
+	Token string
+	// ServiceName name of the service in consul
+	ServiceName string
+	// ServiceID unique id of this instance, if empty it's generated from name, system id, host and port
+	ServiceID string
+	// ServiceURL the url how to connect to this service from outside
+	ServiceURL string
+	// HealthPath path of the health endpoint for http checks
+	HealthPath string
+	// Tags additional tags of the service
+	Tags []string
+	// Meta additional meta data, like version or system id
+	Meta map[string]string
+	// Check type of the health check, http or ttl
+	Check string
+	// Interval of the http check, the ttl and the re-registration
+	Interval time.Duration
+	// DeregisterAfter consul removes the service after the check is critical for this time, 0 is never
+	DeregisterAfter time.Duration
+	// Healthy used for the ttl check
+	Healthy func() (bool, string)
+}
+
+/*
+Registry registration of this service instance in consul. A background loop re-registers the service,
+if consul lost the registration, e.g. after a restart of the agent, and updates the ttl check.
+*/
+type Registry struct {
+	config       Config
+	agent        *consulApi.Agent
+	registration *consulApi.AgentServiceRegistration
+
+	mu         sync.Mutex
+	registered bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+/*
+New creates a new consul registry for the config
+*/
+func New(cfg Config) (*Registry, error) {
+	if cfg.ServiceName == "" {
+		return nil, errors.New("service name not set")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.HealthPath == "" {
+		cfg.HealthPath = defaultHealthPath
+	}
+	switch cfg.Check {
+	case "":
+		cfg.Check = CheckHTTP
+	case CheckHTTP, CheckTTL:
+	default:
+		return nil, fmt.Errorf("unknown check type: %s", cfg.Check)
+	}
+	if cfg.Check == CheckTTL && cfg.Healthy == nil {
+		return nil, errors.New("ttl check needs a health function")
+	}
+
+	client, err := NewClient(cfg.URL, cfg.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	registration, err := newRegistration(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		config:       cfg,
+		agent:        client.Agent(),
+		registration: registration,
+	}, nil
+}
+
+/*
+NewClient creates a consul client for the registry url and the acl token
+*/
+func NewClient(registryURL string, token string) (*consulApi.Client, error) {
+	consulURL, err := url.Parse(registryURL)
+	if err != nil {
+		return nil, fmt.Errorf("wrong registry url: %s", err.Error())
+	}
+	if consulURL.Host == "" {
+		return nil, fmt.Errorf("wrong registry url: %s", registryURL)
+	}
+	consulConfig := consulApi.DefaultConfig()
+	consulConfig.Scheme = consulURL.Scheme
+	consulConfig.Address = consulURL.Host
+	consulConfig.Token = token
+	client, err := consulApi.NewClient(consulConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can't create consul client: %s", err.Error())
+	}
+	return client, nil
+}
+
+func newRegistration(cfg Config) (*consulApi.AgentServiceRegistration, error) {
+	serviceURL, err := url.Parse(cfg.ServiceURL)
+	if err != nil {
+		return nil, fmt.Errorf("wrong service url: %s", err.Error())
+	}
+	address := serviceURL.Hostname()
+	if address == "" {
+		return nil, fmt.Errorf("wrong service url: %s", cfg.ServiceURL)
+	}
+	port, err := urlPort(serviceURL)
+	if err != nil {
+		return nil, err
+	}
+	id := cfg.ServiceID
+	if id == "" {
+		id = generateID(cfg.ServiceName, cfg.Meta["system_id"], port)
+	}
+
+	check := &consulApi.AgentServiceCheck{
+		CheckID: "service:" + id,
+		Name:    cfg.ServiceName + " health",
+	}
+	if cfg.Check == CheckTTL {
+		check.TTL = (cfg.Interval * 2).String()
+	} else {
+		check.HTTP = strings.TrimSuffix(cfg.ServiceURL, "/") + cfg.HealthPath
+		check.Interval = cfg.Interval.String()
+		check.Timeout = (time.Second * 10).String()
+		check.TLSSkipVerify = true
+	}
+	if cfg.DeregisterAfter > 0 {
+		check.DeregisterCriticalServiceAfter = cfg.DeregisterAfter.String()
+	}
+
+	return &consulApi.AgentServiceRegistration{
+		ID:      id,
+		Name:    cfg.ServiceName,
+		Address: address,
+		Port:    port,
+		Tags:    cfg.Tags,
+		Meta:    cfg.Meta,
+		Check:   check,
+	}, nil
+}
+
+func urlPort(u *url.URL) (int, error) {
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "https":
+			return 443, nil
+		case "http":
+			return 80, nil
+		}
+		return 0, fmt.Errorf("no port in service url: %s", u.String())
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return 0, fmt.Errorf("wrong port in service url: %s", err.Error())
+	}
+	return port, nil
+}
+
+/*
+generateID a stable id for this instance, so restarts of the same instance are not creating new services
+*/
+func generateID(name string, systemID string, port int) string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	parts := []string{name}
+	if systemID != "" && systemID != name {
+		parts = append(parts, systemID)
+	}
+	parts = append(parts, hostname, strconv.Itoa(port))
+	return strings.Join(parts, "-")
+}
+
+/*
+ServiceID the id of this service instance in consul
+*/
+func (r *Registry) ServiceID() string {
+	return r.registration.ID
+}
+
+/*
+Start registers the service and starts the background loop. A failing registration is no error, the loop will retry.
+*/
+func (r *Registry) Start() {
+	if err := r.register(); err != nil {
+		log.Alertf("can't register to consul, will retry: %s", err.Error())
+	}
+	r.stop = make(chan struct{})
+	r.stopped = make(chan struct{})
+	go r.run()
+}
+
+func (r *Registry) run() {
+	defer close(r.stopped)
+	if r.config.Check == CheckTTL {
+		// the ttl is twice the interval, so updating it in every interval is enough
+		r.updateTTL()
+	}
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sync()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+/*
+sync re-registers the service, if consul doesn't know it anymore, and updates the ttl check
+*/
+func (r *Registry) sync() {
+	services, err := r.agent.Services()
+	if err != nil {
+		log.Alertf("consul not reachable: %s", err.Error())
+		r.setRegistered(false)
+		return
+	}
+	if _, ok := services[r.registration.ID]; !ok {
+		if r.isRegistered() {
+			log.Alertf("service %s not registered in consul anymore", r.registration.ID)
+		}
+		if err := r.register(); err != nil {
+			log.Alertf("can't register to consul: %s", err.Error())
+			return
+		}
+	}
+	if r.config.Check == CheckTTL {
+		r.updateTTL()
+	}
+}
+
+func (r *Registry) register() error {
+	if err := r.agent.ServiceRegister(r.registration); err != nil {
+		r.setRegistered(false)
+		return err
+	}
+	r.setRegistered(true)
+	log.Infof("registered in consul as %s (%s:%d)", r.registration.ID, r.registration.Address, r.registration.Port)
+	return nil
+}
+
+func (r *Registry) updateTTL() {
+	status := consulApi.HealthPassing
+	healthy, msg := r.config.Healthy()
+	if !healthy {
+		status = consulApi.HealthCritical
+	}
+	if err := r.agent.UpdateTTL(r.registration.Check.CheckID, msg, status); err != nil {
+		log.Alertf("can't update ttl check: %s", err.Error())
+	}
+}
+
+func (r *Registry) setRegistered(registered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.registered = registered
+}
+
+func (r *Registry) isRegistered() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.registered
+}
+
+/*
+Stop stopping the background loop and deregistering the service
+*/
+func (r *Registry) Stop() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.stopped
+		r.stop = nil
+	}
+	if err := r.agent.ServiceDeregister(r.registration.ID); err != nil {
+		return err
+	}
+	r.setRegistered(false)
+	log.Infof("deregistered %s from consul", r.registration.ID)
+	return nil
+}
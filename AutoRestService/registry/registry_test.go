@@ -0,0 +1,207 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+/*
+fakeConsul the parts of the consul agent api used by the registry
+*/
+type fakeConsul struct {
+	mu       sync.Mutex
+	services map[string]consulApi.AgentServiceRegistration
+	ttl      map[string]string
+	tokens   []string
+	down     bool
+}
+
+func newFakeConsul(t *testing.T) (*fakeConsul, string) {
+	f := &fakeConsul{services: make(map[string]consulApi.AgentServiceRegistration), ttl: make(map[string]string)}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server.URL
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/agent/service/register":
+		var reg consulApi.AgentServiceRegistration
+		json.NewDecoder(r.Body).Decode(&reg)
+		f.services[reg.ID] = reg
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/agent/services":
+		services := make(map[string]*consulApi.AgentService)
+		for id, reg := range f.services {
+			services[id] = &consulApi.AgentService{ID: id, Service: reg.Name}
+		}
+		json.NewEncoder(w).Encode(services)
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+		var update struct{ Status string }
+		json.NewDecoder(r.Body).Decode(&update)
+		f.ttl[strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")] = update.Status
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsul) service(id string) (consulApi.AgentServiceRegistration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reg, ok := f.services[id]
+	return reg, ok
+}
+
+func testConfig(url string) Config {
+	return Config{
+		URL:         url,
+		Token:       "secret-token",
+		ServiceName: "autorest",
+		ServiceID:   "autorest-1",
+		ServiceURL:  "https://10.0.0.1:9443",
+		Tags:        []string{"v1", "v2"},
+		Meta:        map[string]string{"version": "1.0", "system_id": "sys"},
+		Interval:    time.Hour,
+	}
+}
+
+func TestRegister(t *testing.T) {
+	consul, url := newFakeConsul(t)
+	r, err := New(testConfig(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	reg, ok := consul.service("autorest-1")
+	if !ok {
+		t.Fatal("service not registered")
+	}
+	if reg.Name != "autorest" || reg.Address != "10.0.0.1" || reg.Port != 9443 {
+		t.Errorf("wrong registration: %+v", reg)
+	}
+	if len(reg.Tags) != 2 || reg.Meta["version"] != "1.0" {
+		t.Errorf("tags or meta missing: %+v", reg)
+	}
+	if reg.Check == nil || reg.Check.HTTP != "https://10.0.0.1:9443/health/health" {
+		t.Errorf("wrong check: %+v", reg.Check)
+	}
+	if consul.tokens[0] != "secret-token" {
+		t.Errorf("acl token not sent: %v", consul.tokens)
+	}
+
+	// consul lost the registration, e.g. after a restart
+	consul.mu.Lock()
+	delete(consul.services, "autorest-1")
+	consul.mu.Unlock()
+	r.sync()
+	if _, ok := consul.service("autorest-1"); !ok {
+		t.Error("service not registered again")
+	}
+
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := consul.service("autorest-1"); ok {
+		t.Error("service not deregistered")
+	}
+}
+
+func TestConsulDown(t *testing.T) {
+	consul, url := newFakeConsul(t)
+	consul.down = true
+	r, err := New(testConfig(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a failing registration is retried by the loop
+	r.Start()
+	defer r.Stop()
+	if r.isRegistered() {
+		t.Error("registered while consul is down")
+	}
+	consul.mu.Lock()
+	consul.down = false
+	consul.mu.Unlock()
+	r.sync()
+	if !r.isRegistered() {
+		t.Error("not registered after consul is up again")
+	}
+}
+
+func TestTTLCheck(t *testing.T) {
+	consul, url := newFakeConsul(t)
+	cfg := testConfig(url)
+	cfg.Check = CheckTTL
+	healthy := true
+	var mu sync.Mutex
+	cfg.Healthy = func() (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		return healthy, ""
+	}
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	defer r.Stop()
+	reg, _ := consul.service("autorest-1")
+	if reg.Check.TTL != (2*time.Hour).String() || reg.Check.HTTP != "" {
+		t.Errorf("wrong ttl check: %+v", reg.Check)
+	}
+	mu.Lock()
+	healthy = false
+	mu.Unlock()
+	r.sync()
+	consul.mu.Lock()
+	status := consul.ttl["service:autorest-1"]
+	consul.mu.Unlock()
+	if status != consulApi.HealthCritical {
+		t.Errorf("ttl status %s, want critical", status)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []func(c *Config){
+		func(c *Config) { c.ServiceName = "" },
+		func(c *Config) { c.Check = "tcp" },
+		func(c *Config) { c.Check = CheckTTL },
+		func(c *Config) { c.URL = "::wrong" },
+		func(c *Config) { c.ServiceURL = "ftp://host" },
+		func(c *Config) { c.ServiceURL = "http://:80" },
+	}
+	for i, change := range tests {
+		cfg := testConfig("http://127.0.0.1:8500")
+		change(&cfg)
+		if _, err := New(cfg); err == nil {
+			t.Errorf("test %d: no error", i)
+		}
+	}
+}
+
+func TestGeneratedID(t *testing.T) {
+	cfg := testConfig("http://127.0.0.1:8500")
+	cfg.ServiceID = ""
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := r.ServiceID(); !strings.HasPrefix(id, "autorest-sys-") || !strings.HasSuffix(id, "-9443") {
+		t.Errorf("wrong generated id: %s", id)
+	}
+}
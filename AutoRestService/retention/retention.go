@@ -0,0 +1,376 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+var log = logging.ServiceLogger{Package: "retention"}
+
+// reasons a document is expired
+const (
+	ReasonAge   = "age"
+	ReasonCount = "count"
+	ReasonTTL   = "ttl"
+)
+
+/*
+Policy retention policy of a model. All rules are optional, documents are deleted if any rule matches.
+*/
+type Policy struct {
+	// MaxAge seconds a document is kept, measured by Field
+	MaxAge int `json:"maxAge,omitempty"`
+	// Field the timestamp field for MaxAge and MaxCount, empty is the creation time of the document
+	Field string `json:"field,omitempty"`
+	// MaxCount max number of documents per device, the oldest documents are deleted
+	MaxCount int `json:"maxCount,omitempty"`
+	// DeviceField the field with the device of a document, needed for MaxCount
+	DeviceField string `json:"deviceField,omitempty"`
+	// TTLField the field with the expiry time of a single document
+	TTLField string `json:"ttlField,omitempty"`
+}
+
+/*
+Validate checking the rules of the policy
+*/
+func (p Policy) Validate() error {
+	if p.MaxAge < 0 {
+		return fmt.Errorf("maxAge must not be negative")
+	}
+	if p.MaxCount < 0 {
+		return fmt.Errorf("maxCount must not be negative")
+	}
+	if p.MaxCount > 0 && p.DeviceField == "" {
+		return fmt.Errorf("maxCount needs the deviceField")
+	}
+	if p.MaxAge == 0 && p.MaxCount == 0 && p.TTLField == "" {
+		return fmt.Errorf("no rule set")
+	}
+	return nil
+}
+
+/*
+Model a model of a tenant with documents
+*/
+type Model struct {
+	Tenant string
+	Name   string
+}
+
+/*
+Store deleting the expired documents, implemented by the storage. The deletes should use the indexes of
+the fields and delete at most limit documents, so a single step doesn't block the storage for long.
+*/
+type Store interface {
+	// Models all models with documents
+	Models() ([]Model, error)
+	// DeleteBefore deleting documents of the model, whose field is before the time. An empty field is the creation time.
+	DeleteBefore(model Model, field string, before time.Time, limit int) (int, error)
+	// DeleteExceeding deleting the oldest documents by field of every device, which has more than max documents
+	DeleteExceeding(model Model, deviceField string, field string, max int, limit int) (int, error)
+}
+
+/*
+Config configuration of the expiry worker
+*/
+type Config struct {
+	Interval int
+	Batch    int
+	Policies map[string]Policy
+}
+
+/*
+ModelStats the deleted documents of a model by reason
+*/
+type ModelStats struct {
+	Age   uint64 `json:"age"`
+	Count uint64 `json:"count"`
+	TTL   uint64 `json:"ttl"`
+}
+
+/*
+Stats the metrics of the expiry worker
+*/
+type Stats struct {
+	Runs         uint64                `json:"runs"`
+	Errors       uint64                `json:"errors"`
+	Deleted      uint64                `json:"deleted"`
+	LastRun      *time.Time            `json:"lastRun,omitempty"`
+	LastDuration string                `json:"lastDuration,omitempty"`
+	LastDeleted  uint64                `json:"lastDeleted"`
+	LastError    string                `json:"lastError,omitempty"`
+	Models       map[string]ModelStats `json:"models"`
+}
+
+/*
+Result the deleted documents of a single run
+*/
+type Result struct {
+	Deleted  uint64                `json:"deleted"`
+	Duration string                `json:"duration"`
+	Models   map[string]ModelStats `json:"models"`
+}
+
+var (
+	mu       sync.Mutex
+	store    Store
+	cfg      Config
+	policies = make(map[string]Policy)
+	stats    = Stats{Models: make(map[string]ModelStats)}
+	stop     chan struct{}
+
+	// only one run at a time, the scheduled run and a run started by the api
+	running sync.Mutex
+)
+
+/*
+SetStore setting the storage of the documents
+*/
+func SetStore(s Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	store = s
+}
+
+/*
+Register setting the policy of a model definition, this overrides the configured policy of the model.
+An empty policy removes it.
+*/
+func Register(model string, policy Policy) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if policy == (Policy{}) {
+		delete(policies, model)
+		return nil
+	}
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("wrong retention policy of model %s: %s", model, err.Error())
+	}
+	policies[model] = policy
+	return nil
+}
+
+/*
+GetPolicy the policy of a model, first of the model definition, then of the config
+*/
+func GetPolicy(model string) (Policy, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	return policy(model)
+}
+
+func policy(model string) (Policy, bool) {
+	if p, ok := policies[model]; ok {
+		return p, true
+	}
+	p, ok := cfg.Policies[model]
+	return p, ok
+}
+
+/*
+Schedule running the expiry worker every interval seconds with the config. A running schedule is replaced,
+an interval of 0 stops the schedule.
+*/
+func Schedule(config Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg = config
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+	if config.Interval <= 0 {
+		return
+	}
+	s := make(chan struct{})
+	stop = s
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s:
+				return
+			case <-ticker.C:
+			}
+			if !hasStore() {
+				continue
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case <-s:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			if _, err := Run(ctx); err != nil {
+				log.Alertf("retention run failed: %s", err.Error())
+			}
+			cancel()
+		}
+	}()
+}
+
+/*
+StopSchedule stopping the expiry worker, a running run is cancelled
+*/
+func StopSchedule() {
+	mu.Lock()
+	c := cfg
+	mu.Unlock()
+	c.Interval = 0
+	Schedule(c)
+}
+
+func hasStore() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return store != nil
+}
+
+/*
+GetStats getting the metrics of the expiry worker
+*/
+func GetStats() Stats {
+	mu.Lock()
+	defer mu.Unlock()
+	s := stats
+	s.Models = make(map[string]ModelStats, len(stats.Models))
+	for k, v := range stats.Models {
+		s.Models[k] = v
+	}
+	return s
+}
+
+/*
+Run deleting all expired documents of all models with a policy. The documents are deleted in steps of
+the batch size, until nothing is left or the context is done.
+*/
+func Run(ctx context.Context) (Result, error) {
+	running.Lock()
+	defer running.Unlock()
+	mu.Lock()
+	s := store
+	batch := cfg.Batch
+	mu.Unlock()
+	if s == nil {
+		return Result{}, fmt.Errorf("no retention store registered")
+	}
+	if batch <= 0 {
+		batch = 1000
+	}
+	start := time.Now()
+	result := Result{Models: make(map[string]ModelStats)}
+	models, err := s.Models()
+	if err == nil {
+		sort.Slice(models, func(i, j int) bool {
+			if models[i].Tenant != models[j].Tenant {
+				return models[i].Tenant < models[j].Tenant
+			}
+			return models[i].Name < models[j].Name
+		})
+		for _, model := range models {
+			if err = expire(ctx, s, model, batch, &result); err != nil {
+				err = fmt.Errorf("can't expire model %s of tenant %s: %s", model.Name, model.Tenant, err.Error())
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	result.Duration = time.Since(start).String()
+	record(start, result, err)
+	if result.Deleted > 0 {
+		log.Infof("retention deleted %d documents in %s", result.Deleted, result.Duration)
+	}
+	return result, err
+}
+
+func expire(ctx context.Context, s Store, model Model, batch int, result *Result) error {
+	mu.Lock()
+	p, ok := policy(model.Name)
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	ms := result.Models[model.Name]
+	defer func() {
+		result.Models[model.Name] = ms
+		result.Deleted = 0
+		for _, m := range result.Models {
+			result.Deleted += m.Age + m.Count + m.TTL
+		}
+	}()
+	if p.TTLField != "" {
+		if err := steps(ctx, batch, &ms.TTL, func() (int, error) {
+			return s.DeleteBefore(model, p.TTLField, now, batch)
+		}); err != nil {
+			return err
+		}
+	}
+	if p.MaxAge > 0 {
+		before := now.Add(-time.Duration(p.MaxAge) * time.Second)
+		if err := steps(ctx, batch, &ms.Age, func() (int, error) {
+			return s.DeleteBefore(model, p.Field, before, batch)
+		}); err != nil {
+			return err
+		}
+	}
+	if p.MaxCount > 0 {
+		if err := steps(ctx, batch, &ms.Count, func() (int, error) {
+			return s.DeleteExceeding(model, p.DeviceField, p.Field, p.MaxCount, batch)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+steps deleting until a step deletes less than the batch size
+*/
+func steps(ctx context.Context, batch int, count *uint64, step func() (int, error)) error {
+	for ctx.Err() == nil {
+		n, err := step()
+		if n > 0 {
+			*count += uint64(n)
+		}
+		if err != nil {
+			return err
+		}
+		if n < batch {
+			return nil
+		}
+	}
+	return nil
+}
+
+func record(start time.Time, result Result, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	stats.Runs++
+	stats.LastRun = &start
+	stats.LastDuration = result.Duration
+	stats.LastDeleted = result.Deleted
+	stats.Deleted += result.Deleted
+	stats.LastError = ""
+	if err != nil {
+		stats.Errors++
+		stats.LastError = err.Error()
+	}
+	for name, m := range result.Models {
+		total := stats.Models[name]
+		total.Age += m.Age
+		total.Count += m.Count
+		total.TTL += m.TTL
+		stats.Models[name] = total
+	}
+}
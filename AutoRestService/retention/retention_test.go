@@ -0,0 +1,178 @@
+package retention
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+/*
+fakeDoc a document of the fake store with its times by field and its device
+*/
+type fakeDoc struct {
+	device string
+	times  map[string]time.Time
+}
+
+/*
+fakeStore the documents by model name of a single tenant, counting the delete calls
+*/
+type fakeStore struct {
+	docs  map[string][]fakeDoc
+	calls int
+}
+
+func (s *fakeStore) Models() ([]Model, error) {
+	list := make([]Model, 0)
+	for name := range s.docs {
+		list = append(list, Model{Tenant: "tenant", Name: name})
+	}
+	return list, nil
+}
+
+func (s *fakeStore) DeleteBefore(model Model, field string, before time.Time, limit int) (int, error) {
+	s.calls++
+	if field == "" {
+		field = "created"
+	}
+	kept := make([]fakeDoc, 0)
+	n := 0
+	for _, doc := range s.docs[model.Name] {
+		t, ok := doc.times[field]
+		if ok && t.Before(before) && n < limit {
+			n++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	s.docs[model.Name] = kept
+	return n, nil
+}
+
+func (s *fakeStore) DeleteExceeding(model Model, deviceField string, field string, max int, limit int) (int, error) {
+	s.calls++
+	if field == "" {
+		field = "created"
+	}
+	docs := s.docs[model.Name]
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].times[field].Before(docs[j].times[field])
+	})
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		counts[doc.device]++
+	}
+	kept := make([]fakeDoc, 0)
+	n := 0
+	for _, doc := range docs {
+		if counts[doc.device] > max && n < limit {
+			counts[doc.device]--
+			n++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	s.docs[model.Name] = kept
+	return n, nil
+}
+
+func docs(n int, device string, field string, t time.Time) []fakeDoc {
+	list := make([]fakeDoc, n)
+	for i := range list {
+		list[i] = fakeDoc{device: device, times: map[string]time.Time{field: t.Add(time.Duration(i) * time.Second)}}
+	}
+	return list
+}
+
+func startTest(t *testing.T, store Store, config Config) {
+	t.Helper()
+	SetStore(store)
+	Schedule(config)
+	t.Cleanup(func() {
+		SetStore(nil)
+		Schedule(Config{})
+		mu.Lock()
+		policies = make(map[string]Policy)
+		stats = Stats{Models: make(map[string]ModelStats)}
+		mu.Unlock()
+	})
+}
+
+func TestRun(t *testing.T) {
+	now := time.Now()
+	store := &fakeStore{docs: map[string][]fakeDoc{
+		"telemetry": append(docs(5, "a", "ts", now.Add(-2*time.Hour)), docs(1, "a", "ts", now)...),
+		"events":    append(docs(3, "a", "expires", now.Add(-time.Minute)), docs(2, "a", "expires", now.Add(time.Hour))...),
+		"readings":  append(docs(4, "a", "created", now), docs(1, "b", "created", now)...),
+		"other":     docs(3, "a", "created", now.Add(-24*time.Hour)),
+	}}
+	startTest(t, store, Config{Batch: 2, Policies: map[string]Policy{
+		"telemetry": {MaxAge: 3600, Field: "ts"},
+		"events":    {TTLField: "expires"},
+		"readings":  {MaxCount: 2, DeviceField: "device"},
+	}})
+	result, err := Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 10 || result.Models["telemetry"].Age != 5 || result.Models["events"].TTL != 3 || result.Models["readings"].Count != 2 {
+		t.Errorf("wrong result: %+v", result)
+	}
+	// the steps of the batch size: telemetry 2+2+1, events 2+1, readings 2+0
+	if store.calls != 7 {
+		t.Errorf("%d delete steps", store.calls)
+	}
+	if len(store.docs["telemetry"]) != 1 || len(store.docs["events"]) != 2 || len(store.docs["readings"]) != 3 || len(store.docs["other"]) != 3 {
+		t.Errorf("wrong documents kept: %+v", store.docs)
+	}
+	for _, doc := range store.docs["readings"] {
+		if doc.device == "a" && doc.times["created"].Before(now.Add(2*time.Second)) {
+			t.Error("newest documents of the device deleted")
+		}
+	}
+	stats := GetStats()
+	if stats.Runs != 1 || stats.Deleted != 10 || stats.Models["telemetry"].Age != 5 || stats.LastRun == nil {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	store := &fakeStore{docs: map[string][]fakeDoc{"telemetry": docs(3, "a", "created", time.Now().Add(-2*time.Hour))}}
+	startTest(t, store, Config{Batch: 10, Policies: map[string]Policy{"telemetry": {MaxAge: 86400}}})
+	if err := Register("telemetry", Policy{MaxCount: 1}); err == nil {
+		t.Error("policy without device field registered")
+	}
+	// the policy of the model definition overrides the config
+	if err := Register("telemetry", Policy{MaxAge: 3600}); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := GetPolicy("telemetry"); p.MaxAge != 3600 {
+		t.Errorf("wrong policy %+v", p)
+	}
+	result, _ := Run(context.Background())
+	if result.Deleted != 3 {
+		t.Errorf("%d deleted", result.Deleted)
+	}
+	Register("telemetry", Policy{})
+	if p, _ := GetPolicy("telemetry"); p.MaxAge != 86400 {
+		t.Errorf("registered policy not removed: %+v", p)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := Run(context.Background()); err == nil {
+		t.Error("run without store")
+	}
+	store := &fakeStore{docs: map[string][]fakeDoc{"telemetry": docs(5, "a", "created", time.Now().Add(-2*time.Hour))}}
+	startTest(t, store, Config{Batch: 1, Policies: map[string]Policy{"telemetry": {MaxAge: 3600}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := Run(ctx)
+	if err != context.Canceled || result.Deleted != 0 {
+		t.Errorf("cancelled run: %+v, %v", result, err)
+	}
+	if stats := GetStats(); stats.Errors != 1 || stats.LastError == "" {
+		t.Errorf("error not recorded: %+v", stats)
+	}
+}
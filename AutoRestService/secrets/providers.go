@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvPrefix prefix of the environment variables of the env provider
+const EnvPrefix = "AUTOREST_SECRET_"
+
+/*
+EnvProvider secrets from environment variables, mongodb/password is read from AUTOREST_SECRET_MONGODB_PASSWORD
+*/
+type EnvProvider struct {
+	Prefix string
+}
+
+/*
+Get the secret from the environment
+*/
+func (p *EnvProvider) Get(path string) (string, bool, error) {
+	prefix := p.Prefix
+	if prefix == "" {
+		prefix = EnvPrefix
+	}
+	name := prefix + strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(path))
+	value, ok := os.LookupEnv(name)
+	return value, ok, nil
+}
+
+/*
+DirProvider secrets from files in a directory, like docker or kubernetes mounted secrets.
+mongodb/password is read from {dir}/mongodb/password or {dir}/mongodb_password.
+*/
+type DirProvider struct {
+	Dir string
+}
+
+/*
+Get the secret from the file, a trailing line break is removed
+*/
+func (p *DirProvider) Get(path string) (string, bool, error) {
+	names := []string{
+		filepath.Join(p.Dir, filepath.FromSlash(path)),
+		filepath.Join(p.Dir, strings.ReplaceAll(path, "/", "_")),
+	}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("can't read secret file: %s", err.Error())
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	return "", false, nil
+}
+
+/*
+MapProvider secrets from a nested map, e.g. the content of the secret file. mongodb/password is the key password of the map mongodb.
+*/
+type MapProvider struct {
+	Data map[string]interface{}
+}
+
+/*
+Get the secret from the map, numbers and booleans are converted to strings
+*/
+func (p *MapProvider) Get(path string) (string, bool, error) {
+	var node interface{} = p.Data
+	for _, segment := range strings.Split(path, "/") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return "", false, nil
+		}
+		node, ok = m[segment]
+		if !ok {
+			return "", false, nil
+		}
+	}
+	switch value := node.(type) {
+	case nil:
+		return "", false, nil
+	case map[string]interface{}, []interface{}:
+		return "", false, fmt.Errorf("secret %s is not a value", path)
+	default:
+		return fmt.Sprintf("%v", value), true, nil
+	}
+}
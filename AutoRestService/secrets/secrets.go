@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// provider types
+const (
+	TypeFile  = "file"
+	TypeEnv   = "env"
+	TypeDir   = "dir"
+	TypeVault = "vault"
+)
+
+var reference = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+/*
+Provider a source of secrets, the path of a secret is like mongodb/password
+*/
+type Provider interface {
+	// Get returns the secret, found is false if the provider doesn't know the path
+	Get(path string) (value string, found bool, err error)
+}
+
+/*
+Resolver resolving ${secret:path} references with a list of providers, the first provider knowing the path wins
+*/
+type Resolver struct {
+	providers []Provider
+	resolved  []string
+}
+
+/*
+NewResolver creates a resolver for the providers
+*/
+func NewResolver(providers ...Provider) *Resolver {
+	return &Resolver{
+		providers: providers,
+		resolved:  make([]string, 0),
+	}
+}
+
+/*
+HasReference checks if the value contains a secret reference
+*/
+func HasReference(value string) bool {
+	return reference.MatchString(value)
+}
+
+/*
+Get getting a single secret from the providers
+*/
+func (r *Resolver) Get(path string) (string, error) {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	for _, p := range r.providers {
+		value, found, err := p.Get(path)
+		if err != nil {
+			return "", fmt.Errorf("can't get secret %s: %s", path, err.Error())
+		}
+		if found {
+			r.resolved = append(r.resolved, value)
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("secret %s not found", path)
+}
+
+/*
+Resolve replacing all secret references in the value
+*/
+func (r *Resolver) Resolve(value string) (string, error) {
+	var err error
+	result := reference.ReplaceAllStringFunc(value, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		var secret string
+		secret, err = r.Get(reference.FindStringSubmatch(ref)[1])
+		return secret
+	})
+	if err != nil {
+		return value, err
+	}
+	return result, nil
+}
+
+/*
+Resolved all secret values resolved by this resolver, used for redacting them
+*/
+func (r *Resolver) Resolved() []string {
+	list := make([]string, len(r.resolved))
+	copy(list, r.resolved)
+	return list
+}
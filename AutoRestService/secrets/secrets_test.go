@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type failingProvider struct{}
+
+func (p *failingProvider) Get(path string) (string, bool, error) {
+	return "", false, errors.New("not reachable")
+}
+
+func TestResolver(t *testing.T) {
+	t.Setenv("AUTOREST_SECRET_MONGODB_PASSWORD", "from-env")
+	t.Setenv("AUTOREST_SECRET_GELF_TOKEN", "gelf")
+	r := NewResolver(
+		&MapProvider{Data: map[string]interface{}{"mongodb": map[string]interface{}{"password": "from-file", "port": 27017}}},
+		&EnvProvider{},
+	)
+	value, err := r.Resolve("mongodb://admin:${secret:mongodb/password}@db:${secret: /mongodb/port/ }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "mongodb://admin:from-file@db:27017" {
+		t.Errorf("wrong value: %s", value)
+	}
+	if value, _ := r.Resolve("${secret:gelf/token}"); value != "gelf" {
+		t.Errorf("second provider not used: %s", value)
+	}
+	if resolved := r.Resolved(); len(resolved) != 3 || resolved[0] != "from-file" {
+		t.Errorf("wrong resolved values: %v", resolved)
+	}
+	if _, err := r.Resolve("${secret:unknown}"); err == nil {
+		t.Error("unknown secret resolved")
+	}
+	if value, _ := r.Resolve("no reference"); value != "no reference" || HasReference(value) {
+		t.Error("value without reference changed")
+	}
+	failing := NewResolver(&failingProvider{}, &EnvProvider{})
+	if _, err := failing.Get("gelf/token"); err == nil {
+		t.Error("provider error not reported")
+	}
+}
+
+func TestDirProvider(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "mongodb"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "mongodb", "password"), []byte("nested\r\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "gelf_token"), []byte("flat\n"), 0600)
+	p := &DirProvider{Dir: dir}
+	if value, found, err := p.Get("mongodb/password"); err != nil || !found || value != "nested" {
+		t.Errorf("got %q %t %v", value, found, err)
+	}
+	if value, found, _ := p.Get("gelf/token"); !found || value != "flat" {
+		t.Errorf("flat file not found: %q", value)
+	}
+	if _, found, err := p.Get("redis/password"); found || err != nil {
+		t.Errorf("unknown secret: %t %v", found, err)
+	}
+}
+
+func TestEnvProviderPrefix(t *testing.T) {
+	t.Setenv("MY_DB_USER_NAME", "admin")
+	p := &EnvProvider{Prefix: "MY_"}
+	if value, found, _ := p.Get("db/user-name"); !found || value != "admin" {
+		t.Errorf("got %q %t", value, found)
+	}
+}
@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/tracing"
+)
+
+const (
+	defaultVaultMount = "secret"
+	vaultTokenEnv     = "VAULT_TOKEN"
+	vaultTokenHeader  = "X-Vault-Token"
+)
+
+/*
+VaultConfig configuration of a vault compatible kv secret store
+*/
+type VaultConfig struct {
+	// URL of the vault server, like http://vault:8200
+	URL string
+	// Mount of the kv secret engine, default is secret
+	Mount string
+	// KVVersion version of the kv engine, 1 or 2, default is 2
+	KVVersion int
+	// Token vault token, default is the environment variable VAULT_TOKEN
+	Token string
+	// TokenFile file containing the token, e.g. a mounted kubernetes secret
+	TokenFile string
+}
+
+/*
+VaultProvider secrets from the kv engine of vault. mongodb/password is the key password of the secret mongodb,
+a path without a key is using the key value. Every secret is read only once per provider.
+*/
+type VaultProvider struct {
+	config VaultConfig
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]map[string]interface{}
+}
+
+/*
+NewVaultProvider creates a vault provider
+*/
+func NewVaultProvider(cfg VaultConfig) (*VaultProvider, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("vault url not set")
+	}
+	if cfg.Mount == "" {
+		cfg.Mount = defaultVaultMount
+	}
+	if cfg.KVVersion == 0 {
+		cfg.KVVersion = 2
+	}
+	if cfg.KVVersion != 1 && cfg.KVVersion != 2 {
+		return nil, fmt.Errorf("unknown kv version: %d", cfg.KVVersion)
+	}
+	if cfg.Token == "" && cfg.TokenFile != "" {
+		data, err := ioutil.ReadFile(cfg.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read vault token file: %s", err.Error())
+		}
+		cfg.Token = strings.TrimSpace(string(data))
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv(vaultTokenEnv)
+	}
+	return &VaultProvider{
+		config: cfg,
+		client: &http.Client{
+			Transport: tracing.Transport(nil),
+			Timeout:   10 * time.Second,
+		},
+		cache: make(map[string]map[string]interface{}),
+	}, nil
+}
+
+/*
+Get the secret from vault
+*/
+func (p *VaultProvider) Get(path string) (string, bool, error) {
+	secret, key := path, "value"
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		secret, key = path[:i], path[i+1:]
+	}
+	p.mu.Lock()
+	data, ok := p.cache[secret]
+	p.mu.Unlock()
+	if !ok {
+		var err error
+		data, err = p.read(secret)
+		if err != nil {
+			return "", false, err
+		}
+		p.mu.Lock()
+		p.cache[secret] = data
+		p.mu.Unlock()
+	}
+	value, ok := data[key]
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	return fmt.Sprintf("%v", value), true, nil
+}
+
+func (p *VaultProvider) read(secret string) (map[string]interface{}, error) {
+	url := strings.TrimSuffix(p.config.URL, "/") + "/v1/" + strings.Trim(p.config.Mount, "/") + "/"
+	if p.config.KVVersion == 2 {
+		url += "data/"
+	}
+	url += secret
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.config.Token != "" {
+		req.Header.Set(vaultTokenHeader, p.config.Token)
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to vault: %s", err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return map[string]interface{}{}, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s", res.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("can't parse vault response: %s", err.Error())
+	}
+	if p.config.KVVersion == 1 {
+		return body.Data, nil
+	}
+	// kv version 2 has the secret in data.data, beside the metadata
+	data, _ := body.Data["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return data, nil
+}
@@ -0,0 +1,135 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+vaultStub a kv engine with the secret mongodb, counting the requests
+*/
+func vaultStub(t *testing.T, kvVersion int) (string, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get(vaultTokenHeader) != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/mongodb":
+			if kvVersion == 2 {
+				w.Write([]byte(`{"data":{"data":{"password":"geheim","port":27017},"metadata":{"version":3}}}`))
+				return
+			}
+		case "/v1/kv/mongodb":
+			if kvVersion == 1 {
+				w.Write([]byte(`{"data":{"password":"geheim1"}}`))
+				return
+			}
+		case "/v1/secret/data/broken":
+			w.Write([]byte(`{"data":`))
+			return
+		case "/v1/secret/data/failing":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, &requests
+}
+
+func TestVaultKV2(t *testing.T) {
+	url, requests := vaultStub(t, 2)
+	p, err := NewVaultProvider(VaultConfig{URL: url, Token: "vault-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := p.Get("mongodb/password")
+	if err != nil || !found || value != "geheim" {
+		t.Errorf("got %q %t %v", value, found, err)
+	}
+	if value, _, _ := p.Get("mongodb/port"); value != "27017" {
+		t.Errorf("number not converted: %s", value)
+	}
+	if _, found, _ := p.Get("mongodb/user"); found {
+		t.Error("unknown key found")
+	}
+	if *requests != 1 {
+		t.Errorf("secret read %d times, want once", *requests)
+	}
+	if _, found, err := p.Get("redis/password"); found || err != nil {
+		t.Errorf("unknown secret: %t %v", found, err)
+	}
+}
+
+func TestVaultKV1(t *testing.T) {
+	url, _ := vaultStub(t, 1)
+	p, err := NewVaultProvider(VaultConfig{URL: url, Mount: "/kv/", KVVersion: 1, Token: "vault-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, found, err := p.Get("mongodb/password"); err != nil || !found || value != "geheim1" {
+		t.Errorf("got %q %t %v", value, found, err)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	url, _ := vaultStub(t, 2)
+	p, _ := NewVaultProvider(VaultConfig{URL: url, Token: "vault-token"})
+	for _, path := range []string{"broken/value", "failing/value"} {
+		if _, _, err := p.Get(path); err == nil {
+			t.Errorf("%s: no error", path)
+		}
+	}
+	wrongToken, _ := NewVaultProvider(VaultConfig{URL: url, Token: "other"})
+	if _, _, err := wrongToken.Get("mongodb/password"); err == nil {
+		t.Error("forbidden not reported")
+	}
+	if _, err := NewVaultProvider(VaultConfig{}); err == nil {
+		t.Error("missing url accepted")
+	}
+	if _, err := NewVaultProvider(VaultConfig{URL: url, KVVersion: 3}); err == nil {
+		t.Error("wrong kv version accepted")
+	}
+}
+
+func TestVaultTokenSources(t *testing.T) {
+	url, _ := vaultStub(t, 2)
+	file := filepath.Join(t.TempDir(), "token")
+	ioutil.WriteFile(file, []byte("vault-token\n"), 0600)
+	p, err := NewVaultProvider(VaultConfig{URL: url, TokenFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := p.Get("mongodb/password"); !found || err != nil {
+		t.Errorf("token file not used: %v", err)
+	}
+	t.Setenv(vaultTokenEnv, "vault-token")
+	p, _ = NewVaultProvider(VaultConfig{URL: url})
+	if _, found, err := p.Get("mongodb/password"); !found || err != nil {
+		t.Errorf("token env not used: %v", err)
+	}
+}
+
+func TestVaultConcurrent(t *testing.T) {
+	url, _ := vaultStub(t, 2)
+	p, _ := NewVaultProvider(VaultConfig{URL: url, Token: "vault-token"})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, _, err := p.Get("mongodb/password"); err != nil || value != "geheim" {
+				t.Errorf("got %q %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// backupSuffix suffix of the model entries in a backup
+const backupSuffix = ".json"
+
+/*
+BackupSource the documents as source of the backups, one entry per model with all its documents.
+The documents are restored with their ids and revisions, so the etags of the clients are still valid.
+*/
+type BackupSource struct{}
+
+/*
+Tenants all tenants with documents, documents in the trash are not backed up
+*/
+func (BackupSource) Tenants() ([]string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]string, 0, len(tenants))
+	for tenant, models := range tenants {
+		for _, docs := range models {
+			if docs.live() {
+				list = append(list, tenant)
+				break
+			}
+		}
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+/*
+Backup adding every model of the tenant as json array of its documents
+*/
+func (BackupSource) Backup(tenant string, add func(name string, size int64, r io.Reader) error) error {
+	mu.RLock()
+	names := make([]string, 0, len(tenants[tenant]))
+	for model, docs := range tenants[tenant] {
+		if docs.live() {
+			names = append(names, model)
+		}
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+	for _, model := range names {
+		data, err := json.Marshal(List(tenant, model))
+		if err != nil {
+			return fmt.Errorf("can't convert model %s: %s", model, err.Error())
+		}
+		if err := add(model+backupSuffix, int64(len(data)), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Restore replacing all documents of a model of the tenant with the documents of the backup entry,
+the documents in the trash are kept
+*/
+func (BackupSource) Restore(tenant string, name string, r io.Reader) error {
+	model := strings.TrimSuffix(name, backupSuffix)
+	if !strings.HasSuffix(name, backupSuffix) || !ValidModel(model) {
+		return fmt.Errorf("wrong backup entry: %s", name)
+	}
+	var list []Document
+	if err := json.NewDecoder(r).Decode(&list); err != nil {
+		return fmt.Errorf("can't parse model %s: %s", model, err.Error())
+	}
+	docs := make(collection, len(list))
+	for i := range list {
+		if list[i].ID == "" {
+			return fmt.Errorf("document without id in model %s", model)
+		}
+		list[i].Trash = ""
+		docs[list[i].ID] = &list[i]
+	}
+
+	defer writing(tenant)()
+	mu.Lock()
+	defer mu.Unlock()
+	models, ok := tenants[tenant]
+	if !ok {
+		models = make(map[string]collection)
+		tenants[tenant] = models
+	}
+	for id, doc := range models[model] {
+		if _, ok := docs[id]; !ok && doc.Trash != "" {
+			docs[id] = doc
+		}
+	}
+	models[model] = docs
+	snapshot(tenant, model)
+	return nil
+}
+
+/*
+live checks for documents not in the trash
+*/
+func (c collection) live() bool {
+	for _, doc := range c {
+		if doc.Trash == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/willie68/AutoRestIoT/backup"
+)
+
+func TestBackupRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, filepath.Join(dir, "documents"))
+	backup.SetSource(BackupSource{})
+	defer backup.SetSource(nil)
+	for i := 0; i < 3; i++ {
+		if _, err := Create("tenant", "devices", map[string]interface{}{"index": float64(i), "tags": []interface{}{"a"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reading, _ := Create("tenant", "readings", map[string]interface{}{"value": 1.5})
+	Update("tenant", "readings", reading.ID, func(doc Document) (map[string]interface{}, error) {
+		return map[string]interface{}{"value": 2.5}, nil
+	})
+	Create("other", "devices", map[string]interface{}{"index": 9.0})
+
+	info, err := backup.Create(filepath.Join(dir, "backups"), "system", "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := backup.Restore(filepath.Join(dir, "backups", info.Name), "", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[0].Name != "devices.json" {
+		t.Errorf("wrong manifest: %+v", manifest)
+	}
+	for _, model := range []string{"devices", "readings"} {
+		if original, copied := List("tenant", model), List("copy", model); !reflect.DeepEqual(original, copied) {
+			t.Errorf("%s not restored:\n%+v\n%+v", model, original, copied)
+		}
+	}
+	if copied, _ := Get("copy", "readings", reading.ID); copied.Revision != 2 {
+		t.Errorf("revision not restored: %+v", copied)
+	}
+
+	// the restored tenant is stored
+	startTest(t, filepath.Join(dir, "documents"))
+	if len(List("copy", "devices")) != 3 || len(List("other", "devices")) != 1 {
+		t.Error("restored documents not stored")
+	}
+	tenants, _ := BackupSource{}.Tenants()
+	if strings.Join(tenants, ",") != "copy,other,tenant" {
+		t.Errorf("wrong tenants %v", tenants)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	startTest(t, "")
+	source := BackupSource{}
+	tests := []struct{ name, data string }{
+		{"../devices.json", "[]"},
+		{"devices.txt", "[]"},
+		{"devices.json", "{}"},
+		{"devices.json", `[{"revision":1}]`},
+	}
+	for _, test := range tests {
+		if err := source.Restore("tenant", test.name, strings.NewReader(test.data)); err == nil {
+			t.Errorf("%s %s: no error", test.name, test.data)
+		}
+	}
+}
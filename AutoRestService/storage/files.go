@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	snapshotSuffix = ".json"
+	logSuffix      = ".log"
+	// the log of a model is compacted into its snapshot, if it has more entries than the model has documents,
+	// but not before compactMin entries
+	compactMin = 1000
+)
+
+/*
+change an entry of the change log of a model, the actual document or only the id, if it's deleted
+*/
+type change struct {
+	ID  string    `json:"id"`
+	Doc *Document `json:"doc,omitempty"`
+}
+
+// modelKey a model of a tenant
+type modelKey struct {
+	tenant string
+	model  string
+}
+
+// number of entries in the change logs, guarded by mu
+var logged = make(map[modelKey]int)
+
+/*
+load reading all stored documents, one directory per tenant with a snapshot and a change log per model.
+The tenant directories are hex encoded, because a tenant may contain any character.
+Returns the models with a damaged change log, which should be compacted.
+*/
+func load() (map[string]map[string]collection, []modelKey, error) {
+	loaded := make(map[string]map[string]collection)
+	damaged := make([]modelKey, 0)
+	logged = make(map[modelKey]int)
+	if cfg.Dir == "" {
+		return loaded, damaged, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("can't create document storage: %s", err.Error())
+	}
+	dirs, err := ioutil.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read document storage: %s", err.Error())
+	}
+	for _, dir := range dirs {
+		tenant, err := hex.DecodeString(dir.Name())
+		if !dir.IsDir() || err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(cfg.Dir, dir.Name()))
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't read document storage: %s", err.Error())
+		}
+		// the snapshots are loaded before the logs are replayed on them
+		models := make(map[string]collection)
+		for _, suffix := range []string{snapshotSuffix, logSuffix} {
+			for _, file := range files {
+				model := strings.TrimSuffix(file.Name(), suffix)
+				if file.IsDir() || !strings.HasSuffix(file.Name(), suffix) || !ValidModel(model) {
+					continue
+				}
+				if _, ok := models[model]; !ok {
+					models[model] = make(collection)
+				}
+				path := filepath.Join(cfg.Dir, dir.Name(), file.Name())
+				if suffix == snapshotSuffix {
+					err = loadSnapshot(path, models[model])
+				} else {
+					key := modelKey{tenant: string(tenant), model: model}
+					var ok bool
+					logged[key], ok, err = replay(path, models[model])
+					if !ok {
+						log.Alertf("damaged change log of model %s of tenant %s", model, tenant)
+						damaged = append(damaged, key)
+					}
+				}
+				if err != nil {
+					return nil, nil, fmt.Errorf("can't read model %s of tenant %s: %s", model, tenant, err.Error())
+				}
+			}
+		}
+		loaded[string(tenant)] = models
+	}
+	return loaded, damaged, nil
+}
+
+func loadSnapshot(path string, docs collection) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []Document
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for i := range list {
+		docs[list[i].ID] = &list[i]
+	}
+	return nil
+}
+
+/*
+replay applying the change log to the documents, returns the number of entries. Not readable entries,
+like an incomplete last entry after a crash, are skipped and reported with ok false.
+*/
+func replay(path string, docs collection) (count int, ok bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	ok = true
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return count, false, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			var c change
+			if json.Unmarshal(line, &c) != nil || c.ID == "" {
+				ok = false
+			} else if c.Doc == nil {
+				delete(docs, c.ID)
+			} else {
+				docs[c.ID] = c.Doc
+			}
+			// the next entry would be appended to this line
+			if line[len(line)-1] != '\n' {
+				ok = false
+			}
+			count++
+		}
+		if err == io.EOF {
+			return count, ok, nil
+		}
+	}
+}
+
+/*
+persist appending the changed documents of the model to its change log, a document missing in the model
+is logged as deleted. The log is compacted into the snapshot of the model, if it's grown too large.
+Must be called with the lock held.
+*/
+func persist(tenant, model string, ids ...string) {
+	key := modelKey{tenant: tenant, model: model}
+	docs := tenants[tenant][model]
+	for _, id := range ids {
+		if doc, ok := docs[id]; !ok || doc.Trash != "" {
+			removed[key]++
+		}
+	}
+	if cfg.Dir == "" || len(ids) == 0 {
+		return
+	}
+	if n := logged[key] + len(ids); n > compactMin && n > len(docs) {
+		snapshot(tenant, model)
+		return
+	}
+	var buf bytes.Buffer
+	for _, id := range ids {
+		data, err := json.Marshal(change{ID: id, Doc: docs[id]})
+		if err != nil {
+			log.Alertf("can't convert document %s of model %s of tenant %s: %s", id, model, tenant, err.Error())
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	dir := filepath.Join(cfg.Dir, hex.EncodeToString([]byte(tenant)))
+	if err := appendFile(dir, filepath.Join(dir, model+logSuffix), buf.Bytes()); err != nil {
+		// the log may be incomplete now, the snapshot has all documents
+		log.Alertf("can't store model %s of tenant %s: %s", model, tenant, err.Error())
+		snapshot(tenant, model)
+		return
+	}
+	logged[key] += len(ids)
+}
+
+func appendFile(dir, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+snapshot writing all documents of the model and removing its change log, the file is replaced atomically.
+Must be called with the lock held.
+*/
+func snapshot(tenant, model string) {
+	key := modelKey{tenant: tenant, model: model}
+	// the documents may be replaced, like by a restore
+	removed[key]++
+	if cfg.Dir == "" {
+		return
+	}
+	dir := filepath.Join(cfg.Dir, hex.EncodeToString([]byte(tenant)))
+	file := filepath.Join(dir, model+snapshotSuffix)
+	docs := tenants[tenant][model]
+	if len(docs) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Alertf("can't remove model %s of tenant %s: %s", model, tenant, err.Error())
+			return
+		}
+		removeLog(key, filepath.Join(dir, model+logSuffix))
+		return
+	}
+	list := make([]*Document, 0, len(docs))
+	for _, doc := range docs {
+		list = append(list, doc)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Alertf("can't convert model %s of tenant %s: %s", model, tenant, err.Error())
+		return
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Alertf("can't store model %s of tenant %s: %s", model, tenant, err.Error())
+		return
+	}
+	if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		log.Alertf("can't store model %s of tenant %s: %s", model, tenant, err.Error())
+		return
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		log.Alertf("can't store model %s of tenant %s: %s", model, tenant, err.Error())
+		return
+	}
+	// replaying the log on the new snapshot would give the same documents, so a failed remove does no harm
+	removeLog(key, filepath.Join(dir, model+logSuffix))
+}
+
+func removeLog(key modelKey, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Alertf("can't remove change log of model %s of tenant %s: %s", key.model, key.tenant, err.Error())
+		return
+	}
+	delete(logged, key)
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// types of the model fields
+const (
+	TypeString  = "string"
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeTime    = "time"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+/*
+Model definition of a model, documents of models without a definition are stored without validation
+*/
+type Model struct {
+	Name string
+	// Fields the defined fields, other fields of a document are allowed too
+	Fields []Field
+}
+
+/*
+Field a defined field of a model, a time is a RFC 3339 string
+*/
+type Field struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+/*
+FieldError a field of a document not matching the model definition
+*/
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// own lock, the models are validated in the update functions while the documents are locked
+var modelsMutex sync.RWMutex
+var models = make(map[string]Model)
+
+/*
+ValidType checks the type of a field definition
+*/
+func ValidType(t string) bool {
+	switch t {
+	case TypeString, TypeNumber, TypeInteger, TypeBoolean, TypeTime, TypeObject, TypeArray:
+		return true
+	}
+	return false
+}
+
+/*
+SetModels replacing the model definitions
+*/
+func SetModels(list []Model) {
+	defined := make(map[string]Model, len(list))
+	for _, m := range list {
+		defined[m.Name] = m
+	}
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	models = defined
+}
+
+/*
+GetModel getting the definition of a model
+*/
+func GetModel(name string) (Model, bool) {
+	modelsMutex.RLock()
+	defer modelsMutex.RUnlock()
+	m, ok := models[name]
+	return m, ok
+}
+
+/*
+Validate checking the data of a document against the definition of its model, the data has the json types
+*/
+func Validate(model string, data map[string]interface{}) []FieldError {
+	m, ok := GetModel(model)
+	if !ok {
+		return nil
+	}
+	return m.validate(data)
+}
+
+func (m Model) validate(data map[string]interface{}) []FieldError {
+	errs := make([]FieldError, 0)
+	for _, f := range m.Fields {
+		value, ok := data[f.Name]
+		if !ok || value == nil {
+			if f.Required {
+				errs = append(errs, FieldError{Field: f.Name, Message: "missing"})
+			}
+			continue
+		}
+		if !f.matches(value) {
+			errs = append(errs, FieldError{Field: f.Name, Message: fmt.Sprintf("not of type %s", f.Type)})
+		}
+	}
+	return errs
+}
+
+func (f Field) matches(value interface{}) bool {
+	switch f.Type {
+	case TypeString:
+		_, ok := value.(string)
+		return ok
+	case TypeNumber:
+		_, ok := value.(float64)
+		return ok
+	case TypeInteger:
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case TypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case TypeTime:
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	case TypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case TypeArray:
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return false
+}
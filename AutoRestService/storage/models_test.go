@@ -0,0 +1,39 @@
+package storage
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	SetModels([]Model{{Name: "devices", Fields: []Field{
+		{Name: "name", Type: TypeString, Required: true},
+		{Name: "battery", Type: TypeInteger},
+		{Name: "level", Type: TypeNumber},
+		{Name: "active", Type: TypeBoolean},
+		{Name: "seen", Type: TypeTime},
+		{Name: "position", Type: TypeObject},
+		{Name: "tags", Type: TypeArray},
+	}}})
+	defer SetModels(nil)
+
+	valid := map[string]interface{}{
+		"name": "sensor", "battery": 80.0, "level": 1.5, "active": true, "seen": "2026-01-01T12:00:00Z",
+		"position": map[string]interface{}{}, "tags": []interface{}{}, "other": "allowed",
+	}
+	if errs := Validate("devices", valid); len(errs) > 0 {
+		t.Errorf("valid document: %v", errs)
+	}
+	invalid := map[string]interface{}{
+		"battery": 80.5, "level": "1", "active": "true", "seen": "yesterday", "position": []interface{}{}, "tags": "a",
+	}
+	if errs := Validate("devices", invalid); len(errs) != 7 {
+		t.Errorf("wrong errors: %v", errs)
+	}
+	if errs := Validate("devices", map[string]interface{}{"name": nil}); len(errs) != 1 || errs[0].Message != "missing" {
+		t.Errorf("null of a required field: %v", errs)
+	}
+	if errs := Validate("unknown", invalid); len(errs) > 0 {
+		t.Errorf("model without definition validated: %v", errs)
+	}
+	if ValidType("date") || !ValidType(TypeTime) {
+		t.Error("wrong type check")
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/retention"
+)
+
+/*
+RetentionStore deleting the expired documents for the expiry worker. The time of a field is a RFC 3339 string,
+documents without it are not expired by age. Documents in the trash are left to the trash.
+*/
+type RetentionStore struct{}
+
+/*
+expiry the expired documents of a model found by a scan, the oldest first. The following batches of a run are taken
+from it, so the documents are scanned once per run and policy, not once per batch. Every document is checked again
+before it's deleted. Documents expiring after the scan are deleted by the next run.
+*/
+type expiry struct {
+	query expiryQuery
+	docs  []expired
+	// the removed counter of the model at the scan, a list of exceeding documents is outdated by a removal
+	removed uint64
+}
+
+// expiryQuery the policy of a scan, either before or max are set
+type expiryQuery struct {
+	field       string
+	deviceField string
+	before      time.Time
+	max         int
+}
+
+// expired a document of a scan, with the values it was found by
+type expired struct {
+	doc    *Document
+	time   time.Time
+	device string
+}
+
+var (
+	// the last scan by model, guarded by mu
+	expiries = make(map[modelKey]*expiry)
+	// number of removed documents by model, deleted or moved into the trash, guarded by mu
+	removed = make(map[modelKey]uint64)
+)
+
+/*
+Models all models of all tenants with documents
+*/
+func (RetentionStore) Models() ([]retention.Model, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]retention.Model, 0)
+	for tenant, models := range tenants {
+		for model, docs := range models {
+			if docs.live() {
+				list = append(list, retention.Model{Tenant: tenant, Name: model})
+			}
+		}
+	}
+	return list, nil
+}
+
+/*
+DeleteBefore deleting the oldest documents of the model, whose field is before the time, at most limit documents
+*/
+func (RetentionStore) DeleteBefore(model retention.Model, field string, before time.Time, limit int) (int, error) {
+	defer writing(model.Tenant)()
+	mu.Lock()
+	defer mu.Unlock()
+	query := expiryQuery{field: field, before: before}
+	return deleteExpired(model, query, limit, func(docs collection) []expired {
+		list := make([]expired, 0)
+		for _, doc := range docs {
+			if t, ok := doc.timestamp(field); ok && doc.Trash == "" && t.Before(before) {
+				list = append(list, expired{doc: doc, time: t})
+			}
+		}
+		return list
+	}), nil
+}
+
+/*
+DeleteExceeding deleting the oldest documents of every device with more than max documents, at most limit documents.
+Documents without the device field are not counted, documents without the time field are the oldest.
+*/
+func (RetentionStore) DeleteExceeding(model retention.Model, deviceField string, field string, max int, limit int) (int, error) {
+	defer writing(model.Tenant)()
+	mu.Lock()
+	defer mu.Unlock()
+	query := expiryQuery{field: field, deviceField: deviceField, max: max}
+	return deleteExpired(model, query, limit, func(docs collection) []expired {
+		devices := make(map[string][]expired)
+		for _, doc := range docs {
+			device, ok := doc.Data[deviceField]
+			if !ok || device == nil || doc.Trash != "" {
+				continue
+			}
+			t, _ := doc.timestamp(field)
+			key := fmt.Sprint(device)
+			devices[key] = append(devices[key], expired{doc: doc, time: t, device: key})
+		}
+		list := make([]expired, 0)
+		for _, docs := range devices {
+			if len(docs) <= max {
+				continue
+			}
+			sortByTime(docs)
+			list = append(list, docs[:len(docs)-max]...)
+		}
+		return list
+	}), nil
+}
+
+/*
+deleteExpired deleting the oldest expired documents, at most limit. The documents are taken from the last scan
+of the same query, without it they are scanned. Must be called with the lock held.
+*/
+func deleteExpired(model retention.Model, query expiryQuery, limit int, scan func(docs collection) []expired) int {
+	key := modelKey{tenant: model.Tenant, model: model.Name}
+	docs := tenants[model.Tenant][model.Name]
+	e, ok := expiries[key]
+	// documents removed by others may change the exceeding documents of a device
+	if !ok || e.query != query || (query.max > 0 && e.removed != removed[key]) {
+		e = &expiry{query: query, docs: scan(docs)}
+		sortByTime(e.docs)
+		expiries[key] = e
+	}
+	ids := make([]string, 0)
+	for len(e.docs) > 0 && (limit <= 0 || len(ids) < limit) {
+		candidate := e.docs[0]
+		e.docs = e.docs[1:]
+		if current, ok := docs[candidate.doc.ID]; ok && e.valid(candidate, current) {
+			delete(docs, current.ID)
+			ids = append(ids, current.ID)
+		}
+	}
+	persist(model.Tenant, model.Name, ids...)
+	if len(e.docs) == 0 {
+		delete(expiries, key)
+	}
+	e.removed = removed[key]
+	return len(ids)
+}
+
+/*
+valid checks if the document is still expired, like it was found by the scan
+*/
+func (e *expiry) valid(candidate expired, current *Document) bool {
+	if current.Trash != "" {
+		return false
+	}
+	t, ok := current.timestamp(e.query.field)
+	if e.query.max > 0 {
+		device, found := current.Data[e.query.deviceField]
+		return found && device != nil && fmt.Sprint(device) == candidate.device && t.Equal(candidate.time)
+	}
+	return ok && t.Before(e.query.before)
+}
+
+func sortByTime(docs []expired) {
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].time.Equal(docs[j].time) {
+			return docs[i].doc.ID < docs[j].doc.ID
+		}
+		return docs[i].time.Before(docs[j].time)
+	})
+}
+
+/*
+timestamp the time of the field, an empty field is the creation time
+*/
+func (d *Document) timestamp(field string) (time.Time, bool) {
+	if field == "" {
+		return d.Created, true
+	}
+	s, ok := d.Data[field].(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
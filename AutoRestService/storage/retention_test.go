@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/retention"
+)
+
+func TestRetentionStore(t *testing.T) {
+	startTest(t, "")
+	now := time.Now().UTC()
+	for i := 0; i < 4; i++ {
+		Create("tenant", "telemetry", map[string]interface{}{
+			"device": "a",
+			"ts":     now.Add(time.Duration(i-3) * time.Hour).Format(time.RFC3339),
+		})
+	}
+	Create("tenant", "telemetry", map[string]interface{}{"device": "b", "ts": "no time"})
+	trashed, _ := Create("tenant", "telemetry", map[string]interface{}{"device": "a", "ts": now.Add(-24 * time.Hour).Format(time.RFC3339)})
+	TrashDocument("tenant", "telemetry", trashed.ID, "item", nil)
+	Create("other", "telemetry", map[string]interface{}{"ts": now.Add(-24 * time.Hour).Format(time.RFC3339)})
+
+	store := RetentionStore{}
+	models, _ := store.Models()
+	if len(models) != 2 {
+		t.Errorf("wrong models: %+v", models)
+	}
+	model := retention.Model{Tenant: "tenant", Name: "telemetry"}
+	// the oldest first, documents without time and in the trash are kept
+	if n, _ := store.DeleteBefore(model, "ts", now.Add(-90*time.Minute), 1); n != 1 {
+		t.Errorf("%d deleted", n)
+	}
+	if n, _ := store.DeleteBefore(model, "ts", now.Add(-90*time.Minute), 10); n != 1 {
+		t.Errorf("%d deleted", n)
+	}
+	if len(List("tenant", "telemetry")) != 3 || RestoreTrash("tenant", "item") != 1 {
+		t.Error("wrong documents deleted by age")
+	}
+	TrashDocument("tenant", "telemetry", trashed.ID, "item", nil)
+
+	// the newest document of every device is kept
+	if n, _ := store.DeleteExceeding(model, "device", "ts", 1, 10); n != 1 {
+		t.Errorf("%d deleted", n)
+	}
+	list := List("tenant", "telemetry")
+	if len(list) != 2 || list[0].Data["ts"] != now.Format(time.RFC3339) {
+		t.Errorf("wrong documents kept: %+v", list)
+	}
+	if len(List("other", "telemetry")) != 1 {
+		t.Error("documents of another tenant deleted")
+	}
+
+	// without field the creation time is used
+	if n, _ := store.DeleteBefore(model, "", time.Now().Add(time.Second), 10); n != 2 {
+		t.Errorf("%d deleted by creation time", n)
+	}
+}
+
+func TestRetentionBatches(t *testing.T) {
+	startTest(t, t.TempDir())
+	now := time.Now().UTC()
+	ts := func(minutes int) map[string]interface{} {
+		return map[string]interface{}{"device": "a", "ts": now.Add(time.Duration(minutes) * time.Minute).Format(time.RFC3339)}
+	}
+	for i := 0; i < 25; i++ {
+		Create("tenant", "telemetry", ts(-100+i))
+	}
+	store := RetentionStore{}
+	model := retention.Model{Tenant: "tenant", Name: "telemetry"}
+	key := modelKey{tenant: "tenant", model: "telemetry"}
+	if n, _ := store.DeleteBefore(model, "ts", now, 10); n != 10 || len(expiries[key].docs) != 15 {
+		t.Fatalf("%d deleted", n)
+	}
+	// the following batches are taken from the scan, a changed document is checked again
+	late, _ := Create("tenant", "telemetry", ts(-200))
+	changed := List("tenant", "telemetry")[1]
+	Update("tenant", "telemetry", changed.ID, func(doc Document) (map[string]interface{}, error) {
+		return ts(10), nil
+	})
+	if n, _ := store.DeleteBefore(model, "ts", now, 10); n != 10 {
+		t.Errorf("%d deleted", n)
+	}
+	if n, _ := store.DeleteBefore(model, "ts", now, 10); n != 4 || expiries[key] != nil {
+		t.Errorf("%d deleted", n)
+	}
+	if _, err := Get("tenant", "telemetry", late.ID); err != nil {
+		t.Error("document created after the scan deleted")
+	}
+	if _, err := Get("tenant", "telemetry", changed.ID); err != nil {
+		t.Error("changed document deleted")
+	}
+
+	// removing the newest document makes the exceeding documents outdated
+	Delete("tenant", "telemetry", late.ID, nil)
+	for i := 0; i < 2; i++ {
+		Create("tenant", "telemetry", ts(i))
+	}
+	if n, _ := store.DeleteExceeding(model, "device", "ts", 1, 1); n != 1 {
+		t.Errorf("%d deleted", n)
+	}
+	newest := List("tenant", "telemetry")[0]
+	Delete("tenant", "telemetry", newest.ID, nil)
+	if n, _ := store.DeleteExceeding(model, "device", "ts", 1, 1); n != 0 || len(List("tenant", "telemetry")) != 1 {
+		t.Errorf("%d deleted, %d kept", n, len(List("tenant", "telemetry")))
+	}
+}
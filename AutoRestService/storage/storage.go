@@ -0,0 +1,330 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+var log = logging.ServiceLogger{Package: "storage"}
+
+/*
+Config configuration of the document storage
+*/
+type Config struct {
+	// Dir directory of the documents, without a directory the documents are only kept in memory
+	Dir string
+}
+
+/*
+Document a stored document of a model. The revision is counted up with every change, it's the strong etag of the document.
+*/
+type Document struct {
+	ID       string                 `json:"id"`
+	Revision uint64                 `json:"revision"`
+	Created  time.Time              `json:"created"`
+	Modified time.Time              `json:"modified"`
+	Data     map[string]interface{} `json:"data"`
+	// Trash the id of the trash item, if the document is deleted. It's hidden until it's restored or purged.
+	Trash string `json:"trash,omitempty"`
+}
+
+// ErrNotFound the document doesn't exist
+var ErrNotFound = errors.New("document not found")
+
+var modelName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// documents by tenant, model and id
+type collection map[string]*Document
+
+var (
+	mu      sync.RWMutex
+	cfg     Config
+	tenants = make(map[string]map[string]collection)
+)
+
+/*
+ValidModel checks the name of a model, only letters, digits, _ and - are allowed
+*/
+func ValidModel(model string) bool {
+	return modelName.MatchString(model)
+}
+
+/*
+Start loading the stored documents
+*/
+func Start(c Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg = c
+	loaded, damaged, err := load()
+	if err != nil {
+		return err
+	}
+	tenants = loaded
+	expiries = make(map[modelKey]*expiry)
+	removed = make(map[modelKey]uint64)
+	for _, key := range damaged {
+		snapshot(key.tenant, key.model)
+	}
+	return nil
+}
+
+/*
+Create storing a new document with the first revision
+*/
+func Create(tenant, model string, data map[string]interface{}) (Document, error) {
+	defer writing(tenant)()
+	id, err := newID()
+	if err != nil {
+		return Document{}, err
+	}
+	doc, _, err := create(nil, tenant, model, id, data)
+	return doc, err
+}
+
+/*
+CreateWithID storing a new document with the id, if there is no document with this id. So a repeated create,
+like of an import started again, doesn't create a second document. created is false for an existing document,
+even if it's in the trash.
+*/
+func CreateWithID(tenant, model, id string, data map[string]interface{}) (doc Document, created bool, err error) {
+	defer writing(tenant)()
+	if id == "" {
+		return Document{}, false, errors.New("document without id")
+	}
+	return create(nil, tenant, model, id, data)
+}
+
+func create(tx *Transaction, tenant, model, id string, data map[string]interface{}) (Document, bool, error) {
+	if !ValidModel(model) {
+		return Document{}, false, fmt.Errorf("invalid model name: %s", model)
+	}
+	now := time.Now().UTC()
+	doc := &Document{ID: id, Revision: 1, Created: now, Modified: now, Data: copyData(data)}
+
+	mu.Lock()
+	defer mu.Unlock()
+	models, ok := tenants[tenant]
+	if !ok {
+		models = make(map[string]collection)
+		tenants[tenant] = models
+	}
+	docs, ok := models[model]
+	if !ok {
+		docs = make(collection)
+		models[model] = docs
+	}
+	if existing, ok := docs[id]; ok {
+		return existing.copy(), false, nil
+	}
+	tx.record(model, id)
+	docs[id] = doc
+	tx.persist(tenant, model, id)
+	return doc.copy(), true, nil
+}
+
+/*
+Get getting a document
+*/
+func Get(tenant, model, id string) (Document, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	doc, ok := find(tenant, model, id)
+	if !ok {
+		return Document{}, ErrNotFound
+	}
+	return doc.copy(), nil
+}
+
+/*
+List all documents of a model, sorted by the creation time
+*/
+func List(tenant, model string) []Document {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Document, 0, len(tenants[tenant][model]))
+	for _, doc := range tenants[tenant][model] {
+		if doc.Trash == "" {
+			list = append(list, doc.copy())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].before(&list[j])
+	})
+	return list
+}
+
+/*
+Cursor reading the documents of a model page by page in the order of List, so not all documents are copied at once.
+Documents deleted after the start of the cursor are skipped, documents created after it are not read.
+*/
+type Cursor struct {
+	tenant string
+	model  string
+	ids    []string
+}
+
+/*
+NewCursor a cursor on the actual documents of a model
+*/
+func NewCursor(tenant, model string) *Cursor {
+	mu.RLock()
+	defer mu.RUnlock()
+	docs := make([]*Document, 0, len(tenants[tenant][model]))
+	for _, doc := range tenants[tenant][model] {
+		if doc.Trash == "" {
+			docs = append(docs, doc)
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].before(docs[j])
+	})
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return &Cursor{tenant: tenant, model: model, ids: ids}
+}
+
+/*
+Len the number of documents not read yet, the documents deleted in between are skipped by Next
+*/
+func (c *Cursor) Len() int {
+	return len(c.ids)
+}
+
+/*
+Next the next documents, at most n. An empty page is the end.
+*/
+func (c *Cursor) Next(n int) []Document {
+	mu.RLock()
+	defer mu.RUnlock()
+	page := make([]Document, 0, n)
+	for len(page) < n && len(c.ids) > 0 {
+		if doc, ok := find(c.tenant, c.model, c.ids[0]); ok {
+			page = append(page, doc.copy())
+		}
+		c.ids = c.ids[1:]
+	}
+	return page
+}
+
+/*
+Update changing a document atomically. The update function gets the actual document and returns the new data,
+no other change of the document is possible in between. So the preconditions of a request are checked in the
+update function, an error of it is returned unchanged and nothing is stored.
+*/
+func Update(tenant, model, id string, update func(doc Document) (map[string]interface{}, error)) (Document, error) {
+	defer writing(tenant)()
+	return updateDocument(nil, tenant, model, id, update)
+}
+
+func updateDocument(tx *Transaction, tenant, model, id string, update func(doc Document) (map[string]interface{}, error)) (Document, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	doc, ok := find(tenant, model, id)
+	if !ok {
+		return Document{}, ErrNotFound
+	}
+	data, err := update(doc.copy())
+	if err != nil {
+		return Document{}, err
+	}
+	tx.record(model, id)
+	doc.Data = copyData(data)
+	doc.Revision++
+	doc.Modified = time.Now().UTC()
+	tx.persist(tenant, model, id)
+	return doc.copy(), nil
+}
+
+/*
+Delete deleting a document permanently and atomically, an error of the check function is returned unchanged
+and the document is kept
+*/
+func Delete(tenant, model, id string, check func(doc Document) error) (Document, error) {
+	defer writing(tenant)()
+	mu.Lock()
+	defer mu.Unlock()
+	doc, ok := find(tenant, model, id)
+	if !ok {
+		return Document{}, ErrNotFound
+	}
+	if check != nil {
+		if err := check(doc.copy()); err != nil {
+			return Document{}, err
+		}
+	}
+	delete(tenants[tenant][model], id)
+	persist(tenant, model, id)
+	return doc.copy(), nil
+}
+
+/*
+find the document, if it isn't in the trash. Must be called with the lock held.
+*/
+func find(tenant, model, id string) (*Document, bool) {
+	doc, ok := tenants[tenant][model][id]
+	if !ok || doc.Trash != "" {
+		return nil, false
+	}
+	return doc, true
+}
+
+/*
+before the order of the documents, by creation time and id
+*/
+func (d *Document) before(o *Document) bool {
+	if d.Created.Equal(o.Created) {
+		return d.ID < o.ID
+	}
+	return d.Created.Before(o.Created)
+}
+
+/*
+copy the document with its own data, so the caller can't change the stored document
+*/
+func (d *Document) copy() Document {
+	c := *d
+	c.Data = copyData(d.Data)
+	return c
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		c[key] = copyValue(value)
+	}
+	return c
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyData(v)
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, e := range v {
+			c[i] = copyValue(e)
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't create document id: %s", err.Error())
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func startTest(t *testing.T, dir string) {
+	t.Helper()
+	if err := Start(Config{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Start(Config{}) })
+}
+
+func TestCreateAndGet(t *testing.T) {
+	startTest(t, "")
+	doc, err := Create("tenant", "devices", map[string]interface{}{"name": "sensor", "tags": []interface{}{"a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID == "" || doc.Revision != 1 || doc.Created.IsZero() || !doc.Created.Equal(doc.Modified) {
+		t.Errorf("wrong new document: %+v", doc)
+	}
+	// the returned data is a copy
+	doc.Data["tags"].([]interface{})[0] = "changed"
+	stored, err := Get("tenant", "devices", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Data["tags"].([]interface{})[0] != "a" {
+		t.Error("stored document changed by the caller")
+	}
+	if _, err := Get("other", "devices", doc.ID); err != ErrNotFound {
+		t.Errorf("document of another tenant found: %v", err)
+	}
+	if _, err := Create("tenant", "../x", nil); err == nil {
+		t.Error("invalid model name accepted")
+	}
+}
+
+func TestUpdateRevision(t *testing.T) {
+	startTest(t, "")
+	doc, _ := Create("tenant", "devices", map[string]interface{}{"battery": 80.0})
+	updated, err := Update("tenant", "devices", doc.ID, func(actual Document) (map[string]interface{}, error) {
+		if actual.Revision != 1 {
+			t.Errorf("wrong actual revision %d", actual.Revision)
+		}
+		return map[string]interface{}{"battery": 70.0}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Revision != 2 || updated.Data["battery"] != 70.0 || updated.Modified.Before(doc.Modified) {
+		t.Errorf("wrong updated document: %+v", updated)
+	}
+
+	// a failing update function is keeping the document
+	failed := errors.New("precondition failed")
+	if _, err := Update("tenant", "devices", doc.ID, func(Document) (map[string]interface{}, error) {
+		return nil, failed
+	}); err != failed {
+		t.Errorf("error of the update function not returned: %v", err)
+	}
+	if stored, _ := Get("tenant", "devices", doc.ID); stored.Revision != 2 {
+		t.Errorf("revision changed by a failed update: %d", stored.Revision)
+	}
+	if _, err := Update("tenant", "devices", "unknown", nil); err != ErrNotFound {
+		t.Errorf("update of an unknown document: %v", err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	startTest(t, "")
+	doc, _ := Create("tenant", "devices", nil)
+	failed := errors.New("precondition failed")
+	if _, err := Delete("tenant", "devices", doc.ID, func(Document) error { return failed }); err != failed {
+		t.Errorf("error of the check not returned: %v", err)
+	}
+	if _, err := Delete("tenant", "devices", doc.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("tenant", "devices", doc.ID); err != ErrNotFound {
+		t.Error("document not deleted")
+	}
+}
+
+func TestList(t *testing.T) {
+	startTest(t, "")
+	Create("tenant", "devices", nil)
+	Create("tenant", "devices", nil)
+	Create("tenant", "readings", nil)
+	list := List("tenant", "devices")
+	if len(list) != 2 || list[1].Created.Before(list[0].Created) {
+		t.Errorf("wrong list: %+v", list)
+	}
+	if len(List("tenant", "unknown")) != 0 {
+		t.Error("documents of an unknown model")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, dir)
+	doc, _ := Create("tenant/with:chars", "devices", map[string]interface{}{"name": "sensor"})
+	Update("tenant/with:chars", "devices", doc.ID, func(Document) (map[string]interface{}, error) {
+		return map[string]interface{}{"name": "renamed"}, nil
+	})
+	deleted, _ := Create("tenant/with:chars", "devices", nil)
+	Delete("tenant/with:chars", "devices", deleted.ID, nil)
+
+	// a restart
+	startTest(t, dir)
+	stored, err := Get("tenant/with:chars", "devices", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Revision != 2 || stored.Data["name"] != "renamed" {
+		t.Errorf("wrong loaded document: %+v", stored)
+	}
+	if _, err := Get("tenant/with:chars", "devices", deleted.ID); err != ErrNotFound {
+		t.Error("deleted document loaded")
+	}
+}
+
+func TestChangeLog(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, dir)
+	ids := make([]string, 0)
+	for i := 0; i < compactMin; i++ {
+		doc, _ := Create("tenant", "readings", map[string]interface{}{"index": float64(i)})
+		ids = append(ids, doc.ID)
+	}
+	model := filepath.Join(dir, hex.EncodeToString([]byte("tenant")), "readings")
+	// every change is appended to the log, the model isn't rewritten
+	if _, err := os.Stat(model + snapshotSuffix); !os.IsNotExist(err) {
+		t.Errorf("snapshot written: %v", err)
+	}
+	for _, id := range ids[:10] {
+		Delete("tenant", "readings", id, nil)
+	}
+	// the grown log is compacted into the snapshot
+	if _, err := os.Stat(model + snapshotSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if n := logged[modelKey{tenant: "tenant", model: "readings"}]; n != 9 {
+		t.Errorf("%d entries in the log after compaction", n)
+	}
+	Delete("tenant", "readings", ids[10], nil)
+	updated, _ := Update("tenant", "readings", ids[11], func(Document) (map[string]interface{}, error) {
+		return map[string]interface{}{"index": -1.0}, nil
+	})
+
+	startTest(t, dir)
+	if n := len(List("tenant", "readings")); n != compactMin-11 {
+		t.Errorf("%d documents loaded", n)
+	}
+	if doc, _ := Get("tenant", "readings", ids[11]); doc.Revision != updated.Revision || doc.Data["index"] != -1.0 {
+		t.Errorf("wrong loaded document: %+v", doc)
+	}
+}
+
+func TestDamagedLog(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, dir)
+	doc, _ := Create("tenant", "devices", map[string]interface{}{"name": "sensor"})
+	model := filepath.Join(dir, hex.EncodeToString([]byte("tenant")), "devices")
+	// a crash while appending
+	file, _ := os.OpenFile(model+logSuffix, os.O_WRONLY|os.O_APPEND, 0600)
+	file.WriteString(`{"id":"abc","doc":{"id":"abc","rev`)
+	file.Close()
+
+	startTest(t, dir)
+	if _, err := Get("tenant", "devices", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	// the damaged log is compacted, so the next change isn't appended to the incomplete entry
+	created, _ := Create("tenant", "devices", nil)
+	startTest(t, dir)
+	if len(List("tenant", "devices")) != 2 {
+		t.Errorf("wrong documents: %+v", List("tenant", "devices"))
+	}
+	if _, err := Get("tenant", "devices", created.ID); err != nil {
+		t.Error("document after the damaged entry lost")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	startTest(t, "")
+	ids := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		doc, _ := Create("tenant", "devices", map[string]interface{}{"index": float64(i)})
+		ids = append(ids, doc.ID)
+	}
+	cursor := NewCursor("tenant", "devices")
+	Delete("tenant", "devices", ids[3], nil)
+	Create("tenant", "devices", nil)
+	if cursor.Len() != 5 {
+		t.Errorf("%d documents in the cursor", cursor.Len())
+	}
+	read := make([]string, 0)
+	for page := cursor.Next(2); len(page) > 0; page = cursor.Next(2) {
+		for _, doc := range page {
+			read = append(read, doc.ID)
+		}
+	}
+	// deleted and new documents are not read
+	if len(read) != 4 || read[0] != ids[0] || read[3] != ids[4] {
+		t.Errorf("wrong documents read: %v", read)
+	}
+}
+
+func TestCreateWithID(t *testing.T) {
+	startTest(t, "")
+	doc, created, err := CreateWithID("tenant", "devices", "line-1", map[string]interface{}{"name": "sensor"})
+	if err != nil || !created || doc.ID != "line-1" {
+		t.Fatalf("wrong document: %+v, %v", doc, err)
+	}
+	TrashDocument("tenant", "devices", "line-1", "item", nil)
+	// an existing document is kept, even in the trash
+	doc, created, _ = CreateWithID("tenant", "devices", "line-1", map[string]interface{}{"name": "other"})
+	if created || doc.Data["name"] != "sensor" || len(List("tenant", "devices")) != 0 {
+		t.Errorf("document created twice: %+v", doc)
+	}
+	if _, _, err := CreateWithID("tenant", "devices", "", nil); err == nil {
+		t.Error("document without id")
+	}
+}
@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+Writer the changing document functions, of the storage or of a transaction
+*/
+type Writer interface {
+	Create(tenant, model string, data map[string]interface{}) (Document, error)
+	Update(tenant, model, id string, update func(doc Document) (map[string]interface{}, error)) (Document, error)
+	TrashDocument(tenant, model, id, key string, check func(doc Document) error) (Document, error)
+}
+
+/*
+Transaction changes of the documents of a tenant, which are committed or rolled back together. While a transaction
+is running, all other writes to the tenant are waiting. Its changes are stored on commit, so nothing of a
+transaction is stored after a crash. Reads are not isolated, they are seeing the changes before the commit.
+*/
+type Transaction struct {
+	tenant string
+	// the documents before their first change by model and id, nil for created documents
+	before map[string]map[string]*Document
+	// the keys of the documents moved into the trash
+	trashed []string
+	done    bool
+}
+
+// ErrTransactionDone the transaction is already committed or rolled back
+var ErrTransactionDone = errors.New("transaction already finished")
+
+type transactionKey struct{}
+
+var (
+	gatesMu sync.Mutex
+	// the transaction gates by tenant, a transaction holds the write lock, the other writes the read lock
+	gates = make(map[string]*sync.RWMutex)
+)
+
+func gate(tenant string) *sync.RWMutex {
+	gatesMu.Lock()
+	defer gatesMu.Unlock()
+	g, ok := gates[tenant]
+	if !ok {
+		g = &sync.RWMutex{}
+		gates[tenant] = g
+	}
+	return g
+}
+
+/*
+writing waiting for a running transaction of the tenant, the returned function ends the write
+*/
+func writing(tenant string) func() {
+	g := gate(tenant)
+	g.RLock()
+	return g.RUnlock
+}
+
+/*
+Begin starting a transaction of the tenant, waiting for the running writes and transactions of the tenant.
+It must be committed or rolled back.
+*/
+func Begin(tenant string) *Transaction {
+	gate(tenant).Lock()
+	return &Transaction{tenant: tenant, before: make(map[string]map[string]*Document)}
+}
+
+/*
+WithTransaction the context for the writes of the transaction
+*/
+func WithTransaction(ctx context.Context, tx *Transaction) context.Context {
+	return context.WithValue(ctx, transactionKey{}, tx)
+}
+
+/*
+Writes the writer of the context, the transaction of the context or the storage itself
+*/
+func Writes(ctx context.Context) Writer {
+	if tx, ok := ctx.Value(transactionKey{}).(*Transaction); ok {
+		return tx
+	}
+	return storageWriter{}
+}
+
+// storageWriter writing directly to the storage
+type storageWriter struct{}
+
+func (storageWriter) Create(tenant, model string, data map[string]interface{}) (Document, error) {
+	return Create(tenant, model, data)
+}
+
+func (storageWriter) Update(tenant, model, id string, update func(doc Document) (map[string]interface{}, error)) (Document, error) {
+	return Update(tenant, model, id, update)
+}
+
+func (storageWriter) TrashDocument(tenant, model, id, key string, check func(doc Document) error) (Document, error) {
+	return TrashDocument(tenant, model, id, key, check)
+}
+
+/*
+Create creating a document in the transaction
+*/
+func (tx *Transaction) Create(tenant, model string, data map[string]interface{}) (Document, error) {
+	if err := tx.check(tenant); err != nil {
+		return Document{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Document{}, err
+	}
+	doc, _, err := create(tx, tenant, model, id, data)
+	return doc, err
+}
+
+/*
+Update updating a document in the transaction
+*/
+func (tx *Transaction) Update(tenant, model, id string, update func(doc Document) (map[string]interface{}, error)) (Document, error) {
+	if err := tx.check(tenant); err != nil {
+		return Document{}, err
+	}
+	return updateDocument(tx, tenant, model, id, update)
+}
+
+/*
+TrashDocument moving a document into the trash in the transaction, on rollback the item is restored from the trash
+*/
+func (tx *Transaction) TrashDocument(tenant, model, id, key string, check func(doc Document) error) (Document, error) {
+	if err := tx.check(tenant); err != nil {
+		return Document{}, err
+	}
+	doc, err := trashDocument(tx, tenant, model, id, key, check)
+	if err == nil {
+		tx.trashed = append(tx.trashed, key)
+	}
+	return doc, err
+}
+
+/*
+Commit storing the changes of the transaction
+*/
+func (tx *Transaction) Commit() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	mu.Lock()
+	for model, docs := range tx.before {
+		ids := make([]string, 0, len(docs))
+		for id := range docs {
+			ids = append(ids, id)
+		}
+		persist(tx.tenant, model, ids...)
+	}
+	mu.Unlock()
+	tx.finish()
+	return nil
+}
+
+/*
+Rollback resetting the changed documents, the moved documents are removed from the trash
+*/
+func (tx *Transaction) Rollback() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	mu.Lock()
+	for model, docs := range tx.before {
+		for id, doc := range docs {
+			if doc == nil {
+				delete(tenants[tx.tenant][model], id)
+			} else {
+				tenants[tx.tenant][model][id] = doc
+			}
+		}
+	}
+	mu.Unlock()
+	tx.finish()
+	// the trash is calling the storage, so this is done after the end of the transaction
+	for _, key := range tx.trashed {
+		if _, err := trash.Restore(key); err != nil {
+			log.Alertf("can't remove item %s of a rolled back transaction from trash: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+func (tx *Transaction) finish() {
+	tx.done = true
+	gate(tx.tenant).Unlock()
+}
+
+func (tx *Transaction) check(tenant string) error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	if tenant != tx.tenant {
+		return errors.New("transaction of another tenant")
+	}
+	return nil
+}
+
+/*
+record keeping the document before its first change in the transaction, without transaction nothing is done.
+Must be called with the lock held.
+*/
+func (tx *Transaction) record(model, id string) {
+	if tx == nil {
+		return
+	}
+	docs, ok := tx.before[model]
+	if !ok {
+		docs = make(map[string]*Document)
+		tx.before[model] = docs
+	}
+	if _, ok := docs[id]; ok {
+		return
+	}
+	var before *Document
+	if doc, ok := tenants[tx.tenant][model][id]; ok {
+		c := doc.copy()
+		before = &c
+	}
+	docs[id] = before
+}
+
+/*
+persist storing the changed document, in a transaction it's stored on commit. Must be called with the lock held.
+*/
+func (tx *Transaction) persist(tenant, model, id string) {
+	if tx == nil {
+		persist(tenant, model, id)
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	startTest(t, "")
+	kept, _ := Create("tenant", "devices", map[string]interface{}{"name": "sensor"})
+	tx := Begin("tenant")
+	w := Writes(WithTransaction(context.Background(), tx))
+	created, _ := w.Create("tenant", "devices", nil)
+	w.Update("tenant", "devices", kept.ID, func(Document) (map[string]interface{}, error) {
+		return map[string]interface{}{"name": "renamed"}, nil
+	})
+	w.Update("tenant", "devices", kept.ID, func(Document) (map[string]interface{}, error) {
+		return map[string]interface{}{"name": "twice"}, nil
+	})
+	if _, err := w.Create("other", "devices", nil); err == nil {
+		t.Error("document of another tenant created")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("tenant", "devices", created.ID); err != ErrNotFound {
+		t.Error("created document not removed")
+	}
+	if doc, _ := Get("tenant", "devices", kept.ID); doc.Revision != 1 || doc.Data["name"] != "sensor" {
+		t.Errorf("document not reset: %+v", doc)
+	}
+	if _, err := tx.Create("tenant", "devices", nil); err != ErrTransactionDone {
+		t.Errorf("write after rollback: %v", err)
+	}
+	if _, ok := Writes(context.Background()).(*Transaction); ok {
+		t.Error("transaction without context")
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, dir)
+	tx := Begin("tenant")
+	created, _ := tx.Create("tenant", "devices", map[string]interface{}{"name": "sensor"})
+
+	// the other writes of the tenant are waiting for the transaction
+	written := make(chan struct{})
+	go func() {
+		Create("tenant", "devices", nil)
+		close(written)
+	}()
+	if _, err := Create("other", "devices", nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-written:
+		t.Fatal("write during the transaction")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// nothing is stored before the commit
+	if logged[modelKey{tenant: "tenant", model: "devices"}] != 0 {
+		t.Error("transaction stored before commit")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	<-written
+	if err := tx.Commit(); err != ErrTransactionDone {
+		t.Errorf("committed twice: %v", err)
+	}
+
+	startTest(t, dir)
+	if _, err := Get("tenant", "devices", created.ID); err != nil {
+		t.Errorf("committed document not stored: %v", err)
+	}
+	if len(List("tenant", "devices")) != 2 {
+		t.Errorf("wrong documents: %+v", List("tenant", "devices"))
+	}
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"github.com/willie68/AutoRestIoT/trash"
+)
+
+/*
+TrashHandler restoring and purging the documents of a store or a single document in the trash. The documents
+are marked with the id of the trash item.
+*/
+type TrashHandler struct{}
+
+/*
+Restore making the documents of the item visible again
+*/
+func (TrashHandler) Restore(item trash.Item) error {
+	n := RestoreTrash(item.Tenant, item.ID)
+	log.Infof("%d documents of tenant %s restored from trash", n, item.Tenant)
+	return nil
+}
+
+/*
+Purge deleting the documents of the item permanently
+*/
+func (TrashHandler) Purge(item trash.Item) error {
+	n := PurgeTrash(item.Tenant, item.ID)
+	log.Infof("%d documents of tenant %s purged", n, item.Tenant)
+	return nil
+}
+
+/*
+TrashDocument moving a document atomically into the trash, it's hidden with the key until it's restored
+or purged. An error of the check function is returned unchanged and the document is kept.
+*/
+func TrashDocument(tenant, model, id, key string, check func(doc Document) error) (Document, error) {
+	defer writing(tenant)()
+	return trashDocument(nil, tenant, model, id, key, check)
+}
+
+func trashDocument(tx *Transaction, tenant, model, id, key string, check func(doc Document) error) (Document, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	doc, ok := find(tenant, model, id)
+	if !ok {
+		return Document{}, ErrNotFound
+	}
+	if check != nil {
+		if err := check(doc.copy()); err != nil {
+			return Document{}, err
+		}
+	}
+	tx.record(model, id)
+	doc.Trash = key
+	tx.persist(tenant, model, id)
+	return doc.copy(), nil
+}
+
+/*
+TrashTenant moving all documents of the tenant into the trash, returns the number of documents
+*/
+func TrashTenant(tenant, key string) int {
+	return changeTrash(tenant, func(doc *Document) bool {
+		if doc.Trash != "" {
+			return false
+		}
+		doc.Trash = key
+		return true
+	}, false)
+}
+
+/*
+RestoreTrash making the documents of the tenant in the trash with the key visible again, returns the number of documents
+*/
+func RestoreTrash(tenant, key string) int {
+	return changeTrash(tenant, func(doc *Document) bool {
+		if doc.Trash != key {
+			return false
+		}
+		doc.Trash = ""
+		return true
+	}, false)
+}
+
+/*
+PurgeTrash deleting the documents of the tenant in the trash with the key, returns the number of documents
+*/
+func PurgeTrash(tenant, key string) int {
+	return changeTrash(tenant, func(doc *Document) bool {
+		return doc.Trash == key
+	}, true)
+}
+
+/*
+changeTrash changing the documents of all models of the tenant, for which change returns true. With remove
+these documents are deleted. Only the changed documents are stored.
+*/
+func changeTrash(tenant string, change func(doc *Document) bool, remove bool) int {
+	defer writing(tenant)()
+	mu.Lock()
+	defer mu.Unlock()
+	count := 0
+	for model, docs := range tenants[tenant] {
+		changed := make([]string, 0)
+		for id, doc := range docs {
+			if !change(doc) {
+				continue
+			}
+			if remove {
+				delete(docs, id)
+			}
+			changed = append(changed, id)
+		}
+		persist(tenant, model, changed...)
+		count += len(changed)
+	}
+	return count
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestTrashDocument(t *testing.T) {
+	dir := t.TempDir()
+	startTest(t, dir)
+	doc, _ := Create("tenant", "devices", map[string]interface{}{"name": "sensor"})
+	if _, err := TrashDocument("tenant", "devices", doc.ID, "item", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Get("tenant", "devices", doc.ID); err != ErrNotFound {
+		t.Errorf("trashed document found: %v", err)
+	}
+	if _, err := TrashDocument("tenant", "devices", doc.ID, "other", nil); err != ErrNotFound {
+		t.Errorf("trashed twice: %v", err)
+	}
+	if len(List("tenant", "devices")) != 0 {
+		t.Error("trashed document listed")
+	}
+	// the trashed document is stored
+	startTest(t, dir)
+	if n := RestoreTrash("tenant", "other"); n != 0 {
+		t.Errorf("%d documents restored with a wrong key", n)
+	}
+	if n := RestoreTrash("tenant", "item"); n != 1 {
+		t.Errorf("%d documents restored", n)
+	}
+	restored, err := Get("tenant", "devices", doc.ID)
+	if err != nil || restored.Revision != doc.Revision || restored.Data["name"] != "sensor" {
+		t.Errorf("wrong restored document %+v: %v", restored, err)
+	}
+}
+
+func TestTrashTenant(t *testing.T) {
+	startTest(t, "")
+	single, _ := Create("tenant", "devices", nil)
+	Create("tenant", "devices", nil)
+	Create("tenant", "readings", nil)
+	Create("other", "devices", nil)
+	TrashDocument("tenant", "devices", single.ID, "single", nil)
+
+	if n := TrashTenant("tenant", "store"); n != 2 {
+		t.Errorf("%d documents trashed", n)
+	}
+	if len(List("tenant", "devices")) != 0 || len(List("other", "devices")) != 1 {
+		t.Error("wrong documents trashed")
+	}
+	// new documents after deleting the store are kept by the restore
+	Create("tenant", "devices", nil)
+	if n := RestoreTrash("tenant", "store"); n != 2 {
+		t.Errorf("%d documents restored", n)
+	}
+	if len(List("tenant", "devices")) != 2 || len(List("tenant", "readings")) != 1 {
+		t.Error("store not restored")
+	}
+	// the single deleted document stays in the trash
+	if _, err := Get("tenant", "devices", single.ID); err != ErrNotFound {
+		t.Error("document restored with the store")
+	}
+	if n := PurgeTrash("tenant", "single"); n != 1 {
+		t.Errorf("%d documents purged", n)
+	}
+	if n := RestoreTrash("tenant", "single"); n != 0 {
+		t.Error("purged document restored")
+	}
+}
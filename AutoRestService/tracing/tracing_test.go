@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const parentTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+func initTest(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	InitWithExporter(exporter, 1, "autorest-test", "test")
+	t.Cleanup(func() {
+		Shutdown(context.Background())
+		provider = nil
+	})
+	return exporter
+}
+
+func spans(t *testing.T, exporter *tracetest.InMemoryExporter) tracetest.SpanStubs {
+	t.Helper()
+	if err := provider.ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return exporter.GetSpans()
+}
+
+func attr(s tracetest.SpanStub, key string) attribute.Value {
+	for _, kv := range s.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	exporter := initTest(t)
+	var traceID string
+	router := chi.NewRouter()
+	router.Use(Middleware)
+	router.Get("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		traceID, _ = IDs(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/items/42", nil)
+	req.Header.Set("traceparent", "00-"+parentTrace+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	list := spans(t, exporter)
+	if len(list) != 1 {
+		t.Fatalf("got %d spans, want 1", len(list))
+	}
+	s := list[0]
+	if s.Name != "GET /items/{id}" {
+		t.Errorf("span name %s", s.Name)
+	}
+	if s.SpanKind != trace.SpanKindServer {
+		t.Errorf("span kind %s", s.SpanKind)
+	}
+	if s.SpanContext.TraceID().String() != parentTrace || traceID != parentTrace {
+		t.Errorf("trace of the caller not used: %s, handler %s", s.SpanContext.TraceID(), traceID)
+	}
+	if s.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("wrong parent %s", s.Parent.SpanID())
+	}
+	if attr(s, "http.route").AsString() != "/items/{id}" || attr(s, "http.response.status_code").AsInt64() != 500 {
+		t.Errorf("wrong attributes: %v", s.Attributes)
+	}
+	if s.Status.Code != codes.Error {
+		t.Errorf("server error not marked: %v", s.Status)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	exporter := initTest(t)
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ctx, parent := StartSpan(context.Background(), "storage")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	client := &http.Client{Transport: Transport(nil)}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	parent.End()
+
+	list := spans(t, exporter)
+	if len(list) != 2 {
+		t.Fatalf("got %d spans, want 2", len(list))
+	}
+	clientSpan := list[0]
+	if clientSpan.SpanKind != trace.SpanKindClient || clientSpan.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("client span not a child of the parent: %+v", clientSpan)
+	}
+	want := "00-" + clientSpan.SpanContext.TraceID().String() + "-" + clientSpan.SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent %s, want %s", traceparent, want)
+	}
+	if clientSpan.Status.Code != codes.Error {
+		t.Errorf("client error not marked: %v", clientSpan.Status)
+	}
+}
+
+func TestIDsWithoutSpan(t *testing.T) {
+	if traceID, spanID := IDs(context.Background()); traceID != "" || spanID != "" {
+		t.Errorf("ids without span: %s %s", traceID, spanID)
+	}
+}
+
+func TestInitDisabled(t *testing.T) {
+	if err := Init(Config{}, "autorest-test", "test"); err != nil || provider != nil {
+		t.Errorf("disabled tracing initialised: %v", err)
+	}
+}
@@ -0,0 +1,340 @@
+package trash
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/willie68/AutoRestIoT/logging"
+)
+
+var log = logging.ServiceLogger{Package: "trash"}
+
+// kinds of deleted items
+const (
+	KindStore    = "store"
+	KindDocument = "document"
+)
+
+/*
+Config configuration of the trash
+*/
+type Config struct {
+	Retention int
+	Storage   string
+}
+
+/*
+Item a deleted item in the trash, the data of the item stays in the storage until it's purged
+*/
+type Item struct {
+	ID      string          `json:"id"`
+	Kind    string          `json:"kind"`
+	Tenant  string          `json:"tenant"`
+	Ref     string          `json:"ref"`
+	Meta    json.RawMessage `json:"meta,omitempty"`
+	Deleted time.Time       `json:"deleted"`
+	Expires time.Time       `json:"expires"`
+}
+
+/*
+Handler restoring and purging the items of a kind, implemented by the storage
+*/
+type Handler interface {
+	// Restore making the item visible again
+	Restore(item Item) error
+	// Purge deleting the data of the item permanently
+	Purge(item Item) error
+}
+
+// ErrNotFound no item with this id
+var ErrNotFound = fmt.Errorf("item not found in trash")
+
+var (
+	mu       sync.Mutex
+	items    = make(map[string]Item)
+	handlers = make(map[string]Handler)
+	cfg      Config
+	stop     chan struct{}
+)
+
+/*
+Register registering the handler of a kind, items of a kind without handler can't be moved into the trash
+*/
+func Register(kind string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[kind] = handler
+}
+
+/*
+Start loading the stored items and starting the sweeper, which purges expired items every minute
+*/
+func Start(config Config) error {
+	mu.Lock()
+	cfg = config
+	loaded, err := load()
+	if err != nil {
+		mu.Unlock()
+		return err
+	}
+	items = make(map[string]Item, len(loaded))
+	for _, item := range loaded {
+		items[item.ID] = item
+	}
+	mu.Unlock()
+	stop = make(chan struct{})
+	go sweep(stop)
+	return nil
+}
+
+/*
+Stop stopping the sweeper
+*/
+func Stop() {
+	if stop != nil {
+		close(stop)
+		stop = nil
+	}
+}
+
+/*
+SetRetention changing the retention of new items, the expiry of existing items is not changed
+*/
+func SetRetention(retention int) {
+	mu.Lock()
+	defer mu.Unlock()
+	cfg.Retention = retention
+}
+
+/*
+Move moving an item into the trash, meta can be used by the handler for restoring. hide is hiding the data
+of the item in the storage with the id of the new item, an error of it is returned unchanged and the item
+is dropped.
+*/
+func Move(kind string, tenant string, ref string, meta interface{}, hide func(item Item) error) (Item, error) {
+	var data json.RawMessage
+	if meta != nil {
+		var err error
+		if data, err = json.Marshal(meta); err != nil {
+			return Item{}, fmt.Errorf("can't convert meta: %s", err.Error())
+		}
+	}
+	now := time.Now().UTC()
+	mu.Lock()
+	_, ok := handlers[kind]
+	retention := cfg.Retention
+	mu.Unlock()
+	if !ok {
+		return Item{}, fmt.Errorf("no trash handler for %s", kind)
+	}
+	item := Item{
+		ID:      newID(),
+		Kind:    kind,
+		Tenant:  tenant,
+		Ref:     ref,
+		Meta:    data,
+		Deleted: now,
+		Expires: now.Add(time.Duration(retention) * time.Second),
+	}
+	// stored before hiding, so hidden data always has an item
+	if err := persist(item); err != nil {
+		return Item{}, err
+	}
+	if err := hide(item); err != nil {
+		remove(item.ID)
+		return Item{}, err
+	}
+	mu.Lock()
+	items[item.ID] = item
+	mu.Unlock()
+	log.Infof("%s %s of tenant %s moved to trash", kind, ref, tenant)
+	return item, nil
+}
+
+/*
+Get getting an item by its id
+*/
+func Get(id string) (Item, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	item, ok := items[id]
+	return item, ok
+}
+
+/*
+List all items of the tenant, newest first
+*/
+func List(tenant string) []Item {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]Item, 0)
+	for _, item := range items {
+		if item.Tenant == tenant {
+			list = append(list, item)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Deleted.After(list[j].Deleted)
+	})
+	return list
+}
+
+/*
+Restore restoring an item and removing it from the trash
+*/
+func Restore(id string) (Item, error) {
+	return take(id, func(handler Handler, item Item) error {
+		return handler.Restore(item)
+	}, "restored")
+}
+
+/*
+Purge deleting an item permanently
+*/
+func Purge(id string) (Item, error) {
+	return take(id, func(handler Handler, item Item) error {
+		return handler.Purge(item)
+	}, "purged")
+}
+
+/*
+take removing an item from the trash after the action of its handler was successful. The item is taken out
+while the handler is running, so it's restored or purged only once, and put back if the handler fails.
+*/
+func take(id string, action func(handler Handler, item Item) error, name string) (Item, error) {
+	mu.Lock()
+	item, ok := items[id]
+	handler, found := handlers[item.Kind]
+	if ok && found {
+		delete(items, id)
+	}
+	mu.Unlock()
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	if !found {
+		return item, fmt.Errorf("no trash handler for %s", item.Kind)
+	}
+	if err := action(handler, item); err != nil {
+		mu.Lock()
+		items[id] = item
+		mu.Unlock()
+		return item, fmt.Errorf("can't %s %s %s: %s", strings.TrimSuffix(name, "d"), item.Kind, item.Ref, err.Error())
+	}
+	remove(id)
+	log.Infof("%s %s of tenant %s %s", item.Kind, item.Ref, item.Tenant, name)
+	return item, nil
+}
+
+func sweep(stop chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		PurgeExpired()
+	}
+}
+
+/*
+PurgeExpired purging all expired items, returns the number of purged items
+*/
+func PurgeExpired() int {
+	now := time.Now()
+	expired := make([]string, 0)
+	mu.Lock()
+	for id, item := range items {
+		if now.After(item.Expires) {
+			expired = append(expired, id)
+		}
+	}
+	mu.Unlock()
+	count := 0
+	for _, id := range expired {
+		if _, err := Purge(id); err != nil {
+			log.Alertf("%s", err.Error())
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+/*
+load reading all stored items, without storage directory the items are only kept in memory
+*/
+func load() ([]Item, error) {
+	if cfg.Storage == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Storage, 0700); err != nil {
+		return nil, fmt.Errorf("can't create trash storage: %s", err.Error())
+	}
+	files, err := ioutil.ReadDir(cfg.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("can't read trash storage: %s", err.Error())
+	}
+	list := make([]Item, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(cfg.Storage, file.Name()))
+		if err != nil {
+			log.Alertf("can't read trash item %s: %s", file.Name(), err.Error())
+			continue
+		}
+		var item Item
+		if err := json.Unmarshal(data, &item); err != nil {
+			log.Alertf("can't parse trash item %s: %s", file.Name(), err.Error())
+			continue
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
+
+func persist(item Item) error {
+	if cfg.Storage == "" {
+		return nil
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(cfg.Storage, item.ID+".json")
+	if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+		return fmt.Errorf("can't store trash item: %s", err.Error())
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return fmt.Errorf("can't store trash item: %s", err.Error())
+	}
+	return nil
+}
+
+func remove(id string) {
+	if cfg.Storage == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(cfg.Storage, id+".json")); err != nil && !os.IsNotExist(err) {
+		log.Alertf("can't remove trash item %s: %s", id, err.Error())
+	}
+}
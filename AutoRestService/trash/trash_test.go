@@ -0,0 +1,131 @@
+package trash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+/*
+testHandler counting the calls, failing if fail is set. It reads the trash like a real handler may do.
+*/
+type testHandler struct {
+	restored int
+	purged   int
+	fail     bool
+}
+
+func (h *testHandler) Restore(item Item) error {
+	// the trash is not locked while the handler is running
+	List(item.Tenant)
+	if h.fail {
+		return fmt.Errorf("failed")
+	}
+	h.restored++
+	return nil
+}
+
+func (h *testHandler) Purge(item Item) error {
+	Get(item.ID)
+	if h.fail {
+		return fmt.Errorf("failed")
+	}
+	h.purged++
+	return nil
+}
+
+func startTest(t *testing.T, dir string, retention int) *testHandler {
+	t.Helper()
+	handler := &testHandler{}
+	Register("test", handler)
+	if err := Start(Config{Retention: retention, Storage: dir}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Stop)
+	return handler
+}
+
+func hidden(item Item) error {
+	return nil
+}
+
+func TestMoveAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	handler := startTest(t, dir, 3600)
+	hiddenID := ""
+	item, err := Move("test", "tenant", "ref", map[string]string{"model": "devices"}, func(item Item) error {
+		hiddenID = item.ID
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hiddenID != item.ID || item.Expires.Sub(item.Deleted) != time.Hour || string(item.Meta) != `{"model":"devices"}` {
+		t.Errorf("wrong item: %+v", item)
+	}
+	// the items are stored
+	Stop()
+	handler = startTest(t, dir, 3600)
+	if list := List("tenant"); len(list) != 1 || list[0].ID != item.ID {
+		t.Fatalf("wrong items: %+v", list)
+	}
+	if len(List("other")) != 0 {
+		t.Error("items of another tenant listed")
+	}
+	if _, err := Restore(item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if handler.restored != 1 {
+		t.Errorf("handler called %d times", handler.restored)
+	}
+	if _, err := Restore(item.ID); err != ErrNotFound {
+		t.Errorf("restored twice: %v", err)
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	handler := startTest(t, "", 3600)
+	if _, err := Move("unknown", "tenant", "ref", nil, hidden); err == nil {
+		t.Error("moved without handler")
+	}
+	if _, err := Move("test", "tenant", "ref", nil, func(item Item) error { return fmt.Errorf("not found") }); err == nil {
+		t.Error("hide error not returned")
+	}
+	if len(List("tenant")) != 0 {
+		t.Error("item kept after failed hide")
+	}
+
+	item, _ := Move("test", "tenant", "ref", nil, hidden)
+	handler.fail = true
+	if _, err := Purge(item.ID); err == nil {
+		t.Error("failed purge not returned")
+	}
+	// the item is put back after a failed purge
+	if _, ok := Get(item.ID); !ok {
+		t.Error("item lost after failed purge")
+	}
+
+	mu.Lock()
+	items["lost"] = Item{ID: "lost", Kind: "removed", Tenant: "tenant"}
+	mu.Unlock()
+	if _, err := Purge("lost"); err == nil {
+		t.Error("purged without handler")
+	}
+	if _, ok := Get("lost"); !ok {
+		t.Error("item without handler removed")
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	handler := startTest(t, "", 0)
+	Move("test", "tenant", "expired", nil, hidden)
+	SetRetention(3600)
+	Move("test", "tenant", "kept", nil, hidden)
+	time.Sleep(time.Millisecond)
+	if n := PurgeExpired(); n != 1 || handler.purged != 1 {
+		t.Errorf("%d purged, handler called %d times", n, handler.purged)
+	}
+	if list := List("tenant"); len(list) != 1 || list[0].Ref != "kept" {
+		t.Errorf("wrong items: %+v", list)
+	}
+}